@@ -0,0 +1,195 @@
+package activity_db
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+// Events record what happened on the site as it happens. Ref is what the
+// event is about, an article key or an album name, so readers can check
+// they're still allowed to see it.
+type Event struct {
+	ID    uint      `gorm:"primaryKey" json:"id"`
+	Type  string    `gorm:"index" json:"type"`
+	Time  time.Time `gorm:"index" json:"time"`
+	Actor string    `json:"actor"`
+	Title string    `json:"title"`
+	Ref   string    `gorm:"index" json:"ref"`
+	Count int       `json:"count,omitempty"`
+}
+
+// When each member last looked at the activity page.
+type Visit struct {
+	Username string    `gorm:"primaryKey" json:"username"`
+	Seen     time.Time `json:"seen"`
+}
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&Event{}, &Visit{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+func Record(eventType string, actor string, title string, ref string, count int) {
+	gdb := getDB()
+	if gdb == nil {
+		return
+	}
+
+	event := &Event{Type: eventType, Time: time.Now(), Actor: actor, Title: title, Ref: ref, Count: count}
+	if err := gdb.Create(event).Error; err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func HasEvents() bool {
+	gdb := getDB()
+	if gdb == nil {
+		return false
+	}
+
+	var count int64
+	if err := gdb.Model(&Event{}).Count(&count).Error; err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+
+	return count > 0
+}
+
+// Adds events reconstructed from what is already on the site.
+func Backfill(events []*Event) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("activity database not available")
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	for _, event := range events {
+		event.ID = 0
+	}
+
+	return gdb.CreateInBatches(&events, 100).Error
+}
+
+// Points events at an article's new key after it is renamed.
+func RenameRef(eventType string, from string, to string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("activity database not available")
+	}
+
+	return gdb.Model(&Event{}).Where("type = ? AND ref = ?", eventType, from).Update("ref", to).Error
+}
+
+// Drops the events about an article once it is purged.
+func DeleteRef(eventType string, ref string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("activity database not available")
+	}
+
+	return gdb.Where("type = ? AND ref = ?", eventType, ref).Delete(&Event{}).Error
+}
+
+// Returns up to limit events of the given types, or of any type when none
+// are given, newest first, starting after the event at (beforeTime,
+// beforeID). A zero beforeTime starts at the newest event.
+func GetEvents(beforeTime time.Time, beforeID uint, types []string, limit int) []*Event {
+	events := make([]*Event, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return events
+	}
+
+	query := gdb.Order("time desc").Order("id desc").Limit(limit)
+	if !beforeTime.IsZero() {
+		query = query.Where("time < ? OR (time = ? AND id < ?)", beforeTime, beforeTime, beforeID)
+	}
+	if len(types) > 0 {
+		query = query.Where("type IN ?", types)
+	}
+	if err := query.Find(&events).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return events
+}
+
+// Returns when the member last looked, the zero time if never.
+func LastSeen(username string) time.Time {
+	gdb := getDB()
+	if gdb == nil || len(username) == 0 {
+		return time.Time{}
+	}
+
+	visit := &Visit{Username: username}
+	if err := gdb.Where("username = ?", username).First(visit).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Error(err.Error())
+	}
+
+	return visit.Seen
+}
+
+// Returns when the member last looked, the zero time if never, and records
+// that they are looking now.
+func MarkSeen(username string) time.Time {
+	gdb := getDB()
+	if gdb == nil || len(username) == 0 {
+		return time.Time{}
+	}
+
+	visit := &Visit{Username: username}
+	if err := gdb.Where("username = ?", username).First(visit).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Error(err.Error())
+	}
+	seen := visit.Seen
+
+	visit.Seen = time.Now()
+	if err := gdb.Save(visit).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return seen
+}
+
+// Credits a deleted member's activity to another name and forgets when they
+// last visited, as part of the caller's transaction.
+func ReassignUser(tx *gorm.DB, from string, to string) error {
+	// Nothing to reassign before the first event is recorded
+	if !tx.Migrator().HasTable(&Event{}) {
+		return nil
+	}
+
+	if err := tx.Model(&Event{}).Where("actor = ?", from).Update("actor", to).Error; err != nil {
+		return err
+	}
+	return tx.Where("username = ?", from).Delete(&Visit{}).Error
+}
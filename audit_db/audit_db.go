@@ -0,0 +1,177 @@
+package audit_db
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+type AuditEvent struct {
+	ID      uint      `gorm:"primaryKey" json:"id"`
+	Time    time.Time `gorm:"index" json:"time"`
+	Action  string    `gorm:"index" json:"action"`
+	Actor   string    `gorm:"index" json:"actor"`
+	IP      string    `json:"ip"`
+	Details string    `json:"details"`
+}
+
+type AuditQuery struct {
+	Action string
+	Actor  string
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
+const (
+	ActionLogin                = "login"
+	ActionLoginFailed          = "login_failed"
+	ActionLockout              = "lockout"
+	ActionUserCreated          = "user_created"
+	ActionInviteCreated        = "invite_created"
+	ActionUserDisabled         = "user_disabled"
+	ActionUserEnabled          = "user_enabled"
+	ActionUserDeleted          = "user_deleted"
+	ActionImpersonationStarted = "impersonation_started"
+	ActionImpersonationEnded   = "impersonation_ended"
+	ActionImpersonatedRequest  = "impersonated_request"
+	ActionArticleCreated       = "article_created"
+	ActionArticleEdited        = "article_edited"
+	ActionArticleDeleted       = "article_deleted"
+	ActionArticleRestored      = "article_restored"
+	ActionArticlePurged        = "article_purged"
+	ActionMediaCleaned         = "media_cleaned"
+	ActionTagRenamed           = "tag_renamed"
+	ActionTagDeleted           = "tag_deleted"
+	ActionNowUpdated           = "now_updated"
+	ActionCategoryCreated      = "category_created"
+	ActionCategoryDeleted      = "category_deleted"
+	ActionAlbumCoverChanged    = "album_cover_changed"
+	ActionPhotoEdited          = "photo_edited"
+	ActionAlbumPublishing      = "album_publishing"
+	ActionHoldPlaced           = "hold_placed"
+	ActionHoldLifted           = "hold_lifted"
+	ActionDuplicatesRemoved    = "duplicates_removed"
+	ActionGalleryReindex       = "gallery_reindex"
+	ActionBackupCreated        = "backup_created"
+	ActionBackupRestored       = "backup_restored"
+	ActionLogLevelChanged      = "log_level_changed"
+	ActionMaintenanceScheduled = "maintenance_scheduled"
+	ActionBannerPosted         = "banner_posted"
+	ActionGuestbookApproved    = "guestbook_approved"
+	ActionGuestbookRejected    = "guestbook_rejected"
+	ActionSmartAlbumSaved      = "smart_album_saved"
+	ActionSmartAlbumDeleted    = "smart_album_deleted"
+)
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&AuditEvent{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+// Audit events are also written to the application log so they survive a
+// database failure.
+func Record(action string, actor string, ip string, details string) {
+	logger.Info("Audit", "action", action, "username", actor, "ip", ip, "details", details)
+
+	gdb := getDB()
+	if gdb == nil {
+		return
+	}
+
+	event := &AuditEvent{Time: time.Now(), Action: action, Actor: actor, IP: ip, Details: details}
+	if err := gdb.Create(event).Error; err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func GetEvents(q *AuditQuery) ([]*AuditEvent, int64) {
+	events := make([]*AuditEvent, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return events, 0
+	}
+
+	query := gdb.Model(&AuditEvent{})
+	if len(q.Action) > 0 {
+		query = query.Where("action = ?", q.Action)
+	}
+	if len(q.Actor) > 0 {
+		query = query.Where("actor = ?", q.Actor)
+	}
+	if !q.Since.IsZero() {
+		query = query.Where("time >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		query = query.Where("time <= ?", q.Until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.Error(err.Error())
+		return events, 0
+	}
+
+	if q.Limit <= 0 || q.Limit > 500 {
+		q.Limit = 100
+	}
+
+	if err := query.Order("time desc").Offset(q.Offset).Limit(q.Limit).Find(&events).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return events, total
+}
+
+// Checks the shared database can be opened and queried.
+func Ping() error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("database not available")
+	}
+
+	return gdb.Exec("SELECT 1").Error
+}
+
+// Runs SQLite's integrity check over the shared database.
+func IntegrityCheck() error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("database not available")
+	}
+
+	var result string
+	if err := gdb.Raw("PRAGMA integrity_check").Scan(&result).Error; err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New(result)
+	}
+
+	return nil
+}
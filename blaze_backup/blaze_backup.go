@@ -0,0 +1,247 @@
+package blaze_backup
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_http"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+)
+
+var logger = blaze_log.GetLogger()
+
+// Snapshots are taken with VACUUM INTO, which writes a consistent copy of the
+// live database without stopping the server. Restores are staged next to the
+// database and swapped in at the next startup, before anything opens it.
+type Backup struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+const (
+	dbPath      = blaze_db.Path
+	backupDir   = "../backups"
+	restorePath = "../blazemarker.db.restore"
+)
+
+var backupName_re = regexp.MustCompile(`^blazemarker-\d{8}-\d{6}\.db$`)
+
+var backupMutex sync.Mutex
+
+// Uploads can be large, so they get much longer than other requests.
+var uploadClient = blaze_http.NewClient(30 * time.Minute)
+
+func CreateBackup() (*Backup, error) {
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+
+	gdb := blaze_db.GetDB()
+	if gdb == nil {
+		return nil, errors.New("database not available")
+	}
+
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	now := time.Now()
+	name := "blazemarker-" + now.Format("20060102-150405") + ".db"
+	path := filepath.Join(backupDir, name)
+
+	if err := gdb.Exec("VACUUM INTO ?", path).Error; err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	logger.Info("Database backup created", "name", name, "size", info.Size())
+
+	return &Backup{Name: name, Time: now, Size: info.Size()}, nil
+}
+
+// Lists snapshots, newest first.
+func ListBackups() []*Backup {
+	backups := make([]*Backup, 0)
+
+	files, err := os.ReadDir(backupDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return backups
+	}
+
+	for _, file := range files {
+		if file.IsDir() || backupName_re.FindStringIndex(file.Name()) == nil {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		backupTime, _ := time.ParseInLocation("20060102-150405", file.Name()[len("blazemarker-"):len(file.Name())-len(".db")], time.Local)
+		backups = append(backups, &Backup{Name: file.Name(), Time: backupTime, Size: info.Size()})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+
+	return backups
+}
+
+func pruneBackups(keep int) {
+	backups := ListBackups()
+	for _, backup := range backups[min(keep, len(backups)):] {
+		if err := os.Remove(filepath.Join(backupDir, backup.Name)); err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		logger.Info("Database backup pruned", "name", backup.Name)
+	}
+}
+
+// Copies a snapshot to each configured off-site destination.
+func uploadBackup(backup *Backup) error {
+	return errors.Join(uploadWebDAV(backup), uploadS3(backup))
+}
+
+// Copies a snapshot to a WebDAV collection when BLAZEMARKER_BACKUP_WEBDAV_URL
+// is set, authenticating with BLAZEMARKER_BACKUP_WEBDAV_USER and
+// BLAZEMARKER_BACKUP_WEBDAV_PASSWORD.
+func uploadWebDAV(backup *Backup) error {
+	webdavURL := os.Getenv("BLAZEMARKER_BACKUP_WEBDAV_URL")
+	if len(webdavURL) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(backupDir, backup.Name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequest(http.MethodPut, webdavURL+"/"+backup.Name, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = backup.Size
+	if user := os.Getenv("BLAZEMARKER_BACKUP_WEBDAV_USER"); len(user) > 0 {
+		req.SetBasicAuth(user, os.Getenv("BLAZEMARKER_BACKUP_WEBDAV_PASSWORD"))
+	}
+
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("backup upload failed: " + resp.Status)
+	}
+
+	logger.Info("Database backup uploaded to WebDAV", "name", backup.Name)
+
+	return nil
+}
+
+// Takes a snapshot every interval, keeping the newest BLAZEMARKER_BACKUP_KEEP
+// (default keep) snapshots.
+func StartBackups(interval time.Duration, keep int) {
+	if value := os.Getenv("BLAZEMARKER_BACKUP_KEEP"); len(value) > 0 {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			keep = parsed
+		} else {
+			logger.Error("Invalid BLAZEMARKER_BACKUP_KEEP", "value", value)
+		}
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			backup, err := CreateBackup()
+			if err != nil {
+				continue
+			}
+			if err := uploadBackup(backup); err != nil {
+				logger.Error(err.Error(), "name", backup.Name)
+			}
+			pruneBackups(keep)
+		}
+	}()
+}
+
+// Stages a snapshot to replace the database at the next startup.
+func StageRestore(name string) error {
+	if backupName_re.FindStringIndex(name) == nil {
+		return errors.New("invalid backup name: " + name)
+	}
+
+	src, err := os.Open(filepath.Join(backupDir, name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(restorePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(restorePath)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(restorePath)
+		return err
+	}
+
+	logger.Info("Database restore staged", "name", name)
+
+	return nil
+}
+
+// Swaps a staged restore into place. Must run before any module opens the
+// database. The replaced database is kept in the backup directory.
+func ApplyPendingRestore() error {
+	if _, err := os.Stat(restorePath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return err
+	}
+
+	replaced := filepath.Join(backupDir, "pre-restore-"+time.Now().Format("20060102-150405")+".db")
+	if err := os.Rename(dbPath, replaced); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Rename(restorePath, dbPath); err != nil {
+		return err
+	}
+
+	logger.Info("Database restored", "replaced", replaced)
+
+	return nil
+}
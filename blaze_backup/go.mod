@@ -0,0 +1,22 @@
+module github.com/jeffereydecker/blazemarker/blaze_backup
+
+go 1.22.5
+
+require (
+	github.com/jeffereydecker/blazemarker/blaze_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/blaze_http v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/blaze_log v0.0.0-20240721023413-f4c6ed51da8c
+)
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	gorm.io/driver/sqlite v1.5.6 // indirect
+	gorm.io/gorm v1.25.11 // indirect
+)
+
+replace github.com/jeffereydecker/blazemarker/blaze_db => ../blaze_db
+
+replace github.com/jeffereydecker/blazemarker/blaze_http => ../blaze_http
@@ -0,0 +1,118 @@
+package blaze_backup
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Copies a snapshot to an S3 bucket, or any S3 compatible store, when
+// BLAZEMARKER_BACKUP_S3_BUCKET is set. BLAZEMARKER_BACKUP_S3_REGION defaults
+// to us-east-1 and BLAZEMARKER_BACKUP_S3_ENDPOINT to AWS's endpoint for the
+// region. Credentials come from BLAZEMARKER_BACKUP_S3_ACCESS_KEY and
+// BLAZEMARKER_BACKUP_S3_SECRET_KEY. Requests are signed with Signature
+// Version 4 and the body is sent unsigned, so the endpoint must use HTTPS.
+func uploadS3(backup *Backup) error {
+	bucket := os.Getenv("BLAZEMARKER_BACKUP_S3_BUCKET")
+	if len(bucket) == 0 {
+		return nil
+	}
+
+	region := os.Getenv("BLAZEMARKER_BACKUP_S3_REGION")
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	endpoint := strings.TrimSuffix(os.Getenv("BLAZEMARKER_BACKUP_S3_ENDPOINT"), "/")
+	if len(endpoint) == 0 {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	if !strings.HasPrefix(endpoint, "https://") {
+		return errors.New("S3 backup endpoint must use https: " + endpoint)
+	}
+
+	accessKey := os.Getenv("BLAZEMARKER_BACKUP_S3_ACCESS_KEY")
+	secretKey := os.Getenv("BLAZEMARKER_BACKUP_S3_SECRET_KEY")
+	if len(accessKey) == 0 || len(secretKey) == 0 {
+		return errors.New("S3 backup credentials are not set")
+	}
+
+	f, err := os.Open(filepath.Join(backupDir, backup.Name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Path style, which S3 compatible stores support too
+	req, err := http.NewRequest(http.MethodPut, endpoint+"/"+bucket+"/"+backup.Name, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = backup.Size
+	signS3Request(req, region, accessKey, secretKey, time.Now())
+
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("S3 backup upload failed: " + resp.Status)
+	}
+
+	logger.Info("Database backup uploaded to S3", "name", backup.Name, "bucket", bucket)
+
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Adds an AWS Signature Version 4 Authorization header. Backup names and
+// bucket names need no escaping, so the request path is used as is.
+func signS3Request(req *http.Request, region string, accessKey string, secretKey string, now time.Time) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	scope := date + "/" + region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
@@ -0,0 +1,50 @@
+package blaze_db
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+// Path is the site database, relative to the server's working directory.
+const Path = "../blazemarker.db"
+
+// Every package shares this one handle, so the process has a single
+// connection pool on the database file. SQLite allows one writer at a time:
+// the busy timeout makes a second writer wait for the lock rather than fail
+// with SQLITE_BUSY, and immediate transactions take the write lock up front so
+// two transactions can't deadlock upgrading from a read.
+const dsn = Path + "?_busy_timeout=5000&_txlock=immediate"
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	var err error
+
+	path := dsn
+	// Tests get a private in-memory database, shared by their connections
+	if testing.Testing() {
+		path = "file:blazemarker?mode=memory&cache=shared&_busy_timeout=5000"
+	}
+
+	db, err = gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		logger.Error(err.Error())
+		db = nil
+	}
+}
+
+// GetDB returns the shared handle, or nil if the database can't be opened.
+func GetDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
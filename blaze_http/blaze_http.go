@@ -0,0 +1,82 @@
+package blaze_http
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Every outbound request goes through a client made here, so none can hang
+// forever on a slow server. Clients for configured destinations, such as the
+// backup target, honour HTTPS_PROXY, HTTP_PROXY and NO_PROXY. Clients for URLs
+// members supply only connect to public addresses, directly, so they can't be
+// used to reach the server's own network.
+
+const (
+	dialTimeout           = 10 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	responseHeaderTimeout = 30 * time.Second
+	idleConnTimeout       = 90 * time.Second
+	maxRedirects          = 5
+)
+
+func newTransport() *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: dialTimeout}).DialContext,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		IdleConnTimeout:       idleConnTimeout,
+		MaxIdleConns:          10,
+	}
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errors.New("too many redirects")
+	}
+	return nil
+}
+
+// Returns a client for destinations the site is configured with. timeout
+// bounds the whole request, including reading the response.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     newTransport(),
+		CheckRedirect: checkRedirect,
+	}
+}
+
+// Returns a client for URLs members supply. Loopback, private, link local and
+// unspecified addresses are refused when connecting, which also covers
+// redirects and names that resolve to them.
+func NewPublicClient(timeout time.Duration) *http.Client {
+	transport := newTransport()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !IsPublicIP(net.ParseIP(host)) {
+				return errors.New("address not allowed: " + host)
+			}
+			return nil
+		},
+	}).DialContext
+
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}
+}
+
+func IsPublicIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsUnspecified()
+}
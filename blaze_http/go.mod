@@ -0,0 +1,3 @@
+module github.com/jeffereydecker/blazemarker/blaze_http
+
+go 1.22.5
@@ -1,30 +1,97 @@
 package blaze_log
 
 import (
+	"errors"
+	"io"
 	"log"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"testing"
 )
 
 var (
 	logger *slog.Logger = nil
 	once   sync.Once
+
+	logFilePath = "../logs/blazemarker.log"
+
+	// Changed at runtime with SetLevel
+	level = new(slog.LevelVar)
 )
 
+// Logging is configured from the environment:
+//
+//	BLAZEMARKER_LOG_LEVEL        debug (default), info, warn or error
+//	BLAZEMARKER_LOG_OUTPUT       file (default), stdout or both
+//	BLAZEMARKER_LOG_MAX_SIZE_MB  rotate the file past this size, default 100
+//	BLAZEMARKER_LOG_MAX_AGE_DAYS remove rotated files older than this, default 30
+//	BLAZEMARKER_LOG_MAX_BACKUPS  keep at most this many rotated files, default 10
+//
+// The file is also rotated daily.
+func envInt(name string, defaultValue int) int {
+	if value := os.Getenv(name); len(value) > 0 {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			return parsed
+		}
+		log.Println("invalid", name, value)
+	}
+	return defaultValue
+}
+
 func InitializeLogOnce() {
 
 	if logger == nil {
-		f, err := os.OpenFile("../logs/blazemarker.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			log.Fatal("error opening log file: ", err.Error())
+		if err := SetLevel(os.Getenv("BLAZEMARKER_LOG_LEVEL")); err != nil {
+			log.Println(err.Error())
 		}
 
-		logger = slog.New(slog.NewJSONHandler(f, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}))
-		logger.Debug("Logging initialized", "AddSource", "true", "Level", "LevelDebug")
+		var w io.Writer
+		output := strings.ToLower(os.Getenv("BLAZEMARKER_LOG_OUTPUT"))
+		// Tests run from each module's directory, where ../logs may not exist
+		if len(output) == 0 && testing.Testing() {
+			output = "stdout"
+		}
+		if output != "stdout" {
+			f, err := newRotatingFile(logFilePath, int64(envInt("BLAZEMARKER_LOG_MAX_SIZE_MB", 100))<<20, envInt("BLAZEMARKER_LOG_MAX_AGE_DAYS", 30), envInt("BLAZEMARKER_LOG_MAX_BACKUPS", 10))
+			if err != nil {
+				log.Fatal("error opening log file: ", err.Error())
+			}
+			w = f
+		}
+		switch output {
+		case "stdout":
+			w = os.Stdout
+		case "both":
+			w = io.MultiWriter(w, os.Stdout)
+		}
+
+		logger = slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{AddSource: true, Level: level, ReplaceAttr: redactAttr})})
+		logger.Debug("Logging initialized", "AddSource", "true", "Level", level.Level().String(), "Output", output)
+	}
+}
+
+// Values logged under these keys, in any case, are never written.
+var redactedKeys = []string{"password", "secret", "token", "authorization"}
 
-		//slog.SetLogLoggerLevel(slog.LevelDebug)
+const redactedValue = "[redacted]"
+
+func isRedacted(key string) bool {
+	for _, redacted := range redactedKeys {
+		if strings.EqualFold(key, redacted) {
+			return true
+		}
 	}
+	return false
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if isRedacted(a.Key) {
+		return slog.String(a.Key, redactedValue)
+	}
+	return a
 }
 
 func GetLogger() *slog.Logger {
@@ -32,3 +99,22 @@ func GetLogger() *slog.Logger {
 
 	return logger
 }
+
+// Accepts debug, info, warn or error in any case. An empty name selects debug.
+func SetLevel(name string) error {
+	if len(name) == 0 {
+		name = "debug"
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return errors.New("unknown log level: " + name)
+	}
+
+	level.Set(l)
+	return nil
+}
+
+func GetLevel() string {
+	return level.Level().String()
+}
@@ -0,0 +1,226 @@
+package blaze_log
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type LogQuery struct {
+	Since     time.Time
+	Until     time.Time
+	Level     string
+	Module    string
+	Username  string
+	RequestID string
+	Text      string
+	Offset    int
+	Limit     int
+}
+
+type LogEntry map[string]any
+
+// Total counts the matches in what was scanned. Truncated is set when the
+// scan stopped at maxLogScanBytes, so older matches weren't counted.
+type LogPage struct {
+	Total     int        `json:"total"`
+	Offset    int        `json:"offset"`
+	Limit     int        `json:"limit"`
+	Truncated bool       `json:"truncated"`
+	Entries   []LogEntry `json:"entries"`
+}
+
+// A query reads at most this much of the log, newest first, and pages no
+// further back than maxLogOffset matches.
+const (
+	maxLogScanBytes = 64 << 20
+	maxLogOffset    = 10000
+)
+
+var logLevels = map[string]int{
+	"DEBUG": 0,
+	"INFO":  1,
+	"WARN":  2,
+	"ERROR": 3,
+}
+
+// The module is the directory of the source file that logged the entry, e.g.
+// gallery_db or index.
+func (e LogEntry) Module() string {
+	source, ok := e["source"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	file, _ := source["file"].(string)
+	return filepath.Base(filepath.Dir(file))
+}
+
+func (e LogEntry) Time() time.Time {
+	value, _ := e["time"].(string)
+	t, _ := time.Parse(time.RFC3339Nano, value)
+	return t
+}
+
+func (e LogEntry) String(key string) string {
+	value, _ := e[key].(string)
+	return value
+}
+
+// Older entries may hold values that are now redacted as they are written.
+func (e LogEntry) redact() LogEntry {
+	for key, value := range e {
+		if isRedacted(key) {
+			e[key] = redactedValue
+		} else if group, ok := value.(map[string]any); ok {
+			LogEntry(group).redact()
+		}
+	}
+	return e
+}
+
+func (q *LogQuery) matches(entry LogEntry) bool {
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		t := entry.Time()
+		if !q.Since.IsZero() && t.Before(q.Since) {
+			return false
+		}
+		if !q.Until.IsZero() && t.After(q.Until) {
+			return false
+		}
+	}
+	if len(q.Level) > 0 && logLevels[entry.String("level")] < logLevels[strings.ToUpper(q.Level)] {
+		return false
+	}
+	if len(q.Module) > 0 && entry.Module() != q.Module {
+		return false
+	}
+	if len(q.Username) > 0 && entry.String("username") != q.Username {
+		return false
+	}
+	if len(q.RequestID) > 0 && entry.String("request_id") != q.RequestID {
+		return false
+	}
+	if len(q.Text) > 0 && !strings.Contains(strings.ToLower(entry.String("msg")), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+// Scans a file for matches, keeping only the newest keep of them, and
+// returns how many it found. At most budget bytes are read from the end of
+// the file.
+func scanLogFile(path string, q *LogQuery, keep int, budget int64) ([]LogEntry, int, int64, error) {
+	matches := make([]LogEntry, 0)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return matches, 0, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return matches, 0, 0, err
+	}
+
+	size := info.Size()
+	partial := size > budget
+	if partial {
+		if _, err := f.Seek(size-budget, io.SeekStart); err != nil {
+			return matches, 0, 0, err
+		}
+		size = budget
+	}
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// Reading from part way through starts mid line
+		if partial {
+			partial = false
+			continue
+		}
+
+		entry := make(LogEntry)
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !q.matches(entry) {
+			continue
+		}
+
+		count = count + 1
+		if keep > 0 {
+			matches = append(matches, entry)
+			if len(matches) > keep {
+				matches = matches[1:]
+			}
+		}
+	}
+
+	return matches, count, size, scanner.Err()
+}
+
+// Scans the current and rotated JSON log files, newest first, and returns
+// one page of matching entries, newest first. Files that ended before Since
+// aren't read, and the scan stops after maxLogScanBytes. Sensitive values
+// logged before they were redacted at write time are redacted here.
+func QueryLog(q *LogQuery) (*LogPage, error) {
+	if q.Limit <= 0 || q.Limit > 1000 {
+		q.Limit = 100
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	if q.Offset > maxLogOffset {
+		q.Offset = maxLogOffset
+	}
+
+	page := &LogPage{Offset: q.Offset, Limit: q.Limit, Entries: make([]LogEntry, 0)}
+
+	// Newest first
+	newest := make([]LogEntry, 0)
+	rotated := rotatedFiles(logFilePath)
+	slices.Reverse(rotated)
+	paths := append([]string{logFilePath}, rotated...)
+
+	var budget int64 = maxLogScanBytes
+	for i, path := range paths {
+		if budget <= 0 {
+			page.Truncated = true
+			break
+		}
+
+		// A file's last entry was written when it was last modified
+		if info, err := os.Stat(path); err == nil && !q.Since.IsZero() && info.ModTime().Before(q.Since) {
+			break
+		}
+
+		matches, count, scanned, err := scanLogFile(path, q, q.Offset+q.Limit-len(newest), budget)
+		if err != nil {
+			// Rotated files may be pruned while we read
+			if i == 0 || !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+
+		page.Total = page.Total + count
+		budget = budget - scanned
+		for j := len(matches) - 1; j >= 0; j-- {
+			newest = append(newest, matches[j])
+		}
+	}
+
+	for i := q.Offset; i < len(newest) && len(page.Entries) < q.Limit; i++ {
+		page.Entries = append(page.Entries, newest[i].redact())
+	}
+
+	return page, nil
+}
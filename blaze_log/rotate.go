@@ -0,0 +1,134 @@
+package blaze_log
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A log file that is renamed to <name>-<timestamp><ext> and reopened once it
+// grows past maxSize or was started on an earlier day. Rotated files beyond
+// maxBackups or older than maxAgeDays are removed.
+type rotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxAgeDays int
+	maxBackups int
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newRotatingFile(path string, maxSize int64, maxAgeDays int, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAgeDays: maxAgeDays, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	r.opened = info.ModTime()
+	if r.size == 0 {
+		r.opened = time.Now()
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.size+int64(len(p)) > r.maxSize || !sameDay(r.opened, time.Now()) {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing entries
+			os.Stderr.WriteString("log rotation failed: " + err.Error() + "\n")
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size = r.size + int64(n)
+	return n, err
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+func rotatedPrefix(path string) (string, string) {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-", ext
+}
+
+func (r *rotatingFile) rotate() error {
+	if r.size == 0 {
+		r.opened = time.Now()
+		return nil
+	}
+
+	prefix, ext := rotatedPrefix(r.path)
+	rotated := prefix + time.Now().Format("20060102-150405") + ext
+
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, rotated); err != nil {
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.prune()
+	return nil
+}
+
+func (r *rotatingFile) prune() {
+	rotated := rotatedFiles(r.path)
+	cutoff := time.Now().AddDate(0, 0, -r.maxAgeDays)
+
+	for i, path := range rotated {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		// rotatedFiles is oldest first
+		if len(rotated)-i > r.maxBackups || info.ModTime().Before(cutoff) {
+			os.Remove(path)
+		}
+	}
+}
+
+// Lists rotated copies of the log file, oldest first.
+func rotatedFiles(path string) []string {
+	prefix, ext := rotatedPrefix(path)
+
+	matches, err := filepath.Glob(prefix + "[0-9]*" + ext)
+	if err != nil {
+		return nil
+	}
+
+	sort.Strings(matches)
+	return matches
+}
@@ -1,10 +1,16 @@
 package blog_db
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"hash/fnv"
 	"html/template"
 	"os"
+	"slices"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/jeffereydecker/blazemarker/blaze_log"
 )
@@ -18,14 +24,166 @@ func (a ByDate) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByDate) Less(i, j int) bool { return a[i].Date > a[j].Date } // Sorting in descending order
 
 type Article struct {
-	ID      uint          `gorm:"primaryKey" json:"id"`
-	Title   string        `json:"title"`
-	Content template.HTML `json:"content"`
-	Author  string        `json:"author"`
-	Date    string        `json:"date"`
+	ID         uint          `gorm:"primaryKey" json:"id"`
+	Title      string        `json:"title"`
+	Content    template.HTML `json:"content"`
+	Author     string        `json:"author"`
+	CoAuthors  []string      `json:"co_authors,omitempty"`
+	Date       string        `json:"date"`
+	Tags       []string      `json:"tags,omitempty"`
+	Category   string        `json:"category,omitempty"`
+	Format     string        `json:"format,omitempty"` // FormatHTML when empty
+	Source     string        `json:"source,omitempty"` // Markdown source
+	Private    bool          `json:"private,omitempty"`
+	SharedWith []string      `json:"shared_with,omitempty"`
+	DeletedAt  *time.Time    `json:"deleted_at,omitempty"` // In the trash when set
 }
 
+func (article *Article) HasAuthor(username string) bool {
+	return len(username) > 0 && (article.Author == username || slices.Contains(article.CoAuthors, username))
+}
+
+// Names every author, e.g. "ann, bob and cat".
+func (article *Article) Byline() string {
+	authors := append([]string{article.Author}, article.CoAuthors...)
+	if len(authors) == 1 {
+		return article.Author
+	}
+	return strings.Join(authors[:len(authors)-1], ", ") + " and " + authors[len(authors)-1]
+}
+
+// Private articles are visible to their authors and the members they are
+// shared with.
+func (article *Article) CanView(username string) bool {
+	return !article.Private || article.HasAuthor(username) || (len(username) > 0 && slices.Contains(article.SharedWith, username))
+}
+
+// Articles are identified by their file name.
+func (article *Article) Key() string {
+	return article.Date + article.Title + article.Author
+}
+
+// A short hash of the key, so permalinks stay unique when titles slugify to
+// the same thing or to nothing at all.
+func (article *Article) permalinkID() string {
+	h := fnv.New32a()
+	h.Write([]byte(article.Key()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (article *Article) permalinkMonth() string {
+	year, month, _ := strings.Cut(article.Date, "-")
+	month, _, _ = strings.Cut(month, "-")
+	return year + "/" + month
+}
+
+// The canonical URL, e.g. /article/2024/07/welcome-to-blazemarker-1a2b3c4d.
+func (article *Article) Permalink() string {
+	slug := article.permalinkID()
+	if title := Slugify(article.Title); len(title) > 0 {
+		slug = title + "-" + slug
+	}
+	return "/article/" + article.permalinkMonth() + "/" + slug
+}
+
+// Finds the article for a permalink by the key hash at the end of the slug.
+// Links made before the hash was added carry only the title: they resolve to
+// the earliest article with that title which the member can see.
+func GetArticleByPermalink(year string, month string, slug string, username string) *Article {
+	articles := GetAllArticles()
+	sort.Slice(articles, func(i, j int) bool { return articles[i].Date < articles[j].Date })
+
+	permalink := "/article/" + year + "/" + month + "/" + slug
+	for _, article := range articles {
+		if article.Permalink() == permalink {
+			return article
+		}
+	}
+
+	for _, article := range articles {
+		if article.permalinkMonth() == year+"/"+month && Slugify(article.Title) == slug && article.CanView(username) {
+			return article
+		}
+	}
+
+	return nil
+}
+
+// Articles in the trash are left out.
+func GetArticle(key string) *Article {
+	if article := readArticle(key); article != nil && article.DeletedAt == nil {
+		return article
+	}
+	return nil
+}
+
+func readArticle(key string) *Article {
+	if len(key) == 0 || strings.ContainsAny(key, "/\\") {
+		return nil
+	}
+
+	jsonData, err := os.ReadFile("../articles/" + key + ".json")
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	article := new(Article)
+	if err := json.Unmarshal(jsonData, article); err != nil {
+		logger.Error(err.Error(), "key", key)
+		return nil
+	}
+	article.Content = SanitizeHTML(article.Content)
+
+	return article
+}
+
+// Saves a new article. An existing article with the same key is never
+// replaced, even one in the trash.
+func CreateArticle(article *Article) error {
+	if _, err := os.Stat("../articles/" + article.Key() + ".json"); err == nil {
+		if GetTrashedArticle(article.Key()) != nil {
+			return errors.New("an article with that title is in the trash: " + article.Title)
+		}
+		return errors.New("an article with that title already exists: " + article.Title)
+	}
+
+	if !SaveArticle(article) {
+		return errors.New("unable to save article: " + article.Title)
+	}
+
+	return nil
+}
+
+// Saves an edited article, removing the old file if its title changed.
+func UpdateArticle(key string, article *Article) error {
+	if article.Key() != key && readArticle(article.Key()) != nil {
+		return errors.New("an article with that title already exists: " + article.Title)
+	}
+
+	if !SaveArticle(article) {
+		return errors.New("unable to save article: " + article.Title)
+	}
+
+	if article.Key() != key {
+		if err := os.Remove("../articles/" + key + ".json"); err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+		renameArticleRefs(key, article)
+	}
+
+	return nil
+}
+
+// Articles in the trash are left out.
 func GetAllArticles() []*Article {
+	return slices.DeleteFunc(readArticles(), func(article *Article) bool { return article.DeletedAt != nil })
+}
+
+func readArticles() []*Article {
 	files, err := os.ReadDir("../articles")
 	if err != nil {
 		logger.Error(err.Error())
@@ -46,6 +204,7 @@ func GetAllArticles() []*Article {
 			logger.Error(err.Error())
 			continue
 		}
+		article.Content = SanitizeHTML(article.Content)
 
 		articles = append(articles, article)
 	}
@@ -65,6 +224,7 @@ func GetIndexArticles() []*Article {
 		logger.Error(err.Error())
 		return nil
 	}
+	article.Content = SanitizeHTML(article.Content)
 
 	articles := make([]*Article, 0)
 
@@ -86,6 +246,7 @@ func GetNowArticles() []*Article {
 		logger.Error(err.Error())
 		return nil
 	}
+	article.Content = SanitizeHTML(article.Content)
 
 	articles := make([]*Article, 0)
 
@@ -100,6 +261,7 @@ func SortByDate(articles []*Article) {
 }
 
 func SaveArticle(article *Article) bool {
+	article.Content = SanitizeHTML(article.Content)
 
 	// Marshal blog entry struct to JSON
 	jsonData, err := json.MarshalIndent(article, "", "    ")
@@ -115,6 +277,118 @@ func SaveArticle(article *Article) bool {
 		logger.Error(err.Error())
 		return (false)
 	}
+	articlesChanged()
 
 	return (true)
 }
+
+func daySeed(day time.Time) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(day.Format("2006-01-02")))
+	return h.Sum64()
+}
+
+// Picks one past article per day. The pick only depends on the date so every
+// visitor sees the same article all day.
+func GetArticleOfTheDay(day time.Time) *Article {
+	today := day.Format("2006-01-02")
+
+	articles := make([]*Article, 0)
+	for _, article := range GetAllArticles() {
+		if article.Date < today && !article.Private {
+			articles = append(articles, article)
+		}
+	}
+
+	if len(articles) == 0 {
+		return nil
+	}
+
+	sort.Slice(articles, func(i, j int) bool {
+		if articles[i].Date != articles[j].Date {
+			return articles[i].Date < articles[j].Date
+		}
+		return articles[i].Title < articles[j].Title
+	})
+
+	return articles[daySeed(day)%uint64(len(articles))]
+}
+
+// Moves every article by one author to another, renaming the article files to
+// match, and drops the author from every article's share list. Returns how
+// many articles were moved and the old and new key of each renamed one.
+// Nothing is written if a renamed article would replace an existing one, and
+// it is safe to retry if it fails part way through.
+func ReassignArticles(from string, to string) (int, map[string]string, error) {
+	count := 0
+	renamed := make(map[string]string)
+
+	files, err := os.ReadDir("../articles")
+	if err != nil {
+		logger.Error(err.Error())
+		return count, renamed, err
+	}
+
+	changed := make(map[string]*Article)
+	for _, file := range files {
+		key := strings.TrimSuffix(file.Name(), ".json")
+
+		jsonData, err := os.ReadFile("../articles/" + file.Name())
+		if err != nil {
+			logger.Error(err.Error())
+			return count, renamed, err
+		}
+
+		article := new(Article)
+		if err := json.Unmarshal(jsonData, article); err != nil {
+			logger.Error(err.Error(), "key", key)
+			continue
+		}
+
+		if !article.HasAuthor(from) && !slices.Contains(article.SharedWith, from) {
+			continue
+		}
+
+		article.SharedWith = slices.DeleteFunc(article.SharedWith, func(name string) bool { return name == from })
+		if article.HasAuthor(from) {
+			if article.Author == from {
+				article.Author = to
+			}
+			article.CoAuthors = slices.DeleteFunc(article.CoAuthors, func(name string) bool { return name == from })
+			if article.Author != to && !slices.Contains(article.CoAuthors, to) {
+				article.CoAuthors = append(article.CoAuthors, to)
+			}
+			count = count + 1
+		}
+		changed[key] = article
+	}
+
+	targets := make(map[string]bool)
+	for key, article := range changed {
+		newKey := article.Key()
+		if newKey == key {
+			continue
+		}
+		if _, err := os.Stat("../articles/" + newKey + ".json"); err == nil || targets[newKey] {
+			return count, renamed, errors.New("an article with that title already exists: " + article.Title)
+		}
+		targets[newKey] = true
+	}
+
+	for key, article := range changed {
+		if !SaveArticle(article) {
+			return count, renamed, errors.New("unable to save article: " + article.Title)
+		}
+
+		if newKey := article.Key(); newKey != key {
+			if err := os.Remove("../articles/" + key + ".json"); err != nil {
+				logger.Error(err.Error())
+				return count, renamed, err
+			}
+			renameArticleRefs(key, article)
+			renamed[key] = newKey
+		}
+	}
+
+	return count, renamed, nil
+}
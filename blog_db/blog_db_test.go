@@ -0,0 +1,142 @@
+package blog_db
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Runs the test from a temporary directory whose ../articles holds the given
+// articles, as the server's working directory would.
+func useTestArticles(t *testing.T, articles []*Article) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, sub := range []string{"articles", "work"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, article := range articles {
+		jsonData, err := json.Marshal(article)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "articles", article.Key()+".json"), jsonData, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "work")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+func readTestArticles(t *testing.T) map[string]*Article {
+	t.Helper()
+
+	articles := make(map[string]*Article)
+	files, err := os.ReadDir("../articles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		jsonData, err := os.ReadFile("../articles/" + file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		article := new(Article)
+		if err := json.Unmarshal(jsonData, article); err != nil {
+			t.Fatal(err)
+		}
+		articles[file.Name()] = article
+	}
+	return articles
+}
+
+func TestReassignArticles(t *testing.T) {
+	tests := []struct {
+		name     string
+		articles []*Article
+		count    int
+		renamed  map[string]string
+		files    []string
+		valid    bool
+	}{
+		{
+			name: "an article by the author is renamed to the new author",
+			articles: []*Article{
+				{Date: "2024-07-01", Title: "Beach", Author: "alice"},
+			},
+			count:   1,
+			renamed: map[string]string{"2024-07-01Beachalice": "2024-07-01Beachbob"},
+			files:   []string{"2024-07-01Beachbob.json"},
+			valid:   true,
+		},
+		{
+			name: "co-authored and shared articles keep their file",
+			articles: []*Article{
+				{Date: "2024-07-01", Title: "Beach", Author: "carol", CoAuthors: []string{"alice"}},
+				{Date: "2024-07-02", Title: "Hike", Author: "carol", SharedWith: []string{"alice"}, Private: true},
+			},
+			count:   1,
+			renamed: map[string]string{},
+			files:   []string{"2024-07-01Beachcarol.json", "2024-07-02Hikecarol.json"},
+			valid:   true,
+		},
+		{
+			name: "a rename onto an existing article writes nothing",
+			articles: []*Article{
+				{Date: "2024-07-01", Title: "Beach", Author: "alice"},
+				{Date: "2024-07-01", Title: "Beach", Author: "bob"},
+				{Date: "2024-07-02", Title: "Hike", Author: "alice"},
+			},
+			renamed: map[string]string{},
+			files:   []string{"2024-07-01Beachalice.json", "2024-07-01Beachbob.json", "2024-07-02Hikealice.json"},
+			valid:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestArticles(t, test.articles)
+
+			count, renamed, err := ReassignArticles("alice", "bob")
+			if test.valid != (err == nil) {
+				t.Fatalf("error = %v, want valid %v", err, test.valid)
+			}
+			if test.valid && count != test.count {
+				t.Errorf("count = %d, want %d", count, test.count)
+			}
+			if !maps.Equal(renamed, test.renamed) {
+				t.Errorf("renamed = %v, want %v", renamed, test.renamed)
+			}
+
+			articles := readTestArticles(t)
+			files := make([]string, 0, len(articles))
+			for file := range articles {
+				files = append(files, file)
+			}
+			slices.Sort(files)
+			if !slices.Equal(files, test.files) {
+				t.Errorf("files = %q, want %q", files, test.files)
+			}
+			for file, article := range articles {
+				if test.valid && (article.HasAuthor("alice") || slices.Contains(article.SharedWith, "alice")) {
+					t.Errorf("%s still credits or shares with alice", file)
+				}
+				if !test.valid && article.Author == "bob" && file != "2024-07-01Beachbob.json" {
+					t.Errorf("%s was changed by a refused reassignment", file)
+				}
+			}
+		})
+	}
+}
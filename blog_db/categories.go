@@ -0,0 +1,248 @@
+package blog_db
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Categories form a tree, e.g. Family > Vacations, stored in
+// ../categories.json. Each article is filed under at most one category by
+// slug; unlike tags, an article in a subcategory also belongs to every
+// category above it.
+
+const categoriesFile = "../categories.json"
+
+type Category struct {
+	Slug   string `json:"slug"`
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+	Path   string `json:"path,omitempty"` // e.g. "Family > Vacations", filled in on read
+}
+
+var (
+	categoriesMutex sync.Mutex
+	slugRe          = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+func Slugify(name string) string {
+	return strings.Trim(slugRe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func readCategories() ([]*Category, error) {
+	categories := make([]*Category, 0)
+
+	jsonData, err := os.ReadFile(categoriesFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return categories, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(jsonData, &categories); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+func writeCategories(categories []*Category) error {
+	for _, category := range categories {
+		category.Path = ""
+	}
+
+	jsonData, err := json.MarshalIndent(categories, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(categoriesFile, jsonData, 0644)
+}
+
+// Follows parents up from slug and returns the categories root first. Stops
+// at a missing parent or a loop.
+func categoryPath(categories []*Category, slug string) []*Category {
+	bySlug := make(map[string]*Category, len(categories))
+	for _, category := range categories {
+		bySlug[category.Slug] = category
+	}
+
+	path := make([]*Category, 0)
+	for category := bySlug[slug]; category != nil && len(path) < len(categories); category = bySlug[category.Parent] {
+		path = append([]*Category{category}, path...)
+	}
+
+	return path
+}
+
+// Returns every category with its Path, ordered so children follow their
+// parent.
+func GetCategories() []*Category {
+	categories, err := readCategories()
+	if err != nil {
+		logger.Error(err.Error())
+		return make([]*Category, 0)
+	}
+
+	for _, category := range categories {
+		names := make([]string, 0)
+		for _, ancestor := range categoryPath(categories, category.Slug) {
+			names = append(names, ancestor.Name)
+		}
+		category.Path = strings.Join(names, " > ")
+	}
+
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Path < categories[j].Path })
+
+	return categories
+}
+
+func GetCategory(slug string) *Category {
+	for _, category := range GetCategories() {
+		if category.Slug == slug {
+			return category
+		}
+	}
+	return nil
+}
+
+// Returns the breadcrumb trail for a category, root first.
+func GetCategoryPath(slug string) []*Category {
+	return categoryPath(GetCategories(), slug)
+}
+
+func GetSubcategories(slug string) []*Category {
+	subcategories := make([]*Category, 0)
+	for _, category := range GetCategories() {
+		if category.Parent == slug {
+			subcategories = append(subcategories, category)
+		}
+	}
+	return subcategories
+}
+
+// Returns the articles filed under the category or any of its descendants.
+func GetArticlesInCategory(slug string) []*Article {
+	categories := GetCategories()
+
+	articles := make([]*Article, 0)
+	for _, article := range GetAllArticles() {
+		if len(article.Category) == 0 {
+			continue
+		}
+		for _, ancestor := range categoryPath(categories, article.Category) {
+			if ancestor.Slug == slug {
+				articles = append(articles, article)
+				break
+			}
+		}
+	}
+
+	return articles
+}
+
+func AddCategory(name string, parent string) (*Category, error) {
+	name = strings.TrimSpace(name)
+	slug := Slugify(name)
+	if len(slug) == 0 {
+		return nil, errors.New("category name can't be empty")
+	}
+
+	categoriesMutex.Lock()
+	defer categoriesMutex.Unlock()
+
+	categories, err := readCategories()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	parentFound := len(parent) == 0
+	for _, category := range categories {
+		parentFound = parentFound || category.Slug == parent
+	}
+	if !parentFound {
+		return nil, errors.New("parent category not found: " + parent)
+	}
+
+	taken := func(slug string) bool {
+		for _, category := range categories {
+			if category.Slug == slug {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Subcategories with a common name, e.g. Family > Holidays and
+	// Work > Holidays, are told apart by their parent
+	if taken(slug) && len(parent) > 0 {
+		slug = parent + "-" + slug
+	}
+	for base, index := slug, 2; taken(slug); index++ {
+		slug = base + "-" + strconv.Itoa(index)
+	}
+
+	category := &Category{Slug: slug, Name: name, Parent: parent}
+	categories = append(categories, category)
+
+	if err := writeCategories(categories); err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return category, nil
+}
+
+// Removes a category that has no subcategories. Its articles move up to its
+// parent, or become uncategorized.
+func DeleteCategory(slug string) (int, error) {
+	categoriesMutex.Lock()
+	defer categoriesMutex.Unlock()
+
+	categories, err := readCategories()
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	var deleted *Category
+	remaining := make([]*Category, 0, len(categories))
+	for _, category := range categories {
+		if category.Parent == slug {
+			return 0, errors.New("category has subcategories: " + slug)
+		}
+		if category.Slug == slug {
+			deleted = category
+			continue
+		}
+		remaining = append(remaining, category)
+	}
+	if deleted == nil {
+		return 0, errors.New("category not found: " + slug)
+	}
+
+	count, err := updateArticles(func(article *Article) bool {
+		if article.Category != slug {
+			return false
+		}
+		article.Category = deleted.Parent
+		return true
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if err := writeCategories(remaining); err != nil {
+		logger.Error(err.Error())
+		return count, err
+	}
+
+	return count, nil
+}
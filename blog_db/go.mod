@@ -2,4 +2,30 @@ module github.com/jeffereydecker/blazemarker/blog_db
 
 go 1.22.5
 
-require github.com/jeffereydecker/blazemarker/blaze_log v0.0.0-20240721023413-f4c6ed51da8c
+require (
+	github.com/disintegration/imaging v1.6.2
+	github.com/jeffereydecker/blazemarker/blaze_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/blaze_http v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/blaze_log v0.0.0-20240721023413-f4c6ed51da8c
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/yuin/goldmark v1.7.4
+	gorm.io/gorm v1.25.11
+)
+
+require (
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	golang.org/x/image v0.18.0 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	gorm.io/driver/sqlite v1.5.6 // indirect
+)
+
+replace github.com/jeffereydecker/blazemarker/blaze_db => ../blaze_db
+
+replace github.com/jeffereydecker/blazemarker/blaze_http => ../blaze_http
+
+replace github.com/jeffereydecker/blazemarker/blaze_log => ../blaze_log
@@ -0,0 +1,101 @@
+package blog_db
+
+import (
+	"errors"
+	"time"
+)
+
+// Articles on hold can't be deleted or purged until an admin lifts the hold.
+// Holds follow the article when its key changes.
+type ArticleHold struct {
+	Article  string    `gorm:"primaryKey" json:"article"`
+	Title    string    `json:"title"`
+	Reason   string    `json:"reason"`
+	PlacedBy string    `json:"placed_by"`
+	PlacedAt time.Time `json:"placed_at"`
+}
+
+func IsArticleHeld(key string) bool {
+	gdb := getDB()
+	if gdb == nil {
+		return false
+	}
+
+	var count int64
+	if err := gdb.Model(&ArticleHold{}).Where("article = ?", key).Count(&count).Error; err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+
+	return count > 0
+}
+
+func GetArticleHolds() []*ArticleHold {
+	holds := make([]*ArticleHold, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return holds
+	}
+
+	if err := gdb.Order("title").Find(&holds).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return holds
+}
+
+// Trashed articles can be held too, to keep them from being purged.
+func PlaceArticleHold(key string, reason string, username string) (*ArticleHold, error) {
+	article := readArticle(key)
+	if article == nil {
+		return nil, errors.New("article not found: " + key)
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return nil, errors.New("database not available")
+	}
+
+	hold := &ArticleHold{Article: key, Title: article.Title, Reason: reason, PlacedBy: username, PlacedAt: time.Now()}
+	if err := gdb.Save(hold).Error; err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+func LiftArticleHold(key string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("database not available")
+	}
+
+	result := gdb.Delete(&ArticleHold{}, "article = ?", key)
+	if result.Error != nil {
+		logger.Error(result.Error.Error())
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("article not on hold: " + key)
+	}
+
+	return nil
+}
+
+// Moves what is stored by article key over to the article's new key.
+func renameArticleRefs(from string, article *Article) {
+	gdb := getDB()
+	if gdb == nil {
+		return
+	}
+
+	if err := gdb.Model(&ArticleHold{}).Where("article = ?", from).
+		Updates(map[string]any{"article": article.Key(), "title": article.Title}).Error; err != nil {
+		logger.Error(err.Error())
+	}
+	if err := gdb.Model(&ArticleRead{}).Where("article = ?", from).Update("article", article.Key()).Error; err != nil {
+		logger.Error(err.Error())
+	}
+}
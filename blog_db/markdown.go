@@ -0,0 +1,60 @@
+package blog_db
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Articles are written either as HTML from the rich text editor or as
+// Markdown. Markdown articles keep their source for editing and store the
+// rendered HTML in Content, so they display like any other article. Raw HTML
+// and unsafe links in Markdown are dropped when rendering.
+
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+)
+
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+func RenderMarkdown(source string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		logger.Error(err.Error())
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// Sets the article's content from the editor, rendering Markdown to HTML.
+func (article *Article) SetBody(format string, body string) error {
+	switch format {
+	case "", FormatHTML:
+		article.Format = FormatHTML
+		article.Source = ""
+		article.Content = template.HTML(body)
+	case FormatMarkdown:
+		content, err := RenderMarkdown(body)
+		if err != nil {
+			return err
+		}
+		article.Format = FormatMarkdown
+		article.Source = body
+		article.Content = content
+	default:
+		return errors.New("unknown article format: " + format)
+	}
+	return nil
+}
+
+// Returns what the editor should load: the Markdown source or the HTML.
+func (article *Article) Body() string {
+	if article.Format == FormatMarkdown {
+		return article.Source
+	}
+	return string(article.Content)
+}
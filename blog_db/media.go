@@ -0,0 +1,327 @@
+package blog_db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// Images uploaded from the article editor are kept in ../media under random
+// names and tracked in the media table. Files no article refers to, including
+// those in the trash, are cleaned up once they are older than
+// orphanedMediaAge.
+type Media struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Owner       string    `gorm:"index" json:"owner"`
+	Filename    string    `gorm:"uniqueIndex" json:"filename"`
+	Name        string    `json:"name"` // As uploaded
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Created     time.Time `json:"created"`
+	URL         string    `gorm:"-" json:"url"`
+	Srcset      string    `gorm:"-" json:"srcset"`
+	UsedBy      []string  `gorm:"-" json:"used_by"` // Article keys
+}
+
+const (
+	mediaDir         = "../media/"
+	orphanedMediaAge = 24 * time.Hour
+
+	// Decoding needs about four bytes per pixel, so a small file claiming a
+	// huge size is refused before it is decoded.
+	maxMediaPixels = 50_000_000
+)
+
+var mediaExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Smaller renditions are made at these widths, for srcset. GIFs are kept as
+// uploaded so animations survive.
+var mediaRenditionWidths = []int{320, 640, 1280}
+
+var mediaRendition_re = regexp.MustCompile(`^([0-9a-f]+)-[0-9]+(\.[a-z]+)$`)
+
+func (media *Media) renditionWidths() []int {
+	if media.ContentType == "image/gif" {
+		return nil
+	}
+	return slices.DeleteFunc(slices.Clone(mediaRenditionWidths), func(width int) bool { return width >= media.Width })
+}
+
+func (media *Media) renditionFilename(width int) string {
+	extension := mediaExtensions[media.ContentType]
+	return strings.TrimSuffix(media.Filename, extension) + "-" + strconv.Itoa(width) + extension
+}
+
+func (media *Media) setURL() {
+	media.URL = "/media/" + media.Filename
+
+	srcset := make([]string, 0)
+	for _, width := range media.renditionWidths() {
+		srcset = append(srcset, "/media/"+media.renditionFilename(width)+" "+strconv.Itoa(width)+"w")
+	}
+	media.Srcset = strings.Join(append(srcset, media.URL+" "+strconv.Itoa(media.Width)+"w"), ", ")
+}
+
+// Maps a rendition's file name to the uploaded file's.
+func originalMediaFilename(filename string) string {
+	if match := mediaRendition_re.FindStringSubmatch(filename); match != nil {
+		return match[1] + match[2]
+	}
+	return filename
+}
+
+// Re-encodes JPEG and PNG uploads upright and without their metadata, so
+// EXIF GPS positions aren't published, and saves the smaller renditions.
+func writeMedia(media *Media, data []byte) error {
+	if media.ContentType == "image/gif" {
+		return os.WriteFile(mediaDir+media.Filename, data, 0644)
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return errors.New("unable to read image: " + err.Error())
+	}
+	media.Width = img.Bounds().Dx()
+	media.Height = img.Bounds().Dy()
+
+	format := imaging.JPEG
+	if media.ContentType == "image/png" {
+		format = imaging.PNG
+	}
+
+	var buffer bytes.Buffer
+	if err := imaging.Encode(&buffer, img, format, imaging.JPEGQuality(90)); err != nil {
+		return err
+	}
+	media.Size = int64(buffer.Len())
+	if err := os.WriteFile(mediaDir+media.Filename, buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	for _, width := range media.renditionWidths() {
+		if err := imaging.Save(imaging.Resize(img, width, 0, imaging.Lanczos), mediaDir+media.renditionFilename(width), imaging.JPEGQuality(85)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeMediaFiles(media *Media) error {
+	for _, width := range media.renditionWidths() {
+		if err := os.Remove(mediaDir + media.renditionFilename(width)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Remove(mediaDir + media.Filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func SaveMedia(owner string, name string, data []byte) (*Media, error) {
+	gdb := getDB()
+	if gdb == nil {
+		return nil, errors.New("database not available")
+	}
+
+	contentType := http.DetectContentType(data)
+	extension, ok := mediaExtensions[contentType]
+	if !ok {
+		return nil, errors.New("unsupported image type: " + contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("unable to read image: " + err.Error())
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxMediaPixels {
+		return nil, errors.New("image is too large: " + strconv.Itoa(config.Width) + "x" + strconv.Itoa(config.Height))
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	media := &Media{
+		Owner:       owner,
+		Filename:    hex.EncodeToString(random) + extension,
+		Name:        name,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       config.Width,
+		Height:      config.Height,
+		Created:     time.Now(),
+	}
+
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+	if err := writeMedia(media, data); err != nil {
+		logger.Error(err.Error())
+		removeMediaFiles(media)
+		return nil, err
+	}
+
+	if err := gdb.Create(media).Error; err != nil {
+		logger.Error(err.Error())
+		removeMediaFiles(media)
+		return nil, err
+	}
+
+	media.setURL()
+	return media, nil
+}
+
+// Maps each media file name to the keys of the articles that show it.
+func mediaUses() map[string][]string {
+	uses := make(map[string][]string)
+
+	for _, article := range readArticles() {
+		for _, body := range []string{string(article.Content), article.Source} {
+			for _, part := range strings.Split(body, "/media/")[1:] {
+				end := strings.IndexAny(part, "\"') ,")
+				if end < 0 {
+					end = len(part)
+				}
+				filename := originalMediaFilename(part[:end])
+				if len(filename) > 0 && !slices.Contains(uses[filename], article.Key()) {
+					uses[filename] = append(uses[filename], article.Key())
+				}
+			}
+		}
+	}
+
+	return uses
+}
+
+// Returns the media owner uploaded, or everyone's when owner is empty, newest
+// first.
+func GetMedia(owner string) []*Media {
+	media := make([]*Media, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return media
+	}
+
+	query := gdb.Order("created DESC")
+	if len(owner) > 0 {
+		query = query.Where("owner = ?", owner)
+	}
+	if err := query.Find(&media).Error; err != nil {
+		logger.Error(err.Error())
+		return media
+	}
+
+	uses := mediaUses()
+	for _, m := range media {
+		m.setURL()
+		m.UsedBy = uses[m.Filename]
+		if m.UsedBy == nil {
+			m.UsedBy = make([]string, 0)
+		}
+	}
+
+	return media
+}
+
+// Removes media no article refers to and files in ../media that aren't
+// tracked. Recent uploads are kept since the article using them may not be
+// saved yet.
+func CleanupOrphanedMedia(now time.Time) (int, error) {
+	gdb := getDB()
+	if gdb == nil {
+		return 0, errors.New("database not available")
+	}
+
+	media := make([]*Media, 0)
+	if err := gdb.Find(&media).Error; err != nil {
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	uses := mediaUses()
+	tracked := make(map[string]bool)
+	count := 0
+
+	for _, m := range media {
+		if len(uses[m.Filename]) > 0 || now.Sub(m.Created) < orphanedMediaAge {
+			tracked[m.Filename] = true
+			continue
+		}
+
+		if err := removeMediaFiles(m); err != nil {
+			logger.Error(err.Error())
+			tracked[m.Filename] = true
+			continue
+		}
+		if err := gdb.Delete(m).Error; err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		logger.Info("Removed orphaned media", "filename", m.Filename, "owner", m.Owner)
+		count = count + 1
+	}
+
+	files, err := os.ReadDir(mediaDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return count, nil
+		}
+		logger.Error(err.Error())
+		return count, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || tracked[originalMediaFilename(file.Name())] {
+			continue
+		}
+		if info, err := file.Info(); err != nil || now.Sub(info.ModTime()) < orphanedMediaAge {
+			continue
+		}
+		if err := os.Remove(mediaDir + file.Name()); err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		logger.Info("Removed untracked media", "filename", file.Name())
+		count = count + 1
+	}
+
+	return count, nil
+}
+
+func StartMediaCleanup(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := CleanupOrphanedMedia(time.Now()); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}()
+}
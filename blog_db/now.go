@@ -0,0 +1,184 @@
+package blog_db
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Each member has one current Now page, stored in ../now/<author>.json. When
+// it is replaced the previous version is archived as a dated article tagged
+// NowTag, which makes up the page's history. Pages are for members only unless
+// their author makes them public.
+
+const (
+	NowTag = "now"
+	nowDir = "../now/"
+)
+
+type NowPage struct {
+	Author  string        `json:"author"`
+	Content template.HTML `json:"content"`
+	Updated time.Time     `json:"updated"`
+	Public  bool          `json:"public,omitempty"`
+}
+
+var nowMutex sync.Mutex
+
+// Presents the page like an article for templates that list articles.
+func (page *NowPage) Article() *Article {
+	return &Article{
+		Title:   "What " + page.Author + " Is Doing Now",
+		Content: page.Content,
+		Author:  page.Author,
+		Date:    page.Updated.Format("2006-01-02"),
+	}
+}
+
+func (page *NowPage) CanView(username string) bool {
+	return page.Public || len(username) > 0
+}
+
+func nowPagePath(author string) string {
+	return nowDir + author + ".json"
+}
+
+func GetNowPage(author string) *NowPage {
+	if len(author) == 0 || strings.ContainsAny(author, "/\\") {
+		return nil
+	}
+
+	jsonData, err := os.ReadFile(nowPagePath(author))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	page := new(NowPage)
+	if err := json.Unmarshal(jsonData, page); err != nil {
+		logger.Error(err.Error(), "author", author)
+		return nil
+	}
+	page.Content = SanitizeHTML(page.Content)
+
+	return page
+}
+
+// Returns every member's Now page, most recently updated first.
+func GetNowPages() []*NowPage {
+	pages := make([]*NowPage, 0)
+
+	files, err := os.ReadDir(nowDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return pages
+	}
+
+	for _, file := range files {
+		if page := GetNowPage(strings.TrimSuffix(file.Name(), ".json")); page != nil {
+			pages = append(pages, page)
+		}
+	}
+
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Updated.After(pages[j].Updated) })
+
+	return pages
+}
+
+// Returns the archived versions of an author's Now page, newest first.
+func GetNowHistory(author string) []*Article {
+	history := make([]*Article, 0)
+	for _, article := range GetArticlesByTag(NowTag) {
+		if article.Author == author {
+			history = append(history, article)
+		}
+	}
+
+	SortByDate(history)
+
+	return history
+}
+
+func writeNowPage(page *NowPage) error {
+	page.Content = SanitizeHTML(page.Content)
+
+	if err := os.MkdirAll(nowDir, 0755); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	jsonData, err := json.MarshalIndent(page, "", "    ")
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	if err := os.WriteFile(nowPagePath(page.Author), jsonData, 0644); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Replaces the author's Now page, archiving the previous version first.
+func SaveNowPage(author string, content template.HTML, public bool) (*NowPage, error) {
+	if len(author) == 0 || strings.ContainsAny(author, "/\\") {
+		return nil, errors.New("invalid author: " + author)
+	}
+
+	nowMutex.Lock()
+	defer nowMutex.Unlock()
+
+	if previous := GetNowPage(author); previous != nil {
+		archived := &Article{
+			Title:   "What I Was Doing Now (" + previous.Updated.Format("January 2, 2006 15:04") + ")",
+			Content: previous.Content,
+			Author:  previous.Author,
+			Date:    previous.Updated.Format("2006-01-02"),
+			Tags:    []string{NowTag},
+		}
+		if err := CreateArticle(archived); err != nil {
+			return nil, errors.New("unable to archive previous Now page: " + err.Error())
+		}
+	}
+
+	page := &NowPage{Author: author, Content: content, Updated: time.Now(), Public: public}
+	if err := writeNowPage(page); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// Makes an existing article the author's current Now page, unless they
+// already have one. Used to carry over Now pages kept as plain articles, which
+// stay public unless the article was private.
+func ImportNowPage(article *Article) (bool, error) {
+	nowMutex.Lock()
+	defer nowMutex.Unlock()
+
+	if GetNowPage(article.Author) != nil {
+		return false, nil
+	}
+
+	updated, err := time.ParseInLocation("2006-01-02", article.Date, time.Local)
+	if err != nil {
+		updated = time.Now()
+	}
+
+	if err := writeNowPage(&NowPage{Author: article.Author, Content: article.Content, Updated: updated, Public: !article.Private}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,260 @@
+package blog_db
+
+import (
+	"context"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_http"
+	"gorm.io/gorm"
+)
+
+// Link previews hold the Open Graph title, description and image of pages
+// articles link to, cached so templates can show preview cards without
+// fetching anything while rendering. Only public hosts are fetched. Which
+// domains are previewed can be limited with comma separated lists in
+// BLAZEMARKER_PREVIEW_ALLOW and BLAZEMARKER_PREVIEW_DENY, subdomains included.
+type LinkPreview struct {
+	URL         string    `gorm:"primaryKey" json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Image       string    `json:"image,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	Fetched     time.Time `json:"fetched"`
+	Error       string    `json:"error,omitempty"`
+}
+
+const (
+	linkPreviewTTL     = 7 * 24 * time.Hour
+	maxLinkPreviewSize = 512 << 10
+)
+
+var (
+	articleLink_re = regexp.MustCompile(`href="(https?://[^"]+)"`)
+	metaTag_re     = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttr_re    = regexp.MustCompile(`(?is)(property|name|content)\s*=\s*("[^"]*"|'[^']*')`)
+	titleTag_re    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// Only connects to public addresses, so previews can't be used to reach the
+// server's own network, and keeps redirects within the allowed domains.
+var previewClient = newPreviewClient()
+
+func newPreviewClient() *http.Client {
+	client := blaze_http.NewPublicClient(5 * time.Second)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return checkPreviewDomain(req.URL.Hostname())
+	}
+	return client
+}
+
+func previewDomains(name string) []string {
+	domains := make([]string, 0)
+	for _, domain := range strings.Split(os.Getenv(name), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); len(domain) > 0 {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+func matchesDomain(host string, domains []string) bool {
+	return slices.ContainsFunc(domains, func(domain string) bool {
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	})
+}
+
+func checkPreviewDomain(host string) error {
+	host = strings.ToLower(host)
+	if matchesDomain(host, previewDomains("BLAZEMARKER_PREVIEW_DENY")) {
+		return errors.New("link previews are blocked for " + host)
+	}
+	if allow := previewDomains("BLAZEMARKER_PREVIEW_ALLOW"); len(allow) > 0 && !matchesDomain(host, allow) {
+		return errors.New("link previews are not allowed for " + host)
+	}
+	return nil
+}
+
+// Returns the external pages the article links to.
+func ArticleLinks(article *Article) []string {
+	links := make([]string, 0)
+	for _, match := range articleLink_re.FindAllStringSubmatch(string(article.Content), -1) {
+		link := html.UnescapeString(match[1])
+		if !slices.Contains(links, link) {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+func parseLinkPreview(link string, page string) *LinkPreview {
+	preview := &LinkPreview{URL: link, Fetched: time.Now()}
+
+	for _, tag := range metaTag_re.FindAllString(page, -1) {
+		var property, content string
+		for _, attr := range metaAttr_re.FindAllStringSubmatch(tag, -1) {
+			value := html.UnescapeString(strings.Trim(attr[2], `"'`))
+			if strings.EqualFold(attr[1], "content") {
+				content = value
+			} else {
+				property = strings.ToLower(value)
+			}
+		}
+
+		switch property {
+		case "og:title":
+			preview.Title = content
+		case "og:description":
+			preview.Description = content
+		case "description":
+			if len(preview.Description) == 0 {
+				preview.Description = content
+			}
+		case "og:image":
+			preview.Image = content
+		case "og:site_name":
+			preview.SiteName = content
+		}
+	}
+
+	if len(preview.Title) == 0 {
+		if match := titleTag_re.FindStringSubmatch(page); match != nil {
+			preview.Title = strings.TrimSpace(html.UnescapeString(match[1]))
+		}
+	}
+
+	// Images can be relative to the page
+	if len(preview.Image) > 0 {
+		base, _ := url.Parse(link)
+		image, err := url.Parse(preview.Image)
+		if err != nil || (image.Scheme != "" && image.Scheme != "http" && image.Scheme != "https") {
+			preview.Image = ""
+		} else {
+			preview.Image = base.ResolveReference(image).String()
+		}
+	}
+
+	return preview
+}
+
+func fetchLinkPreview(ctx context.Context, link string) (*LinkPreview, error) {
+	target, err := url.Parse(link)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return nil, errors.New("invalid link: " + link)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Blazemarker link preview")
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := previewClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("link preview fetch failed: " + resp.Status)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, errors.New("not an HTML page: " + resp.Header.Get("Content-Type"))
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxLinkPreviewSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLinkPreview(link, string(page)), nil
+}
+
+// Returns the cached preview for the link, fetching it when it is missing or
+// stale. Failures are cached too so broken links aren't fetched again and
+// again.
+func GetLinkPreview(ctx context.Context, link string) (*LinkPreview, error) {
+	gdb := getDB()
+	if gdb == nil {
+		return nil, errors.New("database not available")
+	}
+
+	if err := checkPreviewDomain(hostname(link)); err != nil {
+		return nil, err
+	}
+
+	cached := new(LinkPreview)
+	if err := gdb.Where("url = ?", link).First(cached).Error; err == nil && time.Since(cached.Fetched) < linkPreviewTTL {
+		if len(cached.Error) > 0 {
+			return nil, errors.New(cached.Error)
+		}
+		return cached, nil
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Error(err.Error())
+	}
+
+	preview, fetchErr := fetchLinkPreview(ctx, link)
+	if fetchErr != nil {
+		logger.Info("Link preview failed", "link", link, "error", fetchErr.Error())
+		preview = &LinkPreview{URL: link, Fetched: time.Now(), Error: fetchErr.Error()}
+	}
+
+	if err := gdb.Save(preview).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return preview, nil
+}
+
+func hostname(link string) string {
+	if target, err := url.Parse(link); err == nil {
+		return target.Hostname()
+	}
+	return ""
+}
+
+// Returns the cached previews for the links, without fetching.
+func GetCachedLinkPreviews(links []string) []*LinkPreview {
+	previews := make([]*LinkPreview, 0)
+
+	gdb := getDB()
+	if gdb == nil || len(links) == 0 {
+		return previews
+	}
+
+	if err := gdb.Where("url IN ? AND error = ? AND title <> ?", links, "", "").Find(&previews).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	previews = slices.DeleteFunc(previews, func(preview *LinkPreview) bool {
+		return checkPreviewDomain(hostname(preview.URL)) != nil
+	})
+	slices.SortFunc(previews, func(a, b *LinkPreview) int {
+		return slices.Index(links, a.URL) - slices.Index(links, b.URL)
+	})
+
+	return previews
+}
+
+// Fetches previews for the article's links. Run it in the background after
+// saving.
+func FetchArticleLinkPreviews(article *Article) {
+	for _, link := range ArticleLinks(article) {
+		GetLinkPreview(context.Background(), link)
+	}
+}
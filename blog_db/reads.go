@@ -0,0 +1,126 @@
+package blog_db
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Tracks which articles each member has seen, by article key, so the site can
+// show what is new since their last visit.
+type ArticleRead struct {
+	Username string    `gorm:"primaryKey" json:"username"`
+	Article  string    `gorm:"primaryKey" json:"article"`
+	ReadAt   time.Time `json:"read_at"`
+}
+
+// Counting unread articles reads every article, so counts are cached per
+// member until an article changes or the member reads one.
+type unreadCount struct {
+	Count      int
+	Generation int
+}
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+
+	unreadCounts      = make(map[string]*unreadCount)
+	articleGeneration = 0
+	unreadCountsMutex sync.Mutex
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&ArticleRead{}, &ArticleHold{}, &Media{}, &LinkPreview{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+func MarkArticlesRead(username string, articles []*Article) {
+	gdb := getDB()
+	if gdb == nil || len(username) == 0 || len(articles) == 0 {
+		return
+	}
+
+	now := time.Now()
+	reads := make([]*ArticleRead, 0, len(articles))
+	for _, article := range articles {
+		reads = append(reads, &ArticleRead{Username: username, Article: article.Key(), ReadAt: now})
+	}
+
+	if err := gdb.Clauses(clause.OnConflict{DoNothing: true}).Create(&reads).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	unreadCountsMutex.Lock()
+	delete(unreadCounts, username)
+	unreadCountsMutex.Unlock()
+}
+
+// Called whenever an article is written or removed.
+func articlesChanged() {
+	unreadCountsMutex.Lock()
+	articleGeneration = articleGeneration + 1
+	unreadCountsMutex.Unlock()
+}
+
+// Returns the keys of the articles the user has seen.
+func GetReadArticles(username string) map[string]bool {
+	read := make(map[string]bool)
+
+	gdb := getDB()
+	if gdb == nil {
+		return read
+	}
+
+	keys := make([]string, 0)
+	if err := gdb.Model(&ArticleRead{}).Where("username = ?", username).Pluck("article", &keys).Error; err != nil {
+		logger.Error(err.Error())
+		return read
+	}
+
+	for _, key := range keys {
+		read[key] = true
+	}
+
+	return read
+}
+
+func GetUnreadCount(username string) int {
+	unreadCountsMutex.Lock()
+	generation := articleGeneration
+	if cached, ok := unreadCounts[username]; ok && cached.Generation == generation {
+		unreadCountsMutex.Unlock()
+		return cached.Count
+	}
+	unreadCountsMutex.Unlock()
+
+	read := GetReadArticles(username)
+
+	count := 0
+	for _, article := range GetAllArticles() {
+		if article.CanView(username) && !read[article.Key()] {
+			count = count + 1
+		}
+	}
+
+	unreadCountsMutex.Lock()
+	unreadCounts[username] = &unreadCount{Count: count, Generation: generation}
+	unreadCountsMutex.Unlock()
+
+	return count
+}
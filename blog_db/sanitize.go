@@ -0,0 +1,26 @@
+package blog_db
+
+import (
+	"html/template"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Article and Now page bodies are member written HTML rendered as-is, so they
+// are sanitized when saved and again when read, which also covers anything
+// saved before sanitizing was added. The policy keeps what the editor
+// produces: formatting, links, tables, pasted or uploaded images with their
+// srcset and text alignment.
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowDataURIImages()
+	p.AllowAttrs("srcset", "sizes").OnElements("img")
+	p.AllowStyles("text-align", "padding-left").Globally()
+	return p
+}
+
+func SanitizeHTML(html template.HTML) template.HTML {
+	return template.HTML(policy.Sanitize(string(html)))
+}
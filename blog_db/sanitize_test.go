@@ -0,0 +1,40 @@
+package blog_db
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		html template.HTML
+		want template.HTML
+	}{
+		{"formatting is kept", `<p>Hello <b>world</b></p>`, `<p>Hello <b>world</b></p>`},
+		{"tables are kept", `<table><tr><td>cell</td></tr></table>`, `<table><tr><td>cell</td></tr></table>`},
+		{"scripts are dropped", `<script>alert(1)</script><p>after</p>`, `<p>after</p>`},
+		{"scripts inside svg are dropped", `<svg><script>alert(1)</script></svg>`, ``},
+		{"iframes are dropped", `<iframe src="https://evil.example/"></iframe>`, ``},
+		{"event handlers are dropped", `<div onclick="steal()">text</div>`, `<div>text</div>`},
+		{"event handlers on images are dropped", `<img src="x" onerror="alert(1)">`, `<img src="x">`},
+		{"javascript links are dropped", `<a href="javascript:alert(1)">click</a>`, `click`},
+		{"links are kept as nofollow", `<a href="https://example.com/">link</a>`, `<a href="https://example.com/" rel="nofollow">link</a>`},
+		{"alignment is kept, other styles dropped", `<p style="text-align: center; color: red">centered</p>`, `<p style="text-align: center">centered</p>`},
+		{"indentation is kept", `<p style="padding-left: 40px">indented</p>`, `<p style="padding-left: 40px">indented</p>`},
+		{"style urls are dropped", `<p style="background: url(javascript:alert(1))">bg</p>`, `<p>bg</p>`},
+		{
+			"responsive images are kept",
+			`<img src="/media/a.jpg" srcset="/media/a-480.jpg 480w, /media/a.jpg 1200w" sizes="100vw" alt="A">`,
+			`<img src="/media/a.jpg" srcset="/media/a-480.jpg 480w, /media/a.jpg 1200w" sizes="100vw" alt="A">`,
+		},
+		{"pasted images are kept", `<img src="data:image/png;base64,iVBORw0KGgo=">`, `<img src="data:image/png;base64,iVBORw0KGgo=">`},
+		{"data URIs that aren't images are dropped", `<img src="data:text/html;base64,PHNjcmlwdD4=">`, ``},
+	}
+
+	for _, test := range tests {
+		if got := SanitizeHTML(test.html); got != test.want {
+			t.Errorf("%s: SanitizeHTML(%q) = %q, want %q", test.name, test.html, got, test.want)
+		}
+	}
+}
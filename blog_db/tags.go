@@ -0,0 +1,139 @@
+package blog_db
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Tags are stored on each article, normalized to lower case with single
+// spaces. Renaming a tag onto an existing one merges the two.
+
+type TagCount struct {
+	Name   string `json:"name"`
+	Count  int    `json:"count"`
+	Weight int    `json:"weight"` // 1 to 5, relative to the most used tag
+}
+
+func NormalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// Splits the editor's comma separated tag list.
+func ParseTags(tags string) []string {
+	parsed := make([]string, 0)
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = NormalizeTag(tag); len(tag) > 0 && !slices.Contains(parsed, tag) {
+			parsed = append(parsed, tag)
+		}
+	}
+	return parsed
+}
+
+func GetTags() []*TagCount {
+	counts := make(map[string]int)
+	for _, article := range GetAllArticles() {
+		if article.Private {
+			continue
+		}
+		for _, tag := range article.Tags {
+			counts[tag] = counts[tag] + 1
+		}
+	}
+
+	maxCount := 0
+	for _, count := range counts {
+		maxCount = max(maxCount, count)
+	}
+
+	tags := make([]*TagCount, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, &TagCount{Name: name, Count: count, Weight: 1 + 4*count/maxCount})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+	return tags
+}
+
+func GetArticlesByTag(tag string) []*Article {
+	tag = NormalizeTag(tag)
+
+	articles := make([]*Article, 0)
+	for _, article := range GetAllArticles() {
+		if slices.Contains(article.Tags, tag) {
+			articles = append(articles, article)
+		}
+	}
+
+	return articles
+}
+
+// Applies update to every article, saving those it reports as changed.
+func updateArticles(update func(article *Article) bool) (int, error) {
+	files, err := os.ReadDir("../articles")
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range files {
+		jsonData, err := os.ReadFile("../articles/" + file.Name())
+		if err != nil {
+			logger.Error(err.Error())
+			return count, err
+		}
+
+		article := new(Article)
+		if err := json.Unmarshal(jsonData, article); err != nil {
+			logger.Error(err.Error(), "file.Name()", file.Name())
+			continue
+		}
+
+		if !update(article) {
+			continue
+		}
+		if !SaveArticle(article) {
+			return count, errors.New("unable to save article: " + article.Title)
+		}
+		count = count + 1
+	}
+
+	return count, nil
+}
+
+func RenameTag(from string, to string) (int, error) {
+	from, to = NormalizeTag(from), NormalizeTag(to)
+	if len(from) == 0 || len(to) == 0 {
+		return 0, errors.New("tag names can't be empty")
+	}
+
+	return updateArticles(func(article *Article) bool {
+		index := slices.Index(article.Tags, from)
+		if index < 0 || from == to {
+			return false
+		}
+		if slices.Contains(article.Tags, to) {
+			article.Tags = slices.Delete(article.Tags, index, index+1)
+		} else {
+			article.Tags[index] = to
+		}
+		return true
+	})
+}
+
+func DeleteTag(tag string) (int, error) {
+	tag = NormalizeTag(tag)
+
+	return updateArticles(func(article *Article) bool {
+		index := slices.Index(article.Tags, tag)
+		if index < 0 {
+			return false
+		}
+		article.Tags = slices.Delete(article.Tags, index, index+1)
+		return true
+	})
+}
@@ -0,0 +1,128 @@
+package blog_db
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"time"
+)
+
+// Deleted articles are kept in the trash, marked with DeletedAt, until their
+// author restores or purges them or they are purged after trashRetention.
+// Articles on hold stay in the trash until the hold is lifted.
+const trashRetention = 30 * 24 * time.Hour
+
+var articlePurgedHandlers []func(key string)
+
+// Handlers are told the key of each purged article so they can drop what
+// they keep about it. They must be registered before the purger starts.
+func OnArticlePurged(handler func(key string)) {
+	articlePurgedHandlers = append(articlePurgedHandlers, handler)
+}
+
+func DeleteArticle(key string) error {
+	article := GetArticle(key)
+	if article == nil {
+		return errors.New("article not found: " + key)
+	}
+	if IsArticleHeld(key) {
+		return errors.New("article is on hold: " + article.Title)
+	}
+
+	now := time.Now()
+	article.DeletedAt = &now
+	if !SaveArticle(article) {
+		return errors.New("unable to delete article: " + article.Title)
+	}
+
+	return nil
+}
+
+func GetTrashedArticle(key string) *Article {
+	if article := readArticle(key); article != nil && article.DeletedAt != nil {
+		return article
+	}
+	return nil
+}
+
+// Returns the trashed articles username is an author of, most recently
+// deleted first.
+func GetTrash(username string) []*Article {
+	articles := slices.DeleteFunc(readArticles(), func(article *Article) bool {
+		return article.DeletedAt == nil || !article.HasAuthor(username)
+	})
+
+	slices.SortFunc(articles, func(a, b *Article) int { return b.DeletedAt.Compare(*a.DeletedAt) })
+
+	return articles
+}
+
+func RestoreArticle(key string) error {
+	article := GetTrashedArticle(key)
+	if article == nil {
+		return errors.New("article not in trash: " + key)
+	}
+
+	article.DeletedAt = nil
+	if !SaveArticle(article) {
+		return errors.New("unable to restore article: " + article.Title)
+	}
+
+	return nil
+}
+
+func PurgeArticle(key string) error {
+	article := GetTrashedArticle(key)
+	if article == nil {
+		return errors.New("article not in trash: " + key)
+	}
+	if IsArticleHeld(key) {
+		return errors.New("article is on hold: " + article.Title)
+	}
+
+	if err := os.Remove("../articles/" + key + ".json"); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	articlesChanged()
+
+	if gdb := getDB(); gdb != nil {
+		if err := gdb.Where("article = ?", key).Delete(&ArticleRead{}).Error; err != nil {
+			logger.Error(err.Error())
+		}
+	}
+	for _, handler := range articlePurgedHandlers {
+		handler(key)
+	}
+
+	return nil
+}
+
+// Purges articles that have been in the trash longer than trashRetention.
+func PurgeTrash(now time.Time) int {
+	count := 0
+	for _, article := range readArticles() {
+		if article.DeletedAt == nil || now.Sub(*article.DeletedAt) < trashRetention {
+			continue
+		}
+		if IsArticleHeld(article.Key()) {
+			logger.Debug("Held article kept in trash", "article.Title", article.Title, "article.Author", article.Author)
+			continue
+		}
+		if err := PurgeArticle(article.Key()); err != nil {
+			continue
+		}
+		logger.Info("Purged article from trash", "article.Title", article.Title, "article.Author", article.Author)
+		count = count + 1
+	}
+
+	return count
+}
+
+func StartTrashPurger(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			PurgeTrash(time.Now())
+		}
+	}()
+}
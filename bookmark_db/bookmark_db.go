@@ -0,0 +1,140 @@
+package bookmark_db
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+// Bookmarks refer to content by kind and reference: an article's key, or
+// "album/photo" for a photo.
+type Bookmark struct {
+	Username string    `gorm:"primaryKey" json:"username"`
+	Kind     string    `gorm:"primaryKey" json:"kind"`
+	Ref      string    `gorm:"primaryKey" json:"ref"`
+	Created  time.Time `json:"created"`
+}
+
+const (
+	KindArticle = "article"
+	KindPhoto   = "photo"
+)
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&Bookmark{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+// Newest first.
+func GetBookmarks(username string) []*Bookmark {
+	bookmarks := make([]*Bookmark, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return bookmarks
+	}
+
+	if err := gdb.Where("username = ?", username).Order("created desc").Find(&bookmarks).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return bookmarks
+}
+
+func IsBookmarked(username string, kind string, ref string) bool {
+	gdb := getDB()
+	if gdb == nil {
+		return false
+	}
+
+	var count int64
+	if err := gdb.Model(&Bookmark{}).Where("username = ? AND kind = ? AND ref = ?", username, kind, ref).Count(&count).Error; err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+
+	return count > 0
+}
+
+// Bookmarking something twice keeps the original bookmark.
+func AddBookmark(username string, kind string, ref string) (*Bookmark, error) {
+	if kind != KindArticle && kind != KindPhoto {
+		return nil, errors.New("invalid bookmark kind: " + kind)
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return nil, errors.New("bookmark database not available")
+	}
+
+	bookmark := &Bookmark{Username: username, Kind: kind, Ref: ref, Created: time.Now()}
+	if err := gdb.Where("username = ? AND kind = ? AND ref = ?", username, kind, ref).FirstOrCreate(bookmark).Error; err != nil {
+		return nil, err
+	}
+
+	return bookmark, nil
+}
+
+func RemoveBookmark(username string, kind string, ref string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("bookmark database not available")
+	}
+
+	return gdb.Where("username = ? AND kind = ? AND ref = ?", username, kind, ref).Delete(&Bookmark{}).Error
+}
+
+// Drops every member's bookmark of something that no longer exists.
+func RemoveRef(kind string, ref string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("bookmark database not available")
+	}
+
+	return gdb.Where("kind = ? AND ref = ?", kind, ref).Delete(&Bookmark{}).Error
+}
+
+// Keeps bookmarks pointing at something whose reference changed, such as an
+// article whose title was edited.
+func RenameRef(kind string, from string, to string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("bookmark database not available")
+	}
+
+	return gdb.Model(&Bookmark{}).Where("kind = ? AND ref = ?", kind, from).Update("ref", to).Error
+}
+
+// Bookmarks only matter to the member who saved them, so a deleted member's
+// are removed, as part of the caller's transaction.
+func DeleteUser(tx *gorm.DB, username string) error {
+	// Nothing to delete before the first bookmark is saved
+	if !tx.Migrator().HasTable(&Bookmark{}) {
+		return nil
+	}
+
+	return tx.Where("username = ?", username).Delete(&Bookmark{}).Error
+}
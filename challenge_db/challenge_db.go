@@ -0,0 +1,352 @@
+package challenge_db
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var logger = blaze_log.GetLogger()
+
+// Shared challenges, e.g. daily steps or reading minutes. Members join a
+// challenge and log one value per day; a day counts towards a streak when it
+// reaches the challenge's daily goal.
+type Challenge struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `json:"name"`
+	Unit      string    `json:"unit"`
+	Goal      int       `json:"goal"`
+	CreatedBy string    `json:"created_by"`
+	Created   time.Time `json:"created"`
+}
+
+// Who can see a participant's numbers on the leaderboard.
+const (
+	SharingMembers      = "members"
+	SharingParticipants = "participants"
+	SharingPrivate      = "private"
+)
+
+type Participant struct {
+	ChallengeID uint      `gorm:"primaryKey" json:"challenge_id"`
+	Username    string    `gorm:"primaryKey" json:"username"`
+	Sharing     string    `json:"sharing"`
+	Joined      time.Time `json:"joined"`
+}
+
+type Entry struct {
+	ChallengeID uint   `gorm:"primaryKey" json:"challenge_id"`
+	Username    string `gorm:"primaryKey" json:"username"`
+	Day         string `gorm:"primaryKey" json:"day"` // 2006-01-02
+	Value       int    `json:"value"`
+}
+
+type Standing struct {
+	Username   string `json:"username"`
+	Total      int    `json:"total"`
+	Days       int    `json:"days"`
+	GoalDays   int    `json:"goal_days"`
+	Streak     int    `json:"streak"`
+	BestStreak int    `json:"best_streak"`
+}
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&Challenge{}, &Participant{}, &Entry{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+func IsValidSharing(sharing string) bool {
+	return sharing == SharingMembers || sharing == SharingParticipants || sharing == SharingPrivate
+}
+
+func GetChallenges() []*Challenge {
+	challenges := make([]*Challenge, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return challenges
+	}
+
+	if err := gdb.Order("name").Find(&challenges).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return challenges
+}
+
+func GetChallenge(id uint) *Challenge {
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	challenge := new(Challenge)
+	if err := gdb.First(challenge, id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	return challenge
+}
+
+// Creates the challenge and joins its creator to it.
+func CreateChallenge(challenge *Challenge) error {
+	if len(challenge.Name) == 0 {
+		return errors.New("challenge name can't be empty")
+	}
+	if challenge.Goal < 0 {
+		return errors.New("goal can't be negative")
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("challenge database not available")
+	}
+
+	challenge.ID = 0
+	challenge.Created = time.Now()
+
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(challenge).Error; err != nil {
+			return err
+		}
+		participant := &Participant{ChallengeID: challenge.ID, Username: challenge.CreatedBy, Sharing: SharingParticipants, Joined: challenge.Created}
+		return tx.Create(participant).Error
+	})
+}
+
+func GetParticipant(id uint, username string) *Participant {
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	participant := new(Participant)
+	if err := gdb.Where("challenge_id = ? AND username = ?", id, username).First(participant).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	return participant
+}
+
+// Joins the user to the challenge, or changes who can see their numbers if
+// they already take part.
+func Join(id uint, username string, sharing string) (*Participant, error) {
+	if !IsValidSharing(sharing) {
+		return nil, errors.New("invalid sharing: " + sharing)
+	}
+	if GetChallenge(id) == nil {
+		return nil, errors.New("challenge not found")
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return nil, errors.New("challenge database not available")
+	}
+
+	participant := GetParticipant(id, username)
+	if participant == nil {
+		participant = &Participant{ChallengeID: id, Username: username, Joined: time.Now()}
+	}
+	participant.Sharing = sharing
+
+	if err := gdb.Save(participant).Error; err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return participant, nil
+}
+
+func Leave(id uint, username string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("challenge database not available")
+	}
+
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("challenge_id = ? AND username = ?", id, username).Delete(&Entry{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("challenge_id = ? AND username = ?", id, username).Delete(&Participant{}).Error
+	})
+}
+
+// Records the user's value for a day, replacing any earlier value for it.
+func LogEntry(id uint, username string, day time.Time, value int) (*Entry, error) {
+	if value < 0 {
+		return nil, errors.New("value can't be negative")
+	}
+	if day.After(time.Now()) {
+		return nil, errors.New("can't log days in the future")
+	}
+	if GetParticipant(id, username) == nil {
+		return nil, errors.New("not taking part in this challenge")
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return nil, errors.New("challenge database not available")
+	}
+
+	entry := &Entry{ChallengeID: id, Username: username, Day: day.Format("2006-01-02"), Value: value}
+	if err := gdb.Clauses(clause.OnConflict{UpdateAll: true}).Create(entry).Error; err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func GetEntries(id uint, username string, since time.Time) []*Entry {
+	entries := make([]*Entry, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return entries
+	}
+
+	err := gdb.Where("challenge_id = ? AND username = ? AND day >= ?", id, username, since.Format("2006-01-02")).Order("day desc").Find(&entries).Error
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	return entries
+}
+
+// Returns the standings of the participants whose numbers viewer may see,
+// totalled over the days from since. Streaks always count back from today.
+func Leaderboard(id uint, viewer string, since time.Time) []*Standing {
+	standings := make([]*Standing, 0)
+
+	challenge := GetChallenge(id)
+	gdb := getDB()
+	if challenge == nil || gdb == nil {
+		return standings
+	}
+
+	participants := make([]*Participant, 0)
+	if err := gdb.Where("challenge_id = ?", id).Find(&participants).Error; err != nil {
+		logger.Error(err.Error())
+		return standings
+	}
+
+	viewerTakesPart := false
+	for _, participant := range participants {
+		viewerTakesPart = viewerTakesPart || participant.Username == viewer
+	}
+
+	today := time.Now().Format("2006-01-02")
+	sinceDay := since.Format("2006-01-02")
+
+	for _, participant := range participants {
+		switch {
+		case participant.Username == viewer:
+		case participant.Sharing == SharingMembers:
+		case participant.Sharing == SharingParticipants && viewerTakesPart:
+		default:
+			continue
+		}
+
+		entries := make([]*Entry, 0)
+		if err := gdb.Where("challenge_id = ? AND username = ?", id, participant.Username).Order("day").Find(&entries).Error; err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		standing := &Standing{Username: participant.Username}
+		streak := 0
+		lastDay := ""
+		for _, entry := range entries {
+			if entry.Day >= sinceDay {
+				standing.Total = standing.Total + entry.Value
+				standing.Days = standing.Days + 1
+			}
+
+			if entry.Value < max(challenge.Goal, 1) {
+				streak = 0
+				continue
+			}
+			if entry.Day >= sinceDay {
+				standing.GoalDays = standing.GoalDays + 1
+			}
+			if len(lastDay) > 0 && nextDay(lastDay) == entry.Day && streak > 0 {
+				streak = streak + 1
+			} else {
+				streak = 1
+			}
+			lastDay = entry.Day
+			standing.BestStreak = max(standing.BestStreak, streak)
+		}
+
+		// A streak is still current until a whole day is missed
+		if lastDay == today || nextDay(lastDay) == today {
+			standing.Streak = streak
+		}
+
+		standings = append(standings, standing)
+	}
+
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Total != standings[j].Total {
+			return standings[i].Total > standings[j].Total
+		}
+		return standings[i].Username < standings[j].Username
+	})
+
+	return standings
+}
+
+func nextDay(day string) string {
+	t, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, 0, 1).Format("2006-01-02")
+}
+
+// Credits a deleted member's challenges to another name and removes their
+// own progress, as if they had left every challenge, as part of the caller's
+// transaction.
+func ReassignUser(tx *gorm.DB, from string, to string) error {
+	// Nothing to reassign before the first challenge is saved
+	if !tx.Migrator().HasTable(&Challenge{}) {
+		return nil
+	}
+
+	if err := tx.Model(&Challenge{}).Where("created_by = ?", from).Update("created_by", to).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("username = ?", from).Delete(&Entry{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("username = ?", from).Delete(&Participant{}).Error
+}
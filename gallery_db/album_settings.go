@@ -0,0 +1,233 @@
+package gallery_db
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Per album settings stored in .site_photos/album.json. Owner is the user who
+// uploaded the album, Cover the photo chosen as the album cover. Hidden albums
+// are only shown to their managers, until PublishAt if one is set. The file is
+// served with the album, so nothing private belongs here.
+type AlbumSettings struct {
+	Owner     string     `json:"owner"`
+	Cover     string     `json:"cover"`
+	Hidden    bool       `json:"hidden,omitempty"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+}
+
+func (settings *AlbumSettings) IsPublished(now time.Time) bool {
+	return !settings.Hidden || (settings.PublishAt != nil && !now.Before(*settings.PublishAt))
+}
+
+var albumSettingsMutex sync.Mutex
+
+func albumSettingsPath(albumName string) string {
+	return albumDir(albumName) + ".site_photos/album.json"
+}
+
+func GetAlbumSettings(albumName string) *AlbumSettings {
+	settings := new(AlbumSettings)
+
+	jsonData, err := os.ReadFile(albumSettingsPath(albumName))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return settings
+	}
+
+	if err := json.Unmarshal(jsonData, settings); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return settings
+}
+
+func SaveAlbumSettings(albumName string, settings *AlbumSettings) error {
+	if !isValidName(albumName) {
+		return errors.New("invalid album name: " + albumName)
+	}
+	if sitePhotoPath, _ := findOrAddSitePhotoDir(strings.TrimSuffix(albumDir(albumName), "/")); len(sitePhotoPath) == 0 {
+		return errors.New("album not found: " + albumName)
+	}
+
+	jsonData, err := json.MarshalIndent(settings, "", "    ")
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	if err := os.WriteFile(albumSettingsPath(albumName), jsonData, 0644); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func IsAlbumPublished(albumName string) bool {
+	return GetAlbumSettings(albumName).IsPublished(time.Now())
+}
+
+func unpublishedAlbums() []string {
+	albums := make([]string, 0)
+
+	files, err := readGallery()
+	if err != nil {
+		logger.Error(err.Error())
+		return albums
+	}
+
+	for _, file := range files {
+		if file.IsDir() && !IsAlbumPublished(file.Name()) {
+			albums = append(albums, file.Name())
+		}
+	}
+
+	return albums
+}
+
+// Hides the album, optionally until publishAt, or publishes it right away
+// when hidden is false.
+func SetAlbumPublishing(albumName string, hidden bool, publishAt *time.Time) (*AlbumSettings, error) {
+	albumSettingsMutex.Lock()
+	defer albumSettingsMutex.Unlock()
+
+	settings := GetAlbumSettings(albumName)
+	settings.Hidden = hidden
+	settings.PublishAt = nil
+	if hidden {
+		settings.PublishAt = publishAt
+	}
+
+	if err := SaveAlbumSettings(albumName, settings); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// Returns a JPEG that can be resized for the photo: the original, the RAW
+// preview or the converted rendition.
+func sitePhotoSource(albumFullPath string, photoName string, sitePhotoDirPath string) string {
+	switch {
+	case jpg_re.FindStringIndex(photoName) != nil:
+		return albumFullPath + photoName
+	case isRawPhoto(photoName):
+		return findOrAddRawPreview(albumFullPath+photoName, photoName, sitePhotoDirPath)
+	case isConvertedPhoto(photoName):
+		return findOrAddJPEGRendition(albumFullPath+photoName, photoName, sitePhotoDirPath)
+	}
+	return ""
+}
+
+func removeAlbumCovers(sitePhotoDirPath string) {
+	photos, err := os.ReadDir(sitePhotoDirPath)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	re := regexp.MustCompile(`-ac-..\.(?i)jpg$`)
+	for _, photo := range photos {
+		if !photo.IsDir() && re.FindStringIndex(photo.Name()) != nil {
+			if err := os.Remove(filepath.Join(sitePhotoDirPath, photo.Name())); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+}
+
+// Records the chosen cover and regenerates the album's -ac site photo from it.
+func SetAlbumCover(albumName string, photoName string) (string, error) {
+	logger.Debug("SetAlbumCover", "albumName", albumName, "photoName", photoName)
+
+	albumCoverURL, err := setAlbumCover(albumName, photoName)
+	if err != nil {
+		return "", err
+	}
+
+	reindexAlbums(albumName)
+
+	return albumCoverURL, nil
+}
+
+func setAlbumCover(albumName string, photoName string) (string, error) {
+	if !isValidName(albumName) || !isValidName(photoName) || !isSupportedPhoto(photoName) {
+		return "", errors.New("invalid album or photo name")
+	}
+
+	albumFullPath := albumDir(albumName)
+	if _, err := os.Stat(albumFullPath + photoName); err != nil {
+		return "", errors.New("photo not found: " + photoName)
+	}
+
+	albumSettingsMutex.Lock()
+	defer albumSettingsMutex.Unlock()
+
+	sitePhotoDirPath, sitePhotoDir := findOrAddSitePhotoDir(strings.TrimSuffix(albumFullPath, "/"))
+	if len(sitePhotoDirPath) == 0 || sitePhotoDir == nil {
+		return "", errors.New("site photo directory not available")
+	}
+
+	source := sitePhotoSource(albumFullPath, photoName, sitePhotoDirPath)
+	if len(source) == 0 {
+		return "", errors.New("unable to read photo: " + photoName)
+	}
+
+	removeAlbumCovers(sitePhotoDirPath)
+
+	albumCoverPath, albumCover := createSitePhoto(source, photoName, sitePhotoDirPath, sitePhotoDir, "-ac", "-xs")
+	if len(albumCoverPath) == 0 || albumCover == nil {
+		return "", errors.New("unable to create album cover")
+	}
+
+	settings := GetAlbumSettings(albumName)
+	settings.Cover = photoName
+	if err := SaveAlbumSettings(albumName, settings); err != nil {
+		return "", err
+	}
+
+	return photoURL(albumCoverPath), nil
+}
+
+// Hands every album owned by one user to another. An empty owner leaves the
+// album manageable by admins only.
+func ReassignAlbums(from string, to string) (int, error) {
+	files, err := readGallery()
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	albumSettingsMutex.Lock()
+	defer albumSettingsMutex.Unlock()
+
+	count := 0
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+
+		settings := GetAlbumSettings(file.Name())
+		if settings.Owner != from {
+			continue
+		}
+
+		settings.Owner = to
+		if err := SaveAlbumSettings(file.Name(), settings); err != nil {
+			return count, err
+		}
+
+		count = count + 1
+	}
+
+	return count, nil
+}
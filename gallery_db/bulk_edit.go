@@ -0,0 +1,626 @@
+package gallery_db
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Photos are identified across albums as "album/name".
+//
+// A bulk edit selects photos by ID list and/or smart album style filter and
+// applies every non-nil change to each of them in a background job. Jobs and
+// the previous metadata and location of each photo are kept in the database,
+// so a finished job can be undone even after a restart.
+type BulkEdit struct {
+	PhotoIDs   []string  `json:"photo_ids"`
+	Filter     string    `json:"filter"`
+	Caption    *string   `json:"caption"`
+	Tags       *[]string `json:"tags"`
+	AddTags    []string  `json:"add_tags"`
+	RemoveTags []string  `json:"remove_tags"`
+	People     *[]string `json:"people"`
+	Date       *string   `json:"date"`
+	Album      *string   `json:"album"`
+}
+
+type BulkEditJob struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Username  string    `gorm:"index" json:"username"`
+	Status    string    `json:"status"`
+	Total     int       `json:"total"`
+	Completed int       `json:"completed"`
+	Errors    []string  `gorm:"serializer:json" json:"errors"`
+	Created   time.Time `json:"created"`
+	Finished  time.Time `json:"finished"`
+	Edit      *BulkEdit `gorm:"serializer:json" json:"-"`
+}
+
+// What a bulk edit changed about one photo: the album it was in, the album
+// it was moved to if it was, and its previous metadata. Entries are removed
+// as they are undone.
+type BulkEditUndo struct {
+	ID       uint `gorm:"primaryKey"`
+	JobID    uint `gorm:"index"`
+	Album    string
+	Name     string
+	NewAlbum string
+	Metadata *PhotoMetadata `gorm:"serializer:json"`
+}
+
+const (
+	BulkEditPending = "pending"
+	BulkEditRunning = "running"
+	BulkEditDone    = "done"
+	BulkEditUndoing = "undoing"
+	BulkEditUndone  = "undone"
+)
+
+var (
+	bulkEditJobsMutex sync.Mutex
+
+	albumMetadataMutex sync.Mutex
+)
+
+// Jobs cut short by a restart keep the undo entries recorded so far. They
+// are marked done so what they changed can still be undone.
+func recoverBulkEdits(gdb *gorm.DB) {
+	jobs := make([]*BulkEditJob, 0)
+	if err := gdb.Where("status IN ?", []string{BulkEditPending, BulkEditRunning, BulkEditUndoing}).Find(&jobs).Error; err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	for _, job := range jobs {
+		logger.Warn("Bulk edit interrupted by restart", "id", job.ID, "status", job.Status)
+
+		job.Status = BulkEditDone
+		job.Finished = time.Now()
+		job.Errors = append(job.Errors, "interrupted by a restart")
+		if err := gdb.Save(job).Error; err != nil {
+			logger.Error(err.Error(), "id", job.ID)
+		}
+	}
+}
+
+func isValidName(name string) bool {
+	return len(name) > 0 && !strings.ContainsAny(name, `/\`) && name != "." && name != ".."
+}
+
+func splitPhotoID(photoID string) (string, string, error) {
+	album, name, found := strings.Cut(photoID, "/")
+	if !found || !isValidName(album) || !isValidName(name) || !isSupportedPhoto(name) {
+		return "", "", errors.New("invalid photo id: " + photoID)
+	}
+	return album, name, nil
+}
+
+func SaveAlbumMetadata(albumName string, metadata map[string]*PhotoMetadata) error {
+	if sitePhotoPath, _ := findOrAddSitePhotoDir(strings.TrimSuffix(albumDir(albumName), "/")); len(sitePhotoPath) == 0 {
+		return errors.New("album not found: " + albumName)
+	}
+
+	jsonData, err := json.MarshalIndent(metadata, "", "    ")
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	if err := os.WriteFile(metadataPath(albumName), jsonData, 0644); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Selects every photo matching the edit's ID list and filter. The filter only
+// looks in albums for which allowed returns true; the caller must still check
+// the albums of listed photos.
+func SelectBulkEditPhotos(edit *BulkEdit, allowed func(albumName string) bool) ([]string, error) {
+	selected := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, photoID := range edit.PhotoIDs {
+		if _, _, err := splitPhotoID(photoID); err != nil {
+			return nil, err
+		}
+		if !seen[photoID] {
+			seen[photoID] = true
+			selected = append(selected, photoID)
+		}
+	}
+
+	if len(edit.Filter) == 0 {
+		return selected, nil
+	}
+
+	conditions, err := parseFilter(edit.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	albums, err := readGallery()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	for _, album := range albums {
+		if !album.IsDir() || !allowed(album.Name()) {
+			continue
+		}
+
+		photos, err := os.ReadDir(albumDir(album.Name()))
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		// A RAW file paired with a JPG is edited and moved with it
+		rawPairs := findRawPairs(photos)
+
+		albumMetadata := GetAlbumMetadata(album.Name())
+		for _, photo := range photos {
+			if photo.IsDir() || !isSupportedPhoto(photo.Name()) || rawPairs[rawBaseName(photo.Name())] == photo.Name() {
+				continue
+			}
+			photoID := album.Name() + "/" + photo.Name()
+			if !seen[photoID] && matchesFilter(conditions, album.Name(), photo.Name(), filepath.Join(albumDir(album.Name()), photo.Name()), albumMetadata[photo.Name()]) {
+				seen[photoID] = true
+				selected = append(selected, photoID)
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+// Returns the albums of the selected photos, each once.
+func BulkEditAlbums(photoIDs []string) []string {
+	albums := make([]string, 0)
+	for _, photoID := range photoIDs {
+		if albumName, _, err := splitPhotoID(photoID); err == nil && !slices.Contains(albums, albumName) {
+			albums = append(albums, albumName)
+		}
+	}
+	return albums
+}
+
+func ValidateBulkEdit(edit *BulkEdit) error {
+	if len(edit.PhotoIDs) == 0 && len(edit.Filter) == 0 {
+		return errors.New("photo_ids or filter is required")
+	}
+	if edit.Date != nil && len(*edit.Date) > 0 {
+		if _, err := time.Parse("2006-01-02", *edit.Date); err != nil {
+			return errors.New("date must be formatted as YYYY-MM-DD")
+		}
+	}
+	if edit.Album != nil {
+		if fi, err := os.Stat(albumDir(*edit.Album)); !isValidName(*edit.Album) || err != nil || !fi.IsDir() {
+			return errors.New("album not found: " + *edit.Album)
+		}
+	}
+	return nil
+}
+
+// Starts a job over photos chosen with SelectBulkEditPhotos. The caller
+// validates the edit and checks the user may change every album involved.
+func StartBulkEdit(username string, edit *BulkEdit, photoIDs []string) (*BulkEditJob, error) {
+	logger.Debug("StartBulkEdit", "username", username, "photoIDs", len(photoIDs), "Filter", edit.Filter)
+
+	gdb := getDB()
+	if gdb == nil {
+		return nil, errors.New("gallery database not available")
+	}
+
+	job := new(BulkEditJob)
+	job.Username = username
+	job.Status = BulkEditPending
+	job.Total = len(photoIDs)
+	job.Errors = make([]string, 0)
+	job.Created = time.Now()
+	job.Edit = edit
+	if err := gdb.Create(job).Error; err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	go runBulkEdit(job, photoIDs)
+
+	return GetBulkEditJob(strconv.FormatUint(uint64(job.ID), 10)), nil
+}
+
+func GetBulkEditJob(id string) *BulkEditJob {
+	jobID, err := strconv.ParseUint(id, 10, 0)
+	if err != nil {
+		return nil
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	bulkEditJobsMutex.Lock()
+	defer bulkEditJobsMutex.Unlock()
+
+	job := new(BulkEditJob)
+	if err := gdb.First(job, jobID).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	return job
+}
+
+// Applies the update and saves the job, so its progress survives a restart.
+func updateBulkEditJob(job *BulkEditJob, update func(job *BulkEditJob)) {
+	bulkEditJobsMutex.Lock()
+	defer bulkEditJobsMutex.Unlock()
+
+	update(job)
+
+	if gdb := getDB(); gdb != nil {
+		if err := gdb.Save(job).Error; err != nil {
+			logger.Error(err.Error(), "id", job.ID)
+		}
+	}
+}
+
+func saveBulkEditUndo(job *BulkEditJob, undo *BulkEditUndo) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("gallery database not available")
+	}
+
+	undo.JobID = job.ID
+	return gdb.Create(undo).Error
+}
+
+func applyTagChanges(tags []string, edit *BulkEdit) []string {
+	if edit.Tags != nil {
+		tags = append([]string(nil), (*edit.Tags)...)
+	}
+	for _, tag := range edit.AddTags {
+		if !containsFold(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(edit.RemoveTags) > 0 {
+		kept := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			if !containsFold(edit.RemoveTags, tag) {
+				kept = append(kept, tag)
+			}
+		}
+		tags = kept
+	}
+	return tags
+}
+
+func runBulkEdit(job *BulkEditJob, photoIDs []string) {
+	logger.Info("Bulk edit started", "id", job.ID, "username", job.Username, "Total", job.Total)
+
+	updateBulkEditJob(job, func(job *BulkEditJob) { job.Status = BulkEditRunning })
+
+	albums := make([]string, 0)
+	for _, photoID := range photoIDs {
+		if albumName, _, err := splitPhotoID(photoID); err == nil {
+			albums = append(albums, albumName)
+		}
+		undo, err := applyBulkEdit(photoID, job.Edit)
+		errs := make([]string, 0)
+		if err != nil {
+			errs = append(errs, photoID+": "+err.Error())
+		}
+		if undo != nil {
+			if err := saveBulkEditUndo(job, undo); err != nil {
+				logger.Error(err.Error(), "photoID", photoID)
+				errs = append(errs, photoID+": unable to record undo: "+err.Error())
+			}
+		}
+		updateBulkEditJob(job, func(job *BulkEditJob) {
+			job.Completed = job.Completed + 1
+			job.Errors = append(job.Errors, errs...)
+		})
+	}
+
+	if job.Edit.Album != nil {
+		albums = append(albums, *job.Edit.Album)
+	}
+	reindexAlbums(albums...)
+
+	updateBulkEditJob(job, func(job *BulkEditJob) {
+		job.Status = BulkEditDone
+		job.Finished = time.Now()
+	})
+
+	logger.Info("Bulk edit finished", "id", job.ID, "username", job.Username, "Total", job.Total)
+}
+
+// Once anything about the photo may have changed, the undo entry is returned
+// even if the edit then fails, so what was changed can still be put back.
+func applyBulkEdit(photoID string, edit *BulkEdit) (*BulkEditUndo, error) {
+	albumName, photoName, err := splitPhotoID(photoID)
+	if err != nil {
+		return nil, err
+	}
+
+	albumMetadataMutex.Lock()
+	defer albumMetadataMutex.Unlock()
+
+	if _, err := os.Stat(albumDir(albumName) + photoName); err != nil {
+		return nil, err
+	}
+
+	metadata := GetAlbumMetadata(albumName)
+
+	undo := new(BulkEditUndo)
+	undo.Album = albumName
+	undo.Name = photoName
+	if previous, ok := metadata[photoName]; ok {
+		copied := *previous
+		undo.Metadata = &copied
+	}
+
+	photoMetadata, ok := metadata[photoName]
+	if !ok {
+		photoMetadata = new(PhotoMetadata)
+		metadata[photoName] = photoMetadata
+	}
+
+	if edit.Caption != nil {
+		photoMetadata.Caption = *edit.Caption
+	}
+	photoMetadata.Tags = applyTagChanges(photoMetadata.Tags, edit)
+	if edit.People != nil {
+		photoMetadata.People = *edit.People
+	}
+	if edit.Date != nil {
+		photoMetadata.Date = *edit.Date
+	}
+
+	if edit.Album != nil && *edit.Album != albumName {
+		if err := movePhoto(albumName, photoName, *edit.Album); err != nil {
+			return nil, err
+		}
+		undo.NewAlbum = *edit.Album
+		delete(metadata, photoName)
+
+		newMetadata := GetAlbumMetadata(*edit.Album)
+		newMetadata[photoName] = photoMetadata
+		if err := SaveAlbumMetadata(*edit.Album, newMetadata); err != nil {
+			return undo, err
+		}
+	}
+
+	if err := SaveAlbumMetadata(albumName, metadata); err != nil {
+		return undo, err
+	}
+
+	return undo, nil
+}
+
+// Moves the original photo between albums along with its RAW partner and
+// what is kept for them under .site_photos. Albums on hold keep their
+// photos. Moves between gallery roots on different filesystems fail.
+func movePhoto(fromAlbum string, photoName string, toAlbum string) error {
+	logger.Debug("movePhoto", "fromAlbum", fromAlbum, "photoName", photoName, "toAlbum", toAlbum)
+
+	if IsAlbumHeld(fromAlbum) {
+		return errors.New("album is on hold: " + fromAlbum)
+	}
+
+	photoNames := []string{photoName}
+	if partner := rawPartner(fromAlbum, photoName); len(partner) > 0 {
+		photoNames = append(photoNames, partner)
+	}
+
+	for _, name := range photoNames {
+		if _, err := os.Stat(albumDir(toAlbum) + name); err == nil {
+			return errors.New("photo already exists in album " + toAlbum + ": " + name)
+		}
+	}
+
+	// The pair moves together or not at all
+	moved := make([]string, 0, len(photoNames))
+	for _, name := range photoNames {
+		if err := os.Rename(albumDir(fromAlbum)+name, albumDir(toAlbum)+name); err != nil {
+			logger.Error(err.Error())
+			for _, movedName := range moved {
+				if err := os.Rename(albumDir(toAlbum)+movedName, albumDir(fromAlbum)+movedName); err != nil {
+					logger.Error(err.Error())
+				}
+			}
+			return err
+		}
+		moved = append(moved, name)
+	}
+
+	for _, name := range photoNames {
+		forgetPhotoHash(fromAlbum, name)
+		moveSitePhotos(fromAlbum, name, toAlbum)
+	}
+
+	return nil
+}
+
+// Returns the RAW file paired with a JPG, or the JPG paired with a RAW file.
+func rawPartner(albumName string, photoName string) string {
+	photos, err := os.ReadDir(albumDir(albumName))
+	if err != nil {
+		logger.Error(err.Error())
+		return ""
+	}
+
+	base := rawBaseName(photoName)
+	raw, ok := findRawPairs(photos)[base]
+	if !ok {
+		return ""
+	}
+
+	if !isRawPhoto(photoName) {
+		return raw
+	}
+
+	for _, photo := range photos {
+		if !photo.IsDir() && jpg_re.FindStringIndex(photo.Name()) != nil && rawBaseName(photo.Name()) == base {
+			return photo.Name()
+		}
+	}
+
+	return ""
+}
+
+// Moves a photo's site photos, RAW preview, converted rendition and earlier
+// versions. These can all be recreated, so failures are logged rather than
+// returned, and anything that can't be moved is removed so it isn't served
+// for a later photo of the same name.
+func moveSitePhotos(fromAlbum string, photoName string, toAlbum string) {
+	fromDirPath := albumDir(fromAlbum) + ".site_photos/"
+
+	files, err := os.ReadDir(fromDirPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return
+	}
+
+	toDirPath, _ := findOrAddSitePhotoDir(strings.TrimSuffix(albumDir(toAlbum), "/"))
+	if len(toDirPath) == 0 {
+		return
+	}
+
+	base := strings.TrimSuffix(photoName, filepath.Ext(photoName))
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !(strings.HasPrefix(name, base+"-gp-") || name == base+"-rp.jpg" || name == photoName+"-or.jpg") {
+			continue
+		}
+		if err := os.Rename(fromDirPath+name, filepath.Join(toDirPath, name)); err != nil {
+			logger.Error(err.Error())
+			if err := os.Remove(fromDirPath + name); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+
+	fromVersions := strings.TrimSuffix(versionsDirPath(fromAlbum, photoName), "/")
+	if _, err := os.Stat(fromVersions); err != nil {
+		return
+	}
+	toVersions := strings.TrimSuffix(versionsDirPath(toAlbum, photoName), "/")
+	if err := os.MkdirAll(filepath.Dir(toVersions), 0755); err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	if err := os.Rename(fromVersions, toVersions); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// Puts one photo back where it was with its previous metadata. The caller
+// holds albumMetadataMutex.
+func undoBulkEditPhoto(undo *BulkEditUndo) []string {
+	errs := make([]string, 0)
+
+	if len(undo.NewAlbum) > 0 {
+		if err := movePhoto(undo.NewAlbum, undo.Name, undo.Album); err != nil {
+			return append(errs, undo.NewAlbum+"/"+undo.Name+": "+err.Error())
+		}
+		newMetadata := GetAlbumMetadata(undo.NewAlbum)
+		delete(newMetadata, undo.Name)
+		if err := SaveAlbumMetadata(undo.NewAlbum, newMetadata); err != nil {
+			errs = append(errs, undo.NewAlbum+"/"+undo.Name+": "+err.Error())
+		}
+	}
+
+	metadata := GetAlbumMetadata(undo.Album)
+	if undo.Metadata != nil {
+		metadata[undo.Name] = undo.Metadata
+	} else {
+		delete(metadata, undo.Name)
+	}
+	if err := SaveAlbumMetadata(undo.Album, metadata); err != nil {
+		errs = append(errs, undo.Album+"/"+undo.Name+": "+err.Error())
+	}
+
+	return errs
+}
+
+func UndoBulkEdit(id string) error {
+	logger.Debug("UndoBulkEdit", "id", id)
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("gallery database not available")
+	}
+
+	job := GetBulkEditJob(id)
+	if job == nil {
+		return errors.New("bulk edit job not found")
+	}
+
+	// Claim the job so it is only undone once
+	claimed := gdb.Model(&BulkEditJob{}).Where("id = ? AND status = ?", job.ID, BulkEditDone).Update("status", BulkEditUndoing)
+	if claimed.Error != nil {
+		logger.Error(claimed.Error.Error())
+		return claimed.Error
+	}
+	if claimed.RowsAffected == 0 {
+		return errors.New("only finished bulk edits can be undone")
+	}
+	job.Status = BulkEditUndoing
+
+	undos := make([]*BulkEditUndo, 0)
+	if err := gdb.Where("job_id = ?", job.ID).Order("id desc").Find(&undos).Error; err != nil {
+		logger.Error(err.Error())
+		updateBulkEditJob(job, func(job *BulkEditJob) { job.Status = BulkEditDone })
+		return err
+	}
+
+	errs := make([]string, 0)
+	albums := make([]string, 0)
+
+	albumMetadataMutex.Lock()
+	for _, undo := range undos {
+		albums = append(albums, undo.Album)
+		if len(undo.NewAlbum) > 0 {
+			albums = append(albums, undo.NewAlbum)
+		}
+		errs = append(errs, undoBulkEditPhoto(undo)...)
+
+		// Each entry is dropped once handled, so a restart part way through
+		// leaves only what is still to be undone
+		if err := gdb.Delete(undo).Error; err != nil {
+			logger.Error(err.Error())
+		}
+	}
+	albumMetadataMutex.Unlock()
+
+	reindexAlbums(albums...)
+
+	updateBulkEditJob(job, func(job *BulkEditJob) {
+		job.Status = BulkEditUndone
+		job.Errors = append(job.Errors, errs...)
+	})
+
+	logger.Info("Bulk edit undone", "id", id, "errors", len(errs))
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package gallery_db
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Points the gallery at a temporary root holding the given album files.
+func useTestGallery(t *testing.T, files map[string][]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for album, names := range files {
+		if err := os.MkdirAll(filepath.Join(dir, album), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(dir, album, name), []byte("photo"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	galleryRootsOnce.Do(func() {})
+	previous := galleryRoots
+	galleryRoots = []*GalleryRoot{{Name: "galleries", Path: dir + "/"}}
+	albumRootsMutex.Lock()
+	albumRoots = make(map[string]*GalleryRoot)
+	albumRootsMutex.Unlock()
+
+	t.Cleanup(func() { galleryRoots = previous })
+}
+
+func TestSplitPhotoID(t *testing.T) {
+	tests := []struct {
+		photoID string
+		album   string
+		name    string
+		valid   bool
+	}{
+		{"Beach/IMG_0001.jpg", "Beach", "IMG_0001.jpg", true},
+		{"Beach/IMG_0001.JPG", "Beach", "IMG_0001.JPG", true},
+		{"Beach/IMG_0001.CR2", "Beach", "IMG_0001.CR2", true},
+		{"Beach/IMG_0001.heic", "Beach", "IMG_0001.heic", true},
+		{"Beach", "", "", false},
+		{"Beach/", "", "", false},
+		{"/IMG_0001.jpg", "", "", false},
+		{"Beach/.site_photos", "", "", false},
+		{"Beach/metadata.json", "", "", false},
+		{"Beach/../Other/IMG_0001.jpg", "", "", false},
+		{"../Beach/IMG_0001.jpg", "", "", false},
+		{"./IMG_0001.jpg", "", "", false},
+		{`Beach/..\IMG_0001.jpg`, "", "", false},
+	}
+
+	for _, test := range tests {
+		album, name, err := splitPhotoID(test.photoID)
+		if test.valid != (err == nil) {
+			t.Errorf("splitPhotoID(%q) error = %v, want valid %v", test.photoID, err, test.valid)
+			continue
+		}
+		if album != test.album || name != test.name {
+			t.Errorf("splitPhotoID(%q) = %q, %q, want %q, %q", test.photoID, album, name, test.album, test.name)
+		}
+	}
+}
+
+func TestSelectBulkEditPhotos(t *testing.T) {
+	useTestGallery(t, map[string][]string{
+		"Beach":   {"IMG_0001.jpg", "IMG_0001.CR2", "IMG_0002.jpg", "notes.txt"},
+		"Private": {"IMG_0001.jpg"},
+	})
+
+	allowed := func(albumName string) bool { return albumName != "Private" }
+
+	tests := []struct {
+		name     string
+		edit     *BulkEdit
+		selected []string
+		valid    bool
+	}{
+		{
+			name:     "listed photos are kept in order, once",
+			edit:     &BulkEdit{PhotoIDs: []string{"Beach/IMG_0002.jpg", "Beach/IMG_0001.jpg", "Beach/IMG_0002.jpg"}},
+			selected: []string{"Beach/IMG_0002.jpg", "Beach/IMG_0001.jpg"},
+			valid:    true,
+		},
+		{
+			name:  "listed non-photos are refused",
+			edit:  &BulkEdit{PhotoIDs: []string{"Beach/.site_photos"}},
+			valid: false,
+		},
+		{
+			name:     "the filter skips paired RAW files, other files and albums that aren't allowed",
+			edit:     &BulkEdit{Filter: "name=IMG_0001.jpg"},
+			selected: []string{"Beach/IMG_0001.jpg"},
+			valid:    true,
+		},
+		{
+			name:     "the filter adds to listed photos",
+			edit:     &BulkEdit{PhotoIDs: []string{"Beach/IMG_0002.jpg"}, Filter: "album=Beach"},
+			selected: []string{"Beach/IMG_0002.jpg", "Beach/IMG_0001.jpg"},
+			valid:    true,
+		},
+		{
+			name:     "nothing in an album that isn't allowed matches",
+			edit:     &BulkEdit{Filter: "album=Private"},
+			selected: []string{},
+			valid:    true,
+		},
+		{
+			name:  "unknown filter keys are refused",
+			edit:  &BulkEdit{Filter: "camera=Canon"},
+			valid: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := SelectBulkEditPhotos(test.edit, allowed)
+			if test.valid != (err == nil) {
+				t.Fatalf("error = %v, want valid %v", err, test.valid)
+			}
+			if test.valid && !slices.Equal(selected, test.selected) {
+				t.Errorf("selected %q, want %q", selected, test.selected)
+			}
+		})
+	}
+}
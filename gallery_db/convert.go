@@ -0,0 +1,176 @@
+package gallery_db
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp"
+)
+
+// HEIC (iPhone), PNG and WebP originals are converted once into a full size
+// JPEG rendition in the site photo directory. Site photos are created from the
+// rendition, and browsers that can't display the original format are served
+// the rendition instead.
+var convert_expression = `\.(?i)(heic|heif|png|webp)$`
+var convert_re = regexp.MustCompile(convert_expression)
+
+var heic_expression = `\.(?i)(heic|heif)$`
+var heic_re = regexp.MustCompile(heic_expression)
+
+var convertMimeTypes = map[string]string{
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".png":  "image/png",
+	".webp": "image/webp",
+}
+
+func isConvertedPhoto(name string) bool {
+	return convert_re.FindStringIndex(name) != nil
+}
+
+func isSupportedPhoto(name string) bool {
+	return jpg_re.FindStringIndex(name) != nil || isRawPhoto(name) || isConvertedPhoto(name)
+}
+
+// Whether the file is a photo the gallery serves: a JPEG, RAW or converted
+// original, or a site photo.
+func IsGalleryPhoto(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".jpg") || isRawPhoto(name) || isConvertedPhoto(name)
+}
+
+func PhotoMimeType(name string) string {
+	return convertMimeTypes[strings.ToLower(filepath.Ext(name))]
+}
+
+// The Go image libraries can't decode HEIC so it is handed to libheif's
+// heif-convert, falling back to ImageMagick.
+func convertHEIC(sourcePath string, destPath string) error {
+	if path, err := exec.LookPath("heif-convert"); err == nil {
+		return exec.Command(path, "-q", "90", sourcePath, destPath).Run()
+	}
+	if path, err := exec.LookPath("magick"); err == nil {
+		return exec.Command(path, sourcePath, destPath).Run()
+	}
+	if path, err := exec.LookPath("convert"); err == nil {
+		return exec.Command(path, sourcePath, destPath).Run()
+	}
+	return errors.New("no HEIC converter found, install libheif (heif-convert) or ImageMagick")
+}
+
+func findOrAddJPEGRendition(photoFullPath string, photoName string, sitePhotoDirPath string) string {
+	logger.Debug("findOrAddJPEGRendition", "photoFullPath", photoFullPath, "photoName", photoName, "sitePhotoDirPath", sitePhotoDirPath)
+
+	// The extension stays in the name so IMG_1.png and IMG_1.heic don't share
+	// a rendition
+	renditionPath := sitePhotoDirPath + `/` + photoName + "-or.jpg"
+
+	if fi, err := os.Stat(renditionPath); err == nil && fi.Size() > 0 {
+		return renditionPath
+	}
+
+	if heic_re.FindStringIndex(photoName) != nil {
+		if err := convertHEIC(photoFullPath, renditionPath); err != nil {
+			logger.Error(err.Error(), "photoFullPath", photoFullPath)
+			return ""
+		}
+		return renditionPath
+	}
+
+	img, err := imaging.Open(photoFullPath, imaging.AutoOrientation(true))
+	if err != nil {
+		logger.Error(err.Error())
+		return ""
+	}
+
+	if err := imaging.Save(img, renditionPath, imaging.JPEGQuality(90)); err != nil {
+		logger.Error(err.Error())
+		return ""
+	}
+
+	return renditionPath
+}
+
+func findOrAddConvertedSitePhoto(photoPath string, photoName string, photoSize string) *Photo {
+	var pagePhoto *Photo = nil
+
+	logger.Debug("findOrAddConvertedSitePhoto", "photoPath", photoPath, "photoName", photoName)
+
+	if sitePhotoDirPath, sitePhotoDir := findOrAddSitePhotoDir(photoPath); len(sitePhotoDirPath) > 0 && sitePhotoDir != nil {
+		if foundSitePhotoPath, foundSitePhoto := findSitePhoto(sitePhotoDirPath, sitePhotoDir, &photoName, photoSize, "-gp"); len(foundSitePhotoPath) > 0 && foundSitePhoto != nil {
+			pagePhoto = new(Photo)
+			pagePhoto.Name = photoName
+			pagePhoto.Path = photoURL(foundSitePhotoPath)
+		} else if renditionPath := findOrAddJPEGRendition(photoPath+photoName, photoName, sitePhotoDirPath); len(renditionPath) > 0 {
+			if newSitePhotoPath, newSitePhoto := createSitePhoto(renditionPath, photoName, sitePhotoDirPath, sitePhotoDir, "-gp", photoSize); len(newSitePhotoPath) > 0 && newSitePhoto != nil {
+				pagePhoto = new(Photo)
+				pagePhoto.Name = photoName
+				pagePhoto.Path = photoURL(newSitePhotoPath)
+			}
+		}
+	}
+
+	return pagePhoto
+}
+
+// Returns the JPEG rendition of a converted original, creating it if needed.
+func GetJPEGRendition(albumName string, photoName string) string {
+	if !isValidName(albumName) || !isValidName(photoName) || !isConvertedPhoto(photoName) {
+		return ""
+	}
+
+	albumPath := albumDir(albumName)
+	if _, err := os.Stat(albumPath + photoName); err != nil {
+		return ""
+	}
+
+	if sitePhotoDirPath, sitePhotoDir := findOrAddSitePhotoDir(albumPath); len(sitePhotoDirPath) > 0 && sitePhotoDir != nil {
+		return findOrAddJPEGRendition(albumPath+photoName, photoName, sitePhotoDirPath)
+	}
+
+	return ""
+}
+
+// Picks the site photo pipeline for the original's format.
+func findOrAddAnySitePhoto(photoPath string, photoName string, photoSize string) *Photo {
+	switch {
+	case jpg_re.FindStringIndex(photoName) != nil:
+		return findOrAddSitePhoto(photoPath, photoName, photoSize)
+	case isRawPhoto(photoName):
+		return findOrAddRawSitePhoto(photoPath, photoName, photoSize)
+	case isConvertedPhoto(photoName):
+		return findOrAddConvertedSitePhoto(photoPath, photoName, photoSize)
+	}
+	return nil
+}
+
+func findFirstConverted(albumPath string, album os.DirEntry) (string, os.FileInfo) {
+	logger.Debug("findFirstConverted", "albumPath", albumPath, "album.Name()", album.Name())
+
+	if album.IsDir() {
+		albumFullPath := albumPath + album.Name() + `/`
+		photos, err := os.ReadDir(albumFullPath)
+		if err != nil {
+			logger.Error(err.Error())
+			return "", nil
+		}
+
+		for _, photo := range photos {
+			if !photo.IsDir() && isConvertedPhoto(photo.Name()) {
+				fi, err := os.Stat(albumFullPath + photo.Name())
+				if err != nil {
+					logger.Error(err.Error())
+					return "", nil
+				}
+				if fi.Size() > 0 {
+					return albumFullPath + photo.Name(), fi
+				}
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,339 @@
+package gallery_db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hashes are cached per album in .site_photos/hashes.json and recomputed only
+// when an original's size or modification time changes.
+type photoHash struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type DuplicateGroup struct {
+	Hash   string   `json:"hash"`
+	Size   int64    `json:"size"`
+	Photos []string `json:"photos"`
+}
+
+var photoHashMutex sync.Mutex
+
+func hashesPath(albumName string) string {
+	return albumDir(albumName) + ".site_photos/hashes.json"
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readPhotoHashes(albumName string) map[string]*photoHash {
+	hashes := make(map[string]*photoHash)
+
+	jsonData, err := os.ReadFile(hashesPath(albumName))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return hashes
+	}
+
+	if err := json.Unmarshal(jsonData, &hashes); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return hashes
+}
+
+// Drops a photo from the album's hash cache after its original is replaced,
+// rather than trusting the size and modification time to have changed.
+func forgetPhotoHash(albumName string, photoName string) {
+	photoHashMutex.Lock()
+	defer photoHashMutex.Unlock()
+
+	hashes := readPhotoHashes(albumName)
+	if _, ok := hashes[photoName]; !ok {
+		return
+	}
+	delete(hashes, photoName)
+
+	jsonData, err := json.MarshalIndent(hashes, "", "    ")
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	if err := os.WriteFile(hashesPath(albumName), jsonData, 0644); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// Returns the SHA-256 of every supported original in the album, updating the
+// album's hash cache as needed.
+func GetAlbumPhotoHashes(albumName string) map[string]string {
+	logger.Debug("GetAlbumPhotoHashes()", "albumName", albumName)
+
+	albumPath := albumDir(albumName)
+	photos, err := os.ReadDir(albumPath)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil
+	}
+
+	photoHashMutex.Lock()
+	defer photoHashMutex.Unlock()
+
+	cached := readPhotoHashes(albumName)
+	hashes := make(map[string]*photoHash)
+	changed := false
+
+	for _, photo := range photos {
+		if photo.IsDir() || !isSupportedPhoto(photo.Name()) {
+			continue
+		}
+
+		fi, err := photo.Info()
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		if entry, ok := cached[photo.Name()]; ok && entry.Size == fi.Size() && entry.ModTime.Equal(fi.ModTime()) {
+			hashes[photo.Name()] = entry
+			continue
+		}
+
+		hash, err := hashFile(albumPath + photo.Name())
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		hashes[photo.Name()] = &photoHash{Hash: hash, Size: fi.Size(), ModTime: fi.ModTime()}
+		changed = true
+	}
+
+	if changed || len(hashes) != len(cached) {
+		if sitePhotoPath, _ := findOrAddSitePhotoDir(albumPath); len(sitePhotoPath) > 0 {
+			if jsonData, err := json.MarshalIndent(hashes, "", "    "); err != nil {
+				logger.Error(err.Error())
+			} else if err := os.WriteFile(hashesPath(albumName), jsonData, 0644); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+
+	result := make(map[string]string)
+	for name, entry := range hashes {
+		result[name] = entry.Hash
+	}
+
+	return result
+}
+
+// Groups photos with identical content across all albums. The first photo of
+// each group, by album and name, is the canonical copy.
+func FindDuplicatePhotos() []*DuplicateGroup {
+	logger.Debug("FindDuplicatePhotos()")
+
+	albums, err := readGallery()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil
+	}
+
+	groups := make(map[string]*DuplicateGroup)
+
+	for _, album := range albums {
+		if !album.IsDir() {
+			continue
+		}
+
+		albumHashes := GetAlbumPhotoHashes(album.Name())
+		names := make([]string, 0, len(albumHashes))
+		for name := range albumHashes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			hash := albumHashes[name]
+			group, ok := groups[hash]
+			if !ok {
+				group = &DuplicateGroup{Hash: hash, Photos: make([]string, 0)}
+				if fi, err := os.Stat(albumDir(album.Name()) + name); err == nil {
+					group.Size = fi.Size()
+				}
+				groups[hash] = group
+			}
+			group.Photos = append(group.Photos, album.Name()+"/"+name)
+		}
+	}
+
+	duplicates := make([]*DuplicateGroup, 0)
+	for _, group := range groups {
+		if len(group.Photos) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].Photos[0] < duplicates[j].Photos[0] })
+
+	if len(duplicates) > 0 {
+		logger.Info("Duplicate photos found", "groups", len(duplicates))
+	}
+
+	return duplicates
+}
+
+// Removes every copy in the duplicate group except keepPhotoID. Removed
+// originals are moved to removed_duplicates beside their gallery root, e.g.
+// ../photos/removed_duplicates, rather than deleted, under a name of their own
+// when an earlier removal already used theirs. Their site photos, metadata
+// and cached hash go with them, and their earlier versions are moved beside
+// them.
+func RemoveDuplicatePhotos(hash string, keepPhotoID string) ([]string, error) {
+	logger.Debug("RemoveDuplicatePhotos()", "hash", hash, "keepPhotoID", keepPhotoID)
+
+	var group *DuplicateGroup
+	for _, duplicates := range FindDuplicatePhotos() {
+		if duplicates.Hash == hash {
+			group = duplicates
+		}
+	}
+	if group == nil {
+		return nil, errors.New("duplicate group not found")
+	}
+	if !slices.Contains(group.Photos, keepPhotoID) {
+		return nil, errors.New("photo to keep is not part of the duplicate group")
+	}
+
+	for _, photoID := range group.Photos {
+		if albumName, _, err := splitPhotoID(photoID); err == nil && photoID != keepPhotoID && IsAlbumHeld(albumName) {
+			return nil, errors.New("album is on hold: " + albumName)
+		}
+	}
+
+	removed := make([]string, 0)
+	albums := make([]string, 0)
+	defer func() { reindexAlbums(albums...) }()
+
+	for _, photoID := range group.Photos {
+		if photoID == keepPhotoID {
+			continue
+		}
+
+		albumName, photoName, err := splitPhotoID(photoID)
+		if err != nil {
+			return removed, err
+		}
+
+		removedDir := filepath.Join(albumRoot(albumName).Path, "..", "removed_duplicates", albumName)
+		if err := os.MkdirAll(removedDir, 0755); err != nil {
+			logger.Error(err.Error())
+			return removed, err
+		}
+
+		// A RAW pair shares its site photos, so they stay for the partner
+		partner := rawPartner(albumName, photoName)
+
+		removedPath := removedDuplicatePath(removedDir, photoName, hash)
+		if err := os.Rename(albumDir(albumName)+photoName, removedPath); err != nil {
+			logger.Error(err.Error())
+			return removed, err
+		}
+		forgetRemovedPhoto(albumName, photoName, removedPath, len(partner) == 0)
+
+		logger.Info("Removed duplicate photo", "photoID", photoID, "keepPhotoID", keepPhotoID)
+		removed = append(removed, photoID)
+		albums = append(albums, albumName)
+	}
+
+	return removed, nil
+}
+
+// Names the removed copy after the photo, adding part of its hash and then a
+// counter when a copy of that name was removed before.
+func removedDuplicatePath(removedDir string, photoName string, hash string) string {
+	ext := filepath.Ext(photoName)
+	base := strings.TrimSuffix(photoName, ext) + "-" + hash[:min(8, len(hash))]
+
+	path := filepath.Join(removedDir, photoName)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		if i == 1 {
+			path = filepath.Join(removedDir, base+ext)
+		} else {
+			path = filepath.Join(removedDir, base+"-"+strconv.Itoa(i)+ext)
+		}
+	}
+}
+
+// Drops what was kept for a photo that has been removed: its cached hash, its
+// site photos unless a RAW partner still uses them, its converted rendition
+// and its metadata. Earlier versions are moved beside the removed original.
+// Failures are logged, as for regenerateSitePhotos.
+func forgetRemovedPhoto(albumName string, photoName string, removedPath string, withSitePhotos bool) {
+	forgetPhotoHash(albumName, photoName)
+
+	sitePhotoDirPath := albumDir(albumName) + ".site_photos/"
+	files, err := os.ReadDir(sitePhotoDirPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Error(err.Error())
+	}
+
+	base := strings.TrimSuffix(photoName, filepath.Ext(photoName))
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() {
+			continue
+		}
+		if name == photoName+"-or.jpg" || (withSitePhotos && (strings.HasPrefix(name, base+"-gp-") || name == base+"-rp.jpg")) {
+			if err := os.Remove(sitePhotoDirPath + name); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+
+	versions := strings.TrimSuffix(versionsDirPath(albumName, photoName), "/")
+	if _, err := os.Stat(versions); err == nil {
+		if err := os.Rename(versions, removedPath+"-versions"); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	albumMetadataMutex.Lock()
+	defer albumMetadataMutex.Unlock()
+
+	metadata := GetAlbumMetadata(albumName)
+	if _, ok := metadata[photoName]; ok {
+		delete(metadata, photoName)
+		if err := SaveAlbumMetadata(albumName, metadata); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package gallery_db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemovedDuplicatePath(t *testing.T) {
+	dir := t.TempDir()
+	hash := "0123456789abcdef"
+
+	tests := []struct {
+		existing []string
+		want     string
+	}{
+		{nil, "IMG_0001.jpg"},
+		{[]string{"IMG_0001.jpg"}, "IMG_0001-01234567.jpg"},
+		{[]string{"IMG_0001-01234567.jpg"}, "IMG_0001-01234567-2.jpg"},
+		{[]string{"IMG_0001-01234567-2.jpg"}, "IMG_0001-01234567-3.jpg"},
+	}
+
+	for _, test := range tests {
+		for _, name := range test.existing {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("photo"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if path := removedDuplicatePath(dir, "IMG_0001.jpg", hash); path != filepath.Join(dir, test.want) {
+			t.Errorf("removedDuplicatePath = %q, want %q", path, filepath.Join(dir, test.want))
+		}
+	}
+}
+
+func TestRemoveDuplicatePhotos(t *testing.T) {
+	// Every test photo has the same content
+	useTestGallery(t, map[string][]string{
+		"Beach":  {"IMG_0001.jpg"},
+		"Copies": {"IMG_0001.jpg", "IMG_0002.jpg"},
+	})
+	root := galleryRoots[0].Path
+
+	if err := os.MkdirAll(root+"Copies/.site_photos", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"IMG_0001-gp-1024.jpg", "IMG_0002-gp-1024.jpg"} {
+		if err := os.WriteFile(root+"Copies/.site_photos/"+name, []byte("site photo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveAlbumMetadata("Copies", map[string]*PhotoMetadata{"IMG_0001.jpg": {Caption: "copy"}, "IMG_0002.jpg": {Caption: "copy"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	groups := FindDuplicatePhotos()
+	if len(groups) != 1 || len(groups[0].Photos) != 3 {
+		t.Fatalf("duplicate groups = %+v, want one of three photos", groups)
+	}
+
+	removed, err := RemoveDuplicatePhotos(groups[0].Hash, "Beach/IMG_0001.jpg")
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("RemoveDuplicatePhotos = %q, %v, want two photos removed", removed, err)
+	}
+
+	// Removing a copy of the same name again keeps the first one
+	if err := os.WriteFile(root+"Copies/IMG_0001.jpg", []byte("photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveDuplicatePhotos(groups[0].Hash, "Beach/IMG_0001.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	removedDir := filepath.Join(root, "..", "removed_duplicates", "Copies")
+	for _, name := range []string{"IMG_0001.jpg", "IMG_0002.jpg", "IMG_0001-" + groups[0].Hash[:8] + ".jpg"} {
+		if _, err := os.Stat(filepath.Join(removedDir, name)); err != nil {
+			t.Errorf("removed copy %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"IMG_0001-gp-1024.jpg", "IMG_0002-gp-1024.jpg"} {
+		if _, err := os.Stat(root + "Copies/.site_photos/" + name); !os.IsNotExist(err) {
+			t.Errorf("site photo %s kept: %v", name, err)
+		}
+	}
+	if metadata := GetAlbumMetadata("Copies"); len(metadata) != 0 {
+		t.Errorf("metadata = %+v, want none", metadata)
+	}
+	if _, err := os.Stat(root + "Beach/IMG_0001.jpg"); err != nil {
+		t.Errorf("kept photo: %v", err)
+	}
+}
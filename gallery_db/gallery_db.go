@@ -1,13 +1,13 @@
 package gallery_db
 
 import (
-	"bufio"
-	"image"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/jeffereydecker/blazemarker/blaze_log"
 	"github.com/disintegration/imaging"
@@ -52,18 +52,30 @@ var sitePhotoFormatsHeight = map[string]int{
 }
 
 type Album struct {
-	Index          int      `json:"index"`
-	Name           string   `json:"name"`
-	Path           string   `json:"path"`
-	SitePhotos     []*Photo `json:"site_photos"`
-	OriginalPhotos []*Photo `json:"original_photos"`
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	Index          int       `json:"index"`
+	Name           string    `gorm:"uniqueIndex" json:"name"`
+	Path           string    `json:"path"`
+	HasRawPairs    bool      `json:"has_raw_pairs"`
+	Root           string    `json:"root"`
+	PhotoCount     int       `json:"photo_count"`
+	IndexedAt      time.Time `json:"indexed_at"`
+	SitePhotos     []*Photo  `gorm:"-" json:"site_photos"`
+	OriginalPhotos []*Photo  `gorm:"-" json:"original_photos"`
 }
 
 type Photo struct {
-	ID    uint   `gorm:"primaryKey" json:"id"`
-	Index int    `json:"index"`
-	Name  string `json:"name"`
-	Path  string `json:"path"`
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	AlbumName string `gorm:"index" json:"album_name,omitempty"`
+	Index     int    `json:"index"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	SitePath  string `json:"site_path,omitempty"`
+	RawPath   string `json:"raw_path,omitempty"`
+	Caption   string `json:"caption,omitempty"`
+	Hash      string `gorm:"index" json:"hash,omitempty"`
+	Root      string `json:"root,omitempty"`
+	Versions  int    `json:"versions,omitempty"` // Earlier versions kept by edits
 }
 
 var jpg_expression = `\.(?i)jpg`
@@ -187,34 +199,23 @@ func createSitePhoto(imageSourcePath string, imageName string, imageDestPath str
 	// maximize CPU usage for maximum performance
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	img, err := imaging.Open(imageSourcePath)
+	// Portrait photos are often stored sideways with an EXIF orientation, so
+	// rotate before deciding the shape. Site photos are saved upright
+	// without EXIF.
+	img, err := imaging.Open(imageSourcePath, imaging.AutoOrientation(true))
 	if err != nil {
 		logger.Error(err.Error())
 		return "", nil
 	}
 
-	inputFile, err := os.Open(imageSourcePath)
-	if err != nil {
-		logger.Error(err.Error())
-		return "", nil
-	}
-
-	defer inputFile.Close()
-
-	reader := bufio.NewReader(inputFile)
-	config, format, err := image.DecodeConfig(reader)
-	if err != nil {
-		logger.Error(err.Error())
-		return "", nil
-	}
+	bounds := img.Bounds()
 
 	logger.Debug("image details",
 		"imageSourcePath", imageSourcePath,
-		"config.Width", config.Width,
-		"config.Height", config.Height,
-		"format", format)
+		"bounds.Dx()", bounds.Dx(),
+		"bounds.Dy()", bounds.Dy())
 
-	landscape := config.Width > config.Height
+	landscape := bounds.Dx() > bounds.Dy()
 
 	// resize image from 1000 to 500 while preserving the aspect ration
 	// Supported resize filters: NearestNeighbor, Box, Linear, Hermite, MitchellNetravali,
@@ -268,6 +269,18 @@ func findOrAddAlbumCover(albumPath string, album os.DirEntry, photoSize string)
 			albumCoverPath, albumCover := createSitePhoto(photoPath, photo.Name(), sitePhotoPath, sitePhotoDir, "-ac", photoSize)
 			return albumCoverPath, albumCover
 		}
+		if convertedPath, converted := findFirstConverted(albumPath, album); len(convertedPath) > 0 && converted != nil {
+			if renditionPath := findOrAddJPEGRendition(convertedPath, converted.Name(), sitePhotoPath); len(renditionPath) > 0 {
+				albumCoverPath, albumCover := createSitePhoto(renditionPath, converted.Name(), sitePhotoPath, sitePhotoDir, "-ac", photoSize)
+				return albumCoverPath, albumCover
+			}
+		}
+		if rawPath, raw := findFirstRaw(albumPath, album); len(rawPath) > 0 && raw != nil {
+			if previewPath := findOrAddRawPreview(rawPath, raw.Name(), sitePhotoPath); len(previewPath) > 0 {
+				albumCoverPath, albumCover := createSitePhoto(previewPath, raw.Name(), sitePhotoPath, sitePhotoDir, "-ac", photoSize)
+				return albumCoverPath, albumCover
+			}
+		}
 	}
 
 	return "", nil
@@ -284,13 +297,13 @@ func findOrAddSitePhoto(photoPath string, photoName string, photoSize string) *P
 
 			pagePhoto = new(Photo)
 			pagePhoto.Name = photoName
-			pagePhoto.Path = foundSitePhotoPath
+			pagePhoto.Path = photoURL(foundSitePhotoPath)
 
 		} else {
 			if newSitePhotoPath, newSitePhoto := createSitePhoto(photoPath+photoName, photoName, sitePhotoDirPath, sitePhotoDir, "-gp", photoSize); len(newSitePhotoPath) > 0 && newSitePhoto != nil {
 				pagePhoto = new(Photo)
 				pagePhoto.Name = photoName
-				pagePhoto.Path = newSitePhotoPath
+				pagePhoto.Path = photoURL(newSitePhotoPath)
 			}
 		}
 
@@ -299,10 +312,9 @@ func findOrAddSitePhoto(photoPath string, photoName string, photoSize string) *P
 	return pagePhoto
 }
 
+// Merges the albums of every gallery root.
 func GetAllAlbums() []*Album {
-	photoPath := "../photos/galleries/"
-
-	files, err := os.ReadDir(photoPath)
+	files, err := readGallery()
 	if err != nil {
 		logger.Error(err.Error())
 		return nil
@@ -314,13 +326,9 @@ func GetAllAlbums() []*Album {
 	albums := make([]*Album, 0)
 	for _, fileAlbum := range files {
 		if fileAlbum.IsDir() {
-			if albumCoverPath, albumCover := findOrAddAlbumCover(photoPath, fileAlbum, "-xs"); len(albumCoverPath) > 0 && albumCover != nil {
-				//TODO: wider use of album
-				album := new(Album)
+			if album := newAlbum(fileAlbum); album != nil {
 				album.Index = albumIndex
 				albumIndex = albumIndex + 1
-				album.Name = fileAlbum.Name()
-				album.Path = albumCoverPath
 				albums = append(albums, album)
 			}
 		}
@@ -329,9 +337,44 @@ func GetAllAlbums() []*Album {
 	return albums
 }
 
+// Albums without a photo to make a cover from are left out.
+func newAlbum(fileAlbum os.DirEntry) *Album {
+	root := albumRoot(fileAlbum.Name())
+	albumCoverPath, albumCover := findOrAddAlbumCover(root.Path, fileAlbum, "-xs")
+	if len(albumCoverPath) == 0 || albumCover == nil {
+		return nil
+	}
+
+	//TODO: wider use of album
+	album := new(Album)
+	album.Name = fileAlbum.Name()
+	album.Path = photoURL(albumCoverPath)
+	album.HasRawPairs = HasRawPairs(album.Name)
+	album.Root = root.Name
+	return album
+}
+
+// Reads one album from disk, or returns nil if it is gone.
+func readAlbum(albumName string) *Album {
+	if !isValidName(albumName) {
+		return nil
+	}
+
+	fi, err := os.Stat(albumDir(albumName))
+	if err != nil || !fi.IsDir() {
+		return nil
+	}
+
+	return newAlbum(fs.FileInfoToDirEntry(fi))
+}
+
 func GetAlbumPhotos(albumName string) (sitePhotos []*Photo, originalPhotos []*Photo) {
+	if !isValidName(albumName) {
+		return nil, nil
+	}
 
-	path := "../photos/galleries/" + albumName + "/"
+	path := albumDir(albumName)
+	root := GetAlbumRoot(albumName)
 
 	logger.Debug("GetAlbumPhoto()", "albumName", albumName, "path", path)
 
@@ -346,19 +389,88 @@ func GetAlbumPhotos(albumName string) (sitePhotos []*Photo, originalPhotos []*Ph
 
 	var photoIndex = 0
 
+	rawPairs := findRawPairs(photos)
+	albumMetadata := GetAlbumMetadata(albumName)
+	albumHashes := GetAlbumPhotoHashes(albumName)
+	albumVersions := albumPhotoVersions(albumName)
+
 	for _, photo := range photos {
-		if !photo.IsDir() && jpg_re.FindStringIndex(photo.Name()) != nil {
-			if pagePhoto := findOrAddSitePhoto(path, photo.Name(), "-xl"); pagePhoto != nil {
-				pagePhoto.Index = photoIndex
-				sitePhotos = append(sitePhotos, pagePhoto)
-				pageOriginalPhoto := new(Photo)
-				pageOriginalPhoto.Name = photo.Name()
-				pageOriginalPhoto.Path = path + photo.Name()
-				pageOriginalPhoto.Index = photoIndex
-				originalPhotos = append(originalPhotos, pageOriginalPhoto)
-				photoIndex = photoIndex + 1
+		if photo.IsDir() {
+			continue
+		}
+
+		var pagePhoto *Photo = nil
+		rawName := ""
+
+		if jpg_re.FindStringIndex(photo.Name()) != nil {
+			pagePhoto = findOrAddSitePhoto(path, photo.Name(), "-xl")
+			rawName = rawPairs[rawBaseName(photo.Name())]
+		} else if isRawPhoto(photo.Name()) && len(rawPairs[rawBaseName(photo.Name())]) == 0 {
+			pagePhoto = findOrAddRawSitePhoto(path, photo.Name(), "-xl")
+			rawName = photo.Name()
+		} else if isConvertedPhoto(photo.Name()) {
+			pagePhoto = findOrAddConvertedSitePhoto(path, photo.Name(), "-xl")
+		}
+
+		if pagePhoto != nil {
+			pagePhoto.Index = photoIndex
+			if metadata, ok := albumMetadata[photo.Name()]; ok {
+				pagePhoto.Caption = metadata.Caption
+			}
+			sitePhotos = append(sitePhotos, pagePhoto)
+			pageOriginalPhoto := new(Photo)
+			pageOriginalPhoto.Name = photo.Name()
+			pageOriginalPhoto.Path = photoURL(path + photo.Name())
+			pageOriginalPhoto.Hash = albumHashes[photo.Name()]
+			pageOriginalPhoto.Versions = albumVersions[photo.Name()]
+			pageOriginalPhoto.Root = root
+			if len(rawName) > 0 {
+				pageOriginalPhoto.RawPath = photoURL(path + rawName)
 			}
+			// Browsers can't display RAW files, so RAW-only photos use the site
+			// photo in the carousel and keep the RAW as the download.
+			if isRawPhoto(photo.Name()) {
+				pageOriginalPhoto.Path = pagePhoto.Path
+			}
+			pageOriginalPhoto.Index = photoIndex
+			originalPhotos = append(originalPhotos, pageOriginalPhoto)
+			photoIndex = photoIndex + 1
 		}
 	}
 	return sitePhotos, originalPhotos
 }
+
+func HasRawPairs(albumName string) bool {
+	photos, err := os.ReadDir(albumDir(albumName))
+	if err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+
+	return len(findRawPairs(photos)) > 0
+}
+
+// Returns the full paths of the album's original photos, including RAW files,
+// without creating any site photos.
+func GetAlbumOriginalPaths(albumName string) []string {
+	if !isValidName(albumName) {
+		return nil
+	}
+
+	path := albumDir(albumName)
+
+	photos, err := os.ReadDir(path)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil
+	}
+
+	originals := make([]string, 0)
+	for _, photo := range photos {
+		if !photo.IsDir() && isSupportedPhoto(photo.Name()) {
+			originals = append(originals, path+photo.Name())
+		}
+	}
+
+	return originals
+}
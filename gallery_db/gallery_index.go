@@ -0,0 +1,387 @@
+package gallery_db
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"gorm.io/gorm"
+)
+
+// The gallery index persists albums and photos so large galleries can be
+// served without walking the filesystem on every page view. It is rebuilt by
+// SyncGalleryIndex, periodically and on demand.
+type GalleryIndexStatus struct {
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Albums   int       `json:"albums"`
+	Photos   int       `json:"photos"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Albums that appear or gain photos after the index was first built are
+// reported to the handlers registered with OnAlbumChange.
+type AlbumChange struct {
+	Album string
+	New   bool
+	Added int
+}
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+
+	albumChangeHandlers []func(change *AlbumChange)
+
+	galleryIndexStatus      GalleryIndexStatus
+	galleryIndexStatusMutex sync.Mutex
+
+	albumIndexMutex sync.Mutex
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&Album{}, &Photo{}, &AlbumHold{}, &SmartAlbumPhoto{}, &BulkEditJob{}, &BulkEditUndo{}); err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	migrateAlbumHolds(db)
+	recoverBulkEdits(db)
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+// Handlers must be registered before the indexer starts.
+func OnAlbumChange(handler func(change *AlbumChange)) {
+	albumChangeHandlers = append(albumChangeHandlers, handler)
+}
+
+func reportAlbumChange(change *AlbumChange) {
+	for _, handler := range albumChangeHandlers {
+		handler(change)
+	}
+}
+
+func GetGalleryIndexStatus() GalleryIndexStatus {
+	galleryIndexStatusMutex.Lock()
+	defer galleryIndexStatusMutex.Unlock()
+
+	return galleryIndexStatus
+}
+
+// Walks the gallery, creating any missing site photos, and replaces the
+// indexed albums and photos with what is on disk.
+func SyncGalleryIndex() error {
+	galleryIndexStatusMutex.Lock()
+	if galleryIndexStatus.Running {
+		galleryIndexStatusMutex.Unlock()
+		return errors.New("gallery index sync already running")
+	}
+	galleryIndexStatus = GalleryIndexStatus{Running: true, Started: time.Now()}
+	galleryIndexStatusMutex.Unlock()
+
+	logger.Info("Gallery index sync started")
+
+	albums, photos, err := syncGalleryIndex()
+
+	galleryIndexStatusMutex.Lock()
+	galleryIndexStatus.Running = false
+	galleryIndexStatus.Finished = time.Now()
+	galleryIndexStatus.Albums = albums
+	galleryIndexStatus.Photos = photos
+	if err != nil {
+		galleryIndexStatus.Error = err.Error()
+	}
+	galleryIndexStatusMutex.Unlock()
+
+	logger.Info("Gallery index sync finished", "albums", albums, "photos", photos)
+
+	return err
+}
+
+func syncGalleryIndex() (int, int, error) {
+	gdb := getDB()
+	if gdb == nil {
+		return 0, 0, errors.New("gallery database not available")
+	}
+
+	albums := GetAllAlbums()
+	if albums == nil {
+		return 0, 0, errors.New("unable to read gallery")
+	}
+
+	photoCount := 0
+	albumNames := make([]string, 0, len(albums))
+	indexed := IsGalleryIndexed()
+
+	for _, album := range albums {
+		count, err := indexAlbum(gdb, album, indexed)
+		if err != nil {
+			return len(albumNames), photoCount, err
+		}
+
+		albumNames = append(albumNames, album.Name)
+		photoCount = photoCount + count
+	}
+
+	// Drop albums that were removed from disk
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		if len(albumNames) == 0 {
+			if err := tx.Where("1 = 1").Delete(&Photo{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("1 = 1").Delete(&SmartAlbumPhoto{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("1 = 1").Delete(&Album{}).Error
+		}
+		if err := tx.Where("album_name NOT IN ?", albumNames).Delete(&Photo{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("album_name NOT IN ?", albumNames).Delete(&SmartAlbumPhoto{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name NOT IN ?", albumNames).Delete(&Album{}).Error
+	})
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	return len(albumNames), photoCount, err
+}
+
+// Replaces one album's indexed photos with what is on disk and returns how
+// many it has. Albums are indexed one at a time so a full sync and an album
+// reindex can't interleave and leave stale photos behind.
+func indexAlbum(gdb *gorm.DB, album *Album, indexed bool) (int, error) {
+	albumIndexMutex.Lock()
+	defer albumIndexMutex.Unlock()
+
+	sitePhotos, originalPhotos := GetAlbumPhotos(album.Name)
+
+	photos := make([]*Photo, 0, len(originalPhotos))
+	for i, originalPhoto := range originalPhotos {
+		photo := *originalPhoto
+		photo.AlbumName = album.Name
+		if i < len(sitePhotos) {
+			photo.SitePath = sitePhotos[i].Path
+			photo.Caption = sitePhotos[i].Caption
+		}
+		photos = append(photos, &photo)
+	}
+
+	album.PhotoCount = len(photos)
+	album.IndexedAt = time.Now()
+
+	var change *AlbumChange
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		existing := new(Album)
+		if err := tx.Where("name = ?", album.Name).First(existing).Error; err == nil {
+			album.ID = existing.ID
+			if album.PhotoCount > existing.PhotoCount {
+				change = &AlbumChange{Album: album.Name, Added: album.PhotoCount - existing.PhotoCount}
+			}
+		} else if errors.Is(err, gorm.ErrRecordNotFound) {
+			change = &AlbumChange{Album: album.Name, New: true, Added: album.PhotoCount}
+		} else {
+			return err
+		}
+
+		if err := tx.Save(album).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("album_name = ?", album.Name).Delete(&Photo{}).Error; err != nil {
+			return err
+		}
+		if len(photos) > 0 {
+			if err := tx.Create(&photos).Error; err != nil {
+				return err
+			}
+		}
+		return indexAlbumSmartAlbums(tx, album.Name, photos)
+	})
+	if err != nil {
+		logger.Error(err.Error(), "album.Name", album.Name)
+		return 0, err
+	}
+
+	if indexed && change != nil {
+		reportAlbumChange(change)
+	}
+
+	return len(photos), nil
+}
+
+// Brings one album's index up to date after it changes on disk, so covers,
+// edits, moves and removals show without waiting for the next full sync. An
+// album that is no longer on disk is dropped from the index.
+func ReindexAlbum(albumName string) error {
+	logger.Debug("ReindexAlbum()", "albumName", albumName)
+
+	// Pages read the gallery from disk until the first full sync
+	if !IsGalleryIndexed() {
+		return nil
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("gallery database not available")
+	}
+
+	if album := readAlbum(albumName); album != nil {
+		_, err := indexAlbum(gdb, album, true)
+		return err
+	}
+
+	albumIndexMutex.Lock()
+	defer albumIndexMutex.Unlock()
+
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("album_name = ?", albumName).Delete(&Photo{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("album_name = ?", albumName).Delete(&SmartAlbumPhoto{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name = ?", albumName).Delete(&Album{}).Error
+	})
+	if err != nil {
+		logger.Error(err.Error(), "albumName", albumName)
+	}
+
+	return err
+}
+
+// Reindexes each album once, logging rather than returning failures since
+// the next full sync catches up.
+func reindexAlbums(albumNames ...string) {
+	done := make(map[string]bool)
+	for _, albumName := range albumNames {
+		if done[albumName] {
+			continue
+		}
+		done[albumName] = true
+
+		if err := ReindexAlbum(albumName); err != nil {
+			logger.Error(err.Error(), "albumName", albumName)
+		}
+	}
+}
+
+func StartGalleryIndexer(interval time.Duration) {
+	go func() {
+		for {
+			if err := SyncGalleryIndex(); err != nil {
+				logger.Error(err.Error())
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// The index is used once a sync has completed at least once.
+func IsGalleryIndexed() bool {
+	gdb := getDB()
+	if gdb == nil {
+		return false
+	}
+
+	var count int64
+	if err := gdb.Model(&Album{}).Count(&count).Error; err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+
+	return count > 0
+}
+
+func GetIndexedAlbums() []*Album {
+	albums := make([]*Album, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return albums
+	}
+
+	if err := gdb.Order("name").Find(&albums).Error; err != nil {
+		logger.Error(err.Error())
+		return albums
+	}
+
+	for index, album := range albums {
+		album.Index = index
+		album.Path = photoURL(album.Path)
+	}
+
+	return albums
+}
+
+func GetIndexedAlbum(albumName string) *Album {
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	album := new(Album)
+	if err := gdb.Where("name = ?", albumName).First(album).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+	album.Path = photoURL(album.Path)
+
+	return album
+}
+
+func GetIndexedAlbumPhotos(albumName string) (sitePhotos []*Photo, originalPhotos []*Photo) {
+	logger.Debug("GetIndexedAlbumPhotos()", "albumName", albumName)
+
+	gdb := getDB()
+	if gdb == nil {
+		return nil, nil
+	}
+
+	photos := make([]*Photo, 0)
+	if err := gdb.Where("album_name = ?", albumName).Order("`index`").Find(&photos).Error; err != nil {
+		logger.Error(err.Error())
+		return nil, nil
+	}
+
+	sitePhotos, originalPhotos = splitIndexedPhotos(photos)
+
+	return sitePhotos, originalPhotos
+}
+
+// Indexed photos keep the site photo alongside the original; pages expect
+// them as two lists.
+func splitIndexedPhotos(photos []*Photo) (sitePhotos []*Photo, originalPhotos []*Photo) {
+	sitePhotos = make([]*Photo, 0, len(photos))
+	originalPhotos = make([]*Photo, 0, len(photos))
+
+	for _, photo := range photos {
+		photo.Path, photo.SitePath, photo.RawPath = photoURL(photo.Path), photoURL(photo.SitePath), photoURL(photo.RawPath)
+
+		sitePhoto := new(Photo)
+		sitePhoto.ID = photo.ID
+		sitePhoto.Index = photo.Index
+		sitePhoto.Name = photo.Name
+		sitePhoto.Path = photo.SitePath
+		sitePhoto.Caption = photo.Caption
+		sitePhotos = append(sitePhotos, sitePhoto)
+		originalPhotos = append(originalPhotos, photo)
+	}
+
+	return sitePhotos, originalPhotos
+}
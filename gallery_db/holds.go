@@ -0,0 +1,135 @@
+package gallery_db
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Photos in albums on hold can't be removed or moved out until an admin lifts
+// the hold. Holds are kept in the database rather than with the album, where
+// the reason would be served along with the photos.
+type AlbumHold struct {
+	Album    string    `gorm:"primaryKey" json:"album"`
+	Reason   string    `json:"reason"`
+	PlacedBy string    `json:"placed_by"`
+	PlacedAt time.Time `json:"placed_at"`
+}
+
+func IsAlbumHeld(albumName string) bool {
+	gdb := getDB()
+	if gdb == nil {
+		return false
+	}
+
+	var count int64
+	if err := gdb.Model(&AlbumHold{}).Where("album = ?", albumName).Count(&count).Error; err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+
+	return count > 0
+}
+
+func GetAlbumHolds() []*AlbumHold {
+	holds := make([]*AlbumHold, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return holds
+	}
+
+	if err := gdb.Order("album").Find(&holds).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return holds
+}
+
+func PlaceAlbumHold(albumName string, reason string, username string) (*AlbumHold, error) {
+	if !isValidName(albumName) {
+		return nil, errors.New("invalid album name: " + albumName)
+	}
+	if info, err := os.Stat(albumDir(albumName)); err != nil || !info.IsDir() {
+		return nil, errors.New("album not found: " + albumName)
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return nil, errors.New("database not available")
+	}
+
+	hold := &AlbumHold{Album: albumName, Reason: reason, PlacedBy: username, PlacedAt: time.Now()}
+	if err := gdb.Save(hold).Error; err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+func LiftAlbumHold(albumName string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("database not available")
+	}
+
+	result := gdb.Delete(&AlbumHold{}, "album = ?", albumName)
+	if result.Error != nil {
+		logger.Error(result.Error.Error())
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("album not on hold: " + albumName)
+	}
+
+	return nil
+}
+
+// Holds used to be kept in album.json. Moves any found there into the
+// database and rewrites the file without them.
+func migrateAlbumHolds(gdb *gorm.DB) {
+	files, err := readGallery()
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	albumSettingsMutex.Lock()
+	defer albumSettingsMutex.Unlock()
+
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+
+		jsonData, err := os.ReadFile(albumSettingsPath(file.Name()))
+		if err != nil {
+			continue
+		}
+
+		legacy := struct {
+			Hold       bool   `json:"hold"`
+			HoldReason string `json:"hold_reason"`
+		}{}
+		if err := json.Unmarshal(jsonData, &legacy); err != nil || (!legacy.Hold && len(legacy.HoldReason) == 0) {
+			continue
+		}
+
+		if legacy.Hold {
+			hold := &AlbumHold{Album: file.Name(), Reason: legacy.HoldReason, PlacedAt: time.Now()}
+			if err := gdb.Save(hold).Error; err != nil {
+				logger.Error(err.Error())
+				continue
+			}
+		}
+
+		if err := SaveAlbumSettings(file.Name(), GetAlbumSettings(file.Name())); err != nil {
+			continue
+		}
+		logger.Info("Moved album hold out of album.json", "album", file.Name())
+	}
+}
@@ -0,0 +1,205 @@
+package gallery_db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The integrity check compares what is on disk with what was recorded about
+// it: cached hashes of unchanged originals, the gallery site photo of every
+// original and the gallery index. Missing site photos are regenerated and
+// index rows for vanished photos are dropped; everything else is reported.
+type IntegrityReport struct {
+	Running            bool      `json:"running"`
+	Started            time.Time `json:"started"`
+	Finished           time.Time `json:"finished"`
+	Albums             int       `json:"albums"`
+	Photos             int       `json:"photos"`
+	HashMismatches     []string  `json:"hash_mismatches"`
+	RegeneratedPhotos  []string  `json:"regenerated_photos"`
+	MissingSitePhotos  []string  `json:"missing_site_photos"`
+	RemovedIndexRows   []string  `json:"removed_index_rows"`
+	OrphanedSitePhotos []string  `json:"orphaned_site_photos"`
+	Error              string    `json:"error,omitempty"`
+}
+
+var (
+	integrityReport      IntegrityReport
+	integrityReportMutex sync.Mutex
+)
+
+func GetIntegrityReport() IntegrityReport {
+	integrityReportMutex.Lock()
+	defer integrityReportMutex.Unlock()
+
+	return integrityReport
+}
+
+// Problems that need an admin: corrupted originals, site photos that couldn't
+// be recreated and site photos left behind by removed originals.
+func (report *IntegrityReport) Problems() int {
+	return len(report.HashMismatches) + len(report.MissingSitePhotos) + len(report.OrphanedSitePhotos)
+}
+
+func CheckIntegrity() (*IntegrityReport, error) {
+	integrityReportMutex.Lock()
+	if integrityReport.Running {
+		integrityReportMutex.Unlock()
+		return nil, errors.New("integrity check already running")
+	}
+	integrityReport = IntegrityReport{Running: true, Started: time.Now()}
+	integrityReportMutex.Unlock()
+
+	logger.Info("Integrity check started")
+
+	report := &IntegrityReport{
+		Started:            time.Now(),
+		HashMismatches:     make([]string, 0),
+		RegeneratedPhotos:  make([]string, 0),
+		MissingSitePhotos:  make([]string, 0),
+		RemovedIndexRows:   make([]string, 0),
+		OrphanedSitePhotos: make([]string, 0),
+	}
+
+	err := checkIntegrity(report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	report.Finished = time.Now()
+
+	integrityReportMutex.Lock()
+	integrityReport = *report
+	integrityReportMutex.Unlock()
+
+	if report.Problems() > 0 {
+		logger.Warn("Integrity check found problems", "hash_mismatches", len(report.HashMismatches), "missing_site_photos", len(report.MissingSitePhotos), "orphaned_site_photos", len(report.OrphanedSitePhotos))
+	}
+	logger.Info("Integrity check finished", "albums", report.Albums, "photos", report.Photos, "regenerated", len(report.RegeneratedPhotos), "removed_index_rows", len(report.RemovedIndexRows))
+
+	return report, err
+}
+
+func checkIntegrity(report *IntegrityReport) error {
+	albums, err := readGallery()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	for _, album := range albums {
+		if !album.IsDir() {
+			continue
+		}
+		report.Albums = report.Albums + 1
+		checkAlbumIntegrity(album.Name(), report)
+	}
+
+	return checkIndexIntegrity(report)
+}
+
+func checkAlbumIntegrity(albumName string, report *IntegrityReport) {
+	albumPath := albumDir(albumName)
+
+	photos, err := os.ReadDir(albumPath)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	// Hashes are only compared for originals whose size and modification time
+	// still match the cache, so a mismatch means the content changed unnoticed
+	photoHashMutex.Lock()
+	cached := readPhotoHashes(albumName)
+	photoHashMutex.Unlock()
+
+	prefixes := make(map[string]bool)
+
+	for _, photo := range photos {
+		if photo.IsDir() || !isSupportedPhoto(photo.Name()) {
+			continue
+		}
+		report.Photos = report.Photos + 1
+		photoID := albumName + "/" + photo.Name()
+		prefixes[strings.TrimSuffix(photo.Name(), filepath.Ext(photo.Name()))] = true
+
+		if fi, err := photo.Info(); err == nil {
+			if entry, ok := cached[photo.Name()]; ok && entry.Size == fi.Size() && entry.ModTime.Equal(fi.ModTime()) {
+				if hash, err := hashFile(albumPath + photo.Name()); err != nil {
+					logger.Error(err.Error())
+				} else if hash != entry.Hash {
+					logger.Error("Photo hash mismatch", "photoID", photoID, "cached", entry.Hash, "hash", hash)
+					report.HashMismatches = append(report.HashMismatches, photoID)
+				}
+			}
+		}
+
+		sitePhotoName := strings.TrimSuffix(photo.Name(), filepath.Ext(photo.Name())) + "-gp-xl.jpg"
+		if _, err := os.Stat(albumPath + ".site_photos/" + sitePhotoName); err == nil {
+			continue
+		}
+		if sitePhoto := findOrAddAnySitePhoto(albumPath, photo.Name(), "-xl"); sitePhoto != nil {
+			report.RegeneratedPhotos = append(report.RegeneratedPhotos, photoID)
+		} else {
+			report.MissingSitePhotos = append(report.MissingSitePhotos, photoID)
+		}
+	}
+
+	sitePhotos, err := os.ReadDir(albumPath + ".site_photos")
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return
+	}
+
+	for _, sitePhoto := range sitePhotos {
+		prefix, _, found := strings.Cut(sitePhoto.Name(), "-gp-")
+		if sitePhoto.IsDir() || !found {
+			continue
+		}
+		if !prefixes[prefix] {
+			report.OrphanedSitePhotos = append(report.OrphanedSitePhotos, albumName+"/.site_photos/"+sitePhoto.Name())
+		}
+	}
+}
+
+// Drops index rows for photos that are no longer on disk. The next index sync
+// would do the same, but until then they'd be served as broken images.
+func checkIndexIntegrity(report *IntegrityReport) error {
+	if !IsGalleryIndexed() {
+		return nil
+	}
+
+	photos := make([]*Photo, 0)
+	if err := getDB().Find(&photos).Error; err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	for _, photo := range photos {
+		if _, err := os.Stat(albumDir(photo.AlbumName) + photo.Name); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := getDB().Delete(photo).Error; err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+		report.RemovedIndexRows = append(report.RemovedIndexRows, photo.AlbumName+"/"+photo.Name)
+	}
+
+	return nil
+}
+
+func StartIntegrityChecker(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := CheckIntegrity(); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}()
+}
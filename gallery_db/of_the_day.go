@@ -0,0 +1,78 @@
+package gallery_db
+
+import (
+	"hash/fnv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func daySeed(day time.Time) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(day.Format("2006-01-02")))
+	return h.Sum64()
+}
+
+// Picks one photo per day, the same for every visitor. The gallery index is
+// used when available, otherwise an album and then a photo are picked from
+// disk. Photos in unpublished albums are never picked.
+func GetPhotoOfTheDay(day time.Time) *Photo {
+	seed := daySeed(day)
+
+	if IsGalleryIndexed() {
+		hidden := unpublishedAlbums()
+		publishedPhotos := func() *gorm.DB {
+			query := getDB().Model(&Photo{})
+			if len(hidden) > 0 {
+				query = query.Where("album_name NOT IN ?", hidden)
+			}
+			return query
+		}
+
+		var count int64
+		if err := publishedPhotos().Count(&count).Error; err != nil {
+			logger.Error(err.Error())
+			return nil
+		}
+		if count == 0 {
+			return nil
+		}
+
+		photo := new(Photo)
+		if err := publishedPhotos().Order("id").Offset(int(seed % uint64(count))).Limit(1).Find(photo).Error; err != nil {
+			logger.Error(err.Error())
+			return nil
+		}
+		return photo
+	}
+
+	files, err := readGallery()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil
+	}
+
+	albums := make([]string, 0)
+	for _, file := range files {
+		if file.IsDir() && IsAlbumPublished(file.Name()) {
+			albums = append(albums, file.Name())
+		}
+	}
+	if len(albums) == 0 {
+		return nil
+	}
+
+	albumName := albums[seed%uint64(len(albums))]
+	sitePhotos, originalPhotos := GetAlbumPhotos(albumName)
+	if len(sitePhotos) == 0 {
+		return nil
+	}
+
+	index := (seed / uint64(len(albums))) % uint64(len(sitePhotos))
+	photo := originalPhotos[index]
+	photo.AlbumName = albumName
+	photo.SitePath = sitePhotos[index].Path
+	photo.Caption = sitePhotos[index].Caption
+
+	return photo
+}
@@ -0,0 +1,163 @@
+package gallery_db
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Canon (CR2), Nikon (NEF) and Sony (ARW) files are all TIFF based and carry
+// one or more embedded JPEG previews. The largest one is used as the source for
+// site photos while the RAW file stays the downloadable original.
+var raw_expression = `\.(?i)(cr2|nef|arw)$`
+var raw_re = regexp.MustCompile(raw_expression)
+
+var jpegSOI = []byte{0xFF, 0xD8, 0xFF}
+
+func isRawPhoto(name string) bool {
+	return raw_re.FindStringIndex(name) != nil
+}
+
+func rawBaseName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+func findLargestEmbeddedJPEG(data []byte) (image.Image, error) {
+	var largest image.Image
+	largestArea := 0
+
+	for offset := 0; ; {
+		index := bytes.Index(data[offset:], jpegSOI)
+		if index < 0 {
+			break
+		}
+		start := offset + index
+		offset = start + len(jpegSOI)
+
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data[start:]))
+		if err != nil || config.Width*config.Height <= largestArea {
+			continue
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(data[start:]))
+		if err != nil {
+			continue
+		}
+
+		largest = img
+		largestArea = config.Width * config.Height
+	}
+
+	if largest == nil {
+		return nil, errors.New("no embedded JPEG preview found")
+	}
+
+	return largest, nil
+}
+
+// Extracts the embedded preview of a RAW photo into the site photo directory
+// once and returns its path.
+func findOrAddRawPreview(rawFullPath string, rawName string, sitePhotoDirPath string) string {
+	logger.Debug("findOrAddRawPreview", "rawFullPath", rawFullPath, "rawName", rawName, "sitePhotoDirPath", sitePhotoDirPath)
+
+	previewPath := sitePhotoDirPath + `/` + strings.TrimSuffix(rawName, filepath.Ext(rawName)) + "-rp.jpg"
+
+	if fi, err := os.Stat(previewPath); err == nil && fi.Size() > 0 {
+		return previewPath
+	}
+
+	data, err := os.ReadFile(rawFullPath)
+	if err != nil {
+		logger.Error(err.Error())
+		return ""
+	}
+
+	preview, err := findLargestEmbeddedJPEG(data)
+	if err != nil {
+		logger.Error(err.Error(), "rawFullPath", rawFullPath)
+		return ""
+	}
+
+	if err := imaging.Save(preview, previewPath); err != nil {
+		logger.Error(err.Error())
+		return ""
+	}
+
+	return previewPath
+}
+
+func findOrAddRawSitePhoto(photoPath string, photoName string, photoSize string) *Photo {
+	var pagePhoto *Photo = nil
+
+	logger.Debug("findOrAddRawSitePhoto", "photoPath", photoPath, "photoName", photoName)
+
+	if sitePhotoDirPath, sitePhotoDir := findOrAddSitePhotoDir(photoPath); len(sitePhotoDirPath) > 0 && sitePhotoDir != nil {
+		if foundSitePhotoPath, foundSitePhoto := findSitePhoto(sitePhotoDirPath, sitePhotoDir, &photoName, photoSize, "-gp"); len(foundSitePhotoPath) > 0 && foundSitePhoto != nil {
+			pagePhoto = new(Photo)
+			pagePhoto.Name = photoName
+			pagePhoto.Path = photoURL(foundSitePhotoPath)
+		} else if previewPath := findOrAddRawPreview(photoPath+photoName, photoName, sitePhotoDirPath); len(previewPath) > 0 {
+			if newSitePhotoPath, newSitePhoto := createSitePhoto(previewPath, photoName, sitePhotoDirPath, sitePhotoDir, "-gp", photoSize); len(newSitePhotoPath) > 0 && newSitePhoto != nil {
+				pagePhoto = new(Photo)
+				pagePhoto.Name = photoName
+				pagePhoto.Path = photoURL(newSitePhotoPath)
+			}
+		}
+	}
+
+	return pagePhoto
+}
+
+// RAW+JPEG pairs share a base name (IMG_0001.CR2 and IMG_0001.JPG). The pair
+// is shown once using the camera JPEG, with the RAW kept as the original.
+func findRawPairs(photos []os.DirEntry) map[string]string {
+	jpgs := make(map[string]bool)
+	for _, photo := range photos {
+		if !photo.IsDir() && jpg_re.FindStringIndex(photo.Name()) != nil {
+			jpgs[rawBaseName(photo.Name())] = true
+		}
+	}
+
+	pairs := make(map[string]string)
+	for _, photo := range photos {
+		if !photo.IsDir() && isRawPhoto(photo.Name()) && jpgs[rawBaseName(photo.Name())] {
+			pairs[rawBaseName(photo.Name())] = photo.Name()
+		}
+	}
+
+	return pairs
+}
+
+func findFirstRaw(albumPath string, album os.DirEntry) (string, os.FileInfo) {
+	logger.Debug("findFirstRaw", "albumPath", albumPath, "album.Name()", album.Name())
+
+	if album.IsDir() {
+		albumFullPath := albumPath + album.Name() + `/`
+		photos, err := os.ReadDir(albumFullPath)
+		if err != nil {
+			logger.Error(err.Error())
+			return "", nil
+		}
+
+		for _, photo := range photos {
+			if !photo.IsDir() && isRawPhoto(photo.Name()) {
+				fi, err := os.Stat(albumFullPath + photo.Name())
+				if err != nil {
+					logger.Error(err.Error())
+					return "", nil
+				}
+				if fi.Size() > 0 {
+					return albumFullPath + photo.Name(), fi
+				}
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,62 @@
+package gallery_db
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Site photos and renditions made before EXIF orientation was applied may
+// be sideways. Raw previews are kept, they are extracted as is.
+var reprocessed_re = regexp.MustCompile(`(-(gp|ac)-..|-or)\.(?i)jpg$`)
+
+// Removes every album's site photos and renditions and creates them again
+// from the originals, keeping chosen album covers. Returns the number of
+// albums reprocessed.
+func ReprocessSitePhotos() (int, error) {
+	files, err := readGallery()
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+
+		sitePhotoDirPath := albumDir(file.Name()) + ".site_photos"
+		photos, err := os.ReadDir(sitePhotoDirPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logger.Error(err.Error())
+			}
+			continue
+		}
+
+		logger.Info("Reprocessing site photos", "album", file.Name())
+
+		for _, photo := range photos {
+			if !photo.IsDir() && reprocessed_re.FindStringIndex(photo.Name()) != nil {
+				if err := os.Remove(filepath.Join(sitePhotoDirPath, photo.Name())); err != nil {
+					logger.Error(err.Error())
+				}
+			}
+		}
+
+		if cover := GetAlbumSettings(file.Name()).Cover; len(cover) > 0 {
+			if _, err := SetAlbumCover(file.Name(), cover); err != nil {
+				logger.Error(err.Error(), "album", file.Name())
+			}
+		}
+		GetAlbumPhotos(file.Name())
+
+		count = count + 1
+	}
+
+	// Albums without a chosen cover get one from their first photo again
+	GetAllAlbums()
+
+	return count, nil
+}
@@ -0,0 +1,159 @@
+package gallery_db
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Albums can live under several gallery roots, e.g. a local folder and a NAS
+// mount, configured with BLAZEMARKER_GALLERY_ROOTS as comma separated
+// name=path pairs. Each root is served at /photos/<name>/, so names are
+// limited to letters, digits, dashes and underscores. Album names are unique
+// across roots: when two roots have an album of the same name, the one in the
+// root listed first is used.
+type GalleryRoot struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+const defaultGalleryRoot = "../photos/galleries/"
+
+var rootName_re = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+var (
+	galleryRoots     []*GalleryRoot
+	galleryRootsOnce sync.Once
+
+	albumRoots      = make(map[string]*GalleryRoot)
+	albumRootsMutex sync.Mutex
+)
+
+func loadGalleryRoots() {
+	for _, pair := range strings.Split(os.Getenv("BLAZEMARKER_GALLERY_ROOTS"), ",") {
+		name, path, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !rootName_re.MatchString(name) || len(path) == 0 {
+			if len(pair) > 0 {
+				logger.Error("Invalid gallery root, expected name=path", "root", pair)
+			}
+			continue
+		}
+		path = filepath.Clean(path) + "/"
+		if slices.ContainsFunc(galleryRoots, func(root *GalleryRoot) bool { return root.Name == name || root.Path == path }) {
+			logger.Error("Duplicate gallery root", "name", name, "path", path)
+			continue
+		}
+
+		galleryRoots = append(galleryRoots, &GalleryRoot{Name: name, Path: path})
+	}
+
+	if len(galleryRoots) == 0 {
+		galleryRoots = []*GalleryRoot{{Name: "galleries", Path: defaultGalleryRoot}}
+	}
+}
+
+func GetGalleryRoots() []*GalleryRoot {
+	galleryRootsOnce.Do(loadGalleryRoots)
+
+	return galleryRoots
+}
+
+// Where the root is served, e.g. /photos/galleries/.
+func (root *GalleryRoot) URLPrefix() string {
+	return "/photos/" + root.Name + "/"
+}
+
+// Photos are found by their path on disk and handed to pages by URL: a path
+// under a gallery root becomes a URL under its prefix. Anything else, such as
+// a URL stored in the gallery index, is returned unchanged.
+func photoURL(path string) string {
+	for _, root := range GetGalleryRoots() {
+		if rest, found := strings.CutPrefix(path, root.Path); found {
+			return root.URLPrefix() + rest
+		}
+	}
+	return path
+}
+
+// Returns the album directories of every root, sorted by name.
+func readGallery() ([]os.DirEntry, error) {
+	albums := make([]os.DirEntry, 0)
+	roots := make(map[string]*GalleryRoot)
+
+	var firstErr error
+	for _, root := range GetGalleryRoots() {
+		files, err := os.ReadDir(root.Path)
+		if err != nil {
+			logger.Error(err.Error(), "root", root.Name)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		for _, file := range files {
+			if !file.IsDir() {
+				continue
+			}
+			if other, ok := roots[file.Name()]; ok {
+				logger.Warn("Album in more than one gallery root", "album", file.Name(), "root", other.Name, "ignored", root.Name)
+				continue
+			}
+			roots[file.Name()] = root
+			albums = append(albums, file)
+		}
+	}
+
+	// Only fail when no root could be read
+	if len(roots) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+
+	albumRootsMutex.Lock()
+	albumRoots = roots
+	albumRootsMutex.Unlock()
+
+	slices.SortFunc(albums, func(a, b os.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	return albums, nil
+}
+
+// Finds the root holding the album. Unknown albums resolve to the first
+// root, so lookups of missing albums fail there.
+func albumRoot(albumName string) *GalleryRoot {
+	albumRootsMutex.Lock()
+	root, ok := albumRoots[albumName]
+	albumRootsMutex.Unlock()
+	if ok {
+		return root
+	}
+
+	roots := GetGalleryRoots()
+	for _, root := range roots {
+		if fi, err := os.Stat(root.Path + albumName); err == nil && fi.IsDir() {
+			albumRootsMutex.Lock()
+			albumRoots[albumName] = root
+			albumRootsMutex.Unlock()
+			return root
+		}
+	}
+
+	return roots[0]
+}
+
+// The album's directory, with a trailing slash.
+func albumDir(albumName string) string {
+	return albumRoot(albumName).Path + albumName + "/"
+}
+
+func GetAlbumDir(albumName string) string {
+	return albumDir(albumName)
+}
+
+// The name of the root holding the album.
+func GetAlbumRoot(albumName string) string {
+	return albumRoot(albumName).Name
+}
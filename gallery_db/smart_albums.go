@@ -0,0 +1,499 @@
+package gallery_db
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// A smart album is a saved filter over every album's photos, e.g.
+// "tag=beach AND year=2024" or "person=Alice". Matches are worked out as
+// albums are indexed, so viewing one doesn't walk the gallery.
+//
+// Supported keys: tag, person, year, album, name
+//
+// Only its owner or an admin can change or delete a smart album. Those saved
+// before smart albums had owners have none, leaving them to admins.
+type SmartAlbum struct {
+	Name   string `json:"name"`
+	Filter string `json:"filter"`
+	Owner  string `json:"owner"`
+}
+
+// An indexed photo matching a smart album.
+type SmartAlbumPhoto struct {
+	SmartAlbum string `gorm:"primaryKey"`
+	AlbumName  string `gorm:"primaryKey"`
+	Name       string `gorm:"primaryKey"`
+}
+
+type PhotoMetadata struct {
+	Caption string   `json:"caption"`
+	Tags    []string `json:"tags"`
+	People  []string `json:"people"`
+	Date    string   `json:"date"`
+}
+
+type filterCondition struct {
+	Key   string
+	Value string
+}
+
+var smartAlbumsFile = "../photos/smart_albums.json"
+var smartAlbumsMutex sync.Mutex
+
+var smartAlbumKeys = map[string]bool{
+	"tag":    true,
+	"person": true,
+	"year":   true,
+	"album":  true,
+	"name":   true,
+}
+
+func parseFilter(filter string) ([]filterCondition, error) {
+	conditions := make([]filterCondition, 0)
+
+	for _, term := range strings.Split(filter, " AND ") {
+		key, value, found := strings.Cut(strings.TrimSpace(term), "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if !found || len(value) == 0 {
+			return nil, errors.New("invalid filter term: " + term)
+		}
+		if !smartAlbumKeys[key] {
+			return nil, errors.New("unknown filter key: " + key)
+		}
+
+		conditions = append(conditions, filterCondition{Key: key, Value: value})
+	}
+
+	return conditions, nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Use the metadata date when present, otherwise the album naming convention
+// (2004-06-25-Roll034403) and finally the file modification time.
+func photoYear(albumName string, photoPath string, metadata *PhotoMetadata) string {
+	if metadata != nil && len(metadata.Date) >= 4 {
+		return metadata.Date[:4]
+	}
+
+	if len(albumName) >= 4 && strings.Trim(albumName[:4], "0123456789") == "" {
+		return albumName[:4]
+	}
+
+	if fi, err := os.Stat(photoPath); err == nil {
+		return fi.ModTime().Format("2006")
+	}
+
+	return ""
+}
+
+func matchesFilter(conditions []filterCondition, albumName string, photoName string, photoPath string, metadata *PhotoMetadata) bool {
+	for _, condition := range conditions {
+		switch condition.Key {
+		case "tag":
+			if metadata == nil || !containsFold(metadata.Tags, condition.Value) {
+				return false
+			}
+		case "person":
+			if metadata == nil || !containsFold(metadata.People, condition.Value) {
+				return false
+			}
+		case "year":
+			if photoYear(albumName, photoPath, metadata) != condition.Value {
+				return false
+			}
+		case "album":
+			if !strings.EqualFold(albumName, condition.Value) {
+				return false
+			}
+		case "name":
+			if !strings.EqualFold(photoName, condition.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func metadataPath(albumName string) string {
+	return albumDir(albumName) + ".site_photos/metadata.json"
+}
+
+func GetAlbumMetadata(albumName string) map[string]*PhotoMetadata {
+	metadata := make(map[string]*PhotoMetadata)
+
+	jsonData, err := os.ReadFile(metadataPath(albumName))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return metadata
+	}
+
+	if err := json.Unmarshal(jsonData, &metadata); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return metadata
+}
+
+func GetSmartAlbums() []*SmartAlbum {
+	smartAlbumsMutex.Lock()
+	defer smartAlbumsMutex.Unlock()
+
+	return readSmartAlbums()
+}
+
+func readSmartAlbums() []*SmartAlbum {
+	smartAlbums := make([]*SmartAlbum, 0)
+
+	jsonData, err := os.ReadFile(smartAlbumsFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return smartAlbums
+	}
+
+	if err := json.Unmarshal(jsonData, &smartAlbums); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return smartAlbums
+}
+
+func GetSmartAlbum(name string) *SmartAlbum {
+	for _, smartAlbum := range GetSmartAlbums() {
+		if smartAlbum.Name == name {
+			return smartAlbum
+		}
+	}
+	return nil
+}
+
+// Adds the smart album or replaces the filter of an existing one with the
+// same name.
+func SaveSmartAlbum(smartAlbum *SmartAlbum) error {
+	logger.Debug("SaveSmartAlbum", "Name", smartAlbum.Name, "Filter", smartAlbum.Filter)
+
+	if len(smartAlbum.Name) == 0 {
+		return errors.New("smart album name is required")
+	}
+	if _, err := parseFilter(smartAlbum.Filter); err != nil {
+		return err
+	}
+
+	if err := saveSmartAlbum(smartAlbum); err != nil {
+		return err
+	}
+
+	return indexSmartAlbum(smartAlbum)
+}
+
+func saveSmartAlbum(smartAlbum *SmartAlbum) error {
+	smartAlbumsMutex.Lock()
+	defer smartAlbumsMutex.Unlock()
+
+	smartAlbums := readSmartAlbums()
+	replaced := false
+	for i, existing := range smartAlbums {
+		if existing.Name == smartAlbum.Name {
+			smartAlbums[i] = smartAlbum
+			replaced = true
+		}
+	}
+	if !replaced {
+		smartAlbums = append(smartAlbums, smartAlbum)
+	}
+
+	return writeSmartAlbums(smartAlbums)
+}
+
+func DeleteSmartAlbum(name string) error {
+	logger.Debug("DeleteSmartAlbum", "name", name)
+
+	if err := deleteSmartAlbum(name); err != nil {
+		return err
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	albumIndexMutex.Lock()
+	defer albumIndexMutex.Unlock()
+
+	return gdb.Where("smart_album = ?", name).Delete(&SmartAlbumPhoto{}).Error
+}
+
+func deleteSmartAlbum(name string) error {
+	smartAlbumsMutex.Lock()
+	defer smartAlbumsMutex.Unlock()
+
+	smartAlbums := readSmartAlbums()
+	kept := make([]*SmartAlbum, 0, len(smartAlbums))
+	for _, smartAlbum := range smartAlbums {
+		if smartAlbum.Name != name {
+			kept = append(kept, smartAlbum)
+		}
+	}
+
+	return writeSmartAlbums(kept)
+}
+
+func writeSmartAlbums(smartAlbums []*SmartAlbum) error {
+	jsonData, err := json.MarshalIndent(smartAlbums, "", "    ")
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	if err := os.WriteFile(smartAlbumsFile, jsonData, 0644); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Works out which of an album's indexed photos match each smart album,
+// replacing what was recorded for the album before. Called as the album is
+// indexed, inside its transaction.
+func indexAlbumSmartAlbums(tx *gorm.DB, albumName string, photos []*Photo) error {
+	if err := tx.Where("album_name = ?", albumName).Delete(&SmartAlbumPhoto{}).Error; err != nil {
+		return err
+	}
+
+	matches := make([]*SmartAlbumPhoto, 0)
+	albumMetadata := GetAlbumMetadata(albumName)
+	albumPath := albumDir(albumName)
+
+	for _, smartAlbum := range GetSmartAlbums() {
+		conditions, err := parseFilter(smartAlbum.Filter)
+		if err != nil {
+			logger.Error(err.Error(), "smartAlbum.Name", smartAlbum.Name)
+			continue
+		}
+
+		for _, photo := range photos {
+			if matchesFilter(conditions, albumName, photo.Name, filepath.Join(albumPath, photo.Name), albumMetadata[photo.Name]) {
+				matches = append(matches, &SmartAlbumPhoto{SmartAlbum: smartAlbum.Name, AlbumName: albumName, Name: photo.Name})
+			}
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil
+	}
+
+	return tx.Create(&matches).Error
+}
+
+// Works out a new or changed smart album's matches from the indexed photos.
+// Before the first full sync there is nothing to match; the sync does it.
+func indexSmartAlbum(smartAlbum *SmartAlbum) error {
+	if !IsGalleryIndexed() {
+		return nil
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("gallery database not available")
+	}
+
+	conditions, err := parseFilter(smartAlbum.Filter)
+	if err != nil {
+		return err
+	}
+
+	albumIndexMutex.Lock()
+	defer albumIndexMutex.Unlock()
+
+	albumNames := make([]string, 0)
+	if err := gdb.Model(&Album{}).Order("name").Pluck("name", &albumNames).Error; err != nil {
+		return err
+	}
+
+	matches := make([]*SmartAlbumPhoto, 0)
+	for _, albumName := range albumNames {
+		photoNames := make([]string, 0)
+		if err := gdb.Model(&Photo{}).Where("album_name = ?", albumName).Pluck("name", &photoNames).Error; err != nil {
+			return err
+		}
+
+		albumMetadata := GetAlbumMetadata(albumName)
+		albumPath := albumDir(albumName)
+		for _, photoName := range photoNames {
+			if matchesFilter(conditions, albumName, photoName, filepath.Join(albumPath, photoName), albumMetadata[photoName]) {
+				matches = append(matches, &SmartAlbumPhoto{SmartAlbum: smartAlbum.Name, AlbumName: albumName, Name: photoName})
+			}
+		}
+	}
+
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("smart_album = ?", smartAlbum.Name).Delete(&SmartAlbumPhoto{}).Error; err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&matches, 500).Error
+	})
+}
+
+// Returns the indexed photos matching a smart album, leaving out albums that
+// aren't published. A limit of 0 returns every match.
+func getIndexedSmartAlbumPhotos(name string, unpublished []string, limit int) []*Photo {
+	photos := make([]*Photo, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return photos
+	}
+
+	query := gdb.Model(&Photo{}).Select("photos.*").
+		Joins("JOIN smart_album_photos ON smart_album_photos.album_name = photos.album_name AND smart_album_photos.name = photos.name").
+		Where("smart_album_photos.smart_album = ? AND photos.site_path <> ''", name)
+	if len(unpublished) > 0 {
+		query = query.Where("photos.album_name NOT IN ?", unpublished)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	if err := query.Order("photos.album_name, photos.`index`").Find(&photos).Error; err != nil {
+		logger.Error(err.Error(), "name", name)
+	}
+
+	return photos
+}
+
+// Returns the site and original photos of every album photo matching the
+// smart album filter, in the same shape as GetAlbumPhotos.
+func GetSmartAlbumPhotos(name string) (sitePhotos []*Photo, originalPhotos []*Photo) {
+	logger.Debug("GetSmartAlbumPhotos()", "name", name)
+
+	smartAlbum := GetSmartAlbum(name)
+	if smartAlbum == nil {
+		logger.Warn("Smart album not found", "name", name)
+		return nil, nil
+	}
+
+	if !IsGalleryIndexed() {
+		return findSmartAlbumPhotos(smartAlbum)
+	}
+
+	sitePhotos, originalPhotos = splitIndexedPhotos(getIndexedSmartAlbumPhotos(name, unpublishedAlbums(), 0))
+	for i := range originalPhotos {
+		sitePhotos[i].Index = i
+		originalPhotos[i].Index = i
+	}
+
+	return sitePhotos, originalPhotos
+}
+
+// Until the gallery has been indexed, smart albums are matched by walking
+// every album on disk.
+func findSmartAlbumPhotos(smartAlbum *SmartAlbum) (sitePhotos []*Photo, originalPhotos []*Photo) {
+	conditions, err := parseFilter(smartAlbum.Filter)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, nil
+	}
+
+	albums, err := readGallery()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, nil
+	}
+
+	sitePhotos = make([]*Photo, 0)
+	originalPhotos = make([]*Photo, 0)
+
+	var photoIndex = 0
+
+	for _, album := range albums {
+		if !album.IsDir() || !IsAlbumPublished(album.Name()) {
+			continue
+		}
+
+		albumPath := albumDir(album.Name())
+		photos, err := os.ReadDir(albumPath)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		albumMetadata := GetAlbumMetadata(album.Name())
+
+		for _, photo := range photos {
+			if photo.IsDir() || !isSupportedPhoto(photo.Name()) {
+				continue
+			}
+			if !matchesFilter(conditions, album.Name(), photo.Name(), filepath.Join(albumPath, photo.Name()), albumMetadata[photo.Name()]) {
+				continue
+			}
+			if pagePhoto := findOrAddAnySitePhoto(albumPath, photo.Name(), "-xl"); pagePhoto != nil {
+				pagePhoto.Index = photoIndex
+				sitePhotos = append(sitePhotos, pagePhoto)
+				pageOriginalPhoto := new(Photo)
+				pageOriginalPhoto.Name = photo.Name()
+				pageOriginalPhoto.Path = photoURL(albumPath + photo.Name())
+				pageOriginalPhoto.Root = GetAlbumRoot(album.Name())
+				if isRawPhoto(photo.Name()) {
+					pageOriginalPhoto.Path = pagePhoto.Path
+					pageOriginalPhoto.RawPath = photoURL(albumPath + photo.Name())
+				}
+				pageOriginalPhoto.Index = photoIndex
+				originalPhotos = append(originalPhotos, pageOriginalPhoto)
+				photoIndex = photoIndex + 1
+			}
+		}
+	}
+
+	return sitePhotos, originalPhotos
+}
+
+// Smart albums are listed alongside regular albums using their first match as
+// the cover. Covers come from the index, so they show once the gallery has
+// been indexed.
+func GetAllSmartAlbums() []*Album {
+	albums := make([]*Album, 0)
+
+	indexed := IsGalleryIndexed()
+	var unpublished []string
+	if indexed {
+		unpublished = unpublishedAlbums()
+	}
+
+	for index, smartAlbum := range GetSmartAlbums() {
+		album := new(Album)
+		album.Index = index
+		album.Name = smartAlbum.Name
+		if indexed {
+			if photos := getIndexedSmartAlbumPhotos(smartAlbum.Name, unpublished, 1); len(photos) > 0 {
+				album.Path = photoURL(photos[0].SitePath)
+			}
+		}
+		albums = append(albums, album)
+	}
+
+	return albums
+}
@@ -0,0 +1,354 @@
+package gallery_db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// Edited photos keep every earlier state of the original under
+// .site_photos/versions/<photo>/ as <version>.jpg, described by versions.json.
+// Each entry records the edit that replaced that version and who made it.
+type PhotoVersion struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	Editor  string    `json:"editor"`
+	Edit    string    `json:"edit"`
+}
+
+// Op is one of rotate_left, rotate_right, rotate_180, flip_horizontal,
+// flip_vertical or crop. Crops use the rectangle in original pixels.
+type PhotoEdit struct {
+	Op     string `json:"op"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+var photoVersionsMutex sync.Mutex
+
+// Callers check the names with isValidName first.
+func versionsDirPath(albumName string, photoName string) string {
+	return albumDir(albumName) + ".site_photos/versions/" + photoName + "/"
+}
+
+func GetPhotoVersions(albumName string, photoName string) ([]*PhotoVersion, error) {
+	if !isValidName(albumName) || !isValidName(photoName) {
+		return nil, errors.New("invalid album or photo name")
+	}
+
+	return readPhotoVersions(albumName, photoName), nil
+}
+
+// Returns how many earlier versions each edited photo in the album has.
+func albumPhotoVersions(albumName string) map[string]int {
+	counts := make(map[string]int)
+
+	photos, err := os.ReadDir(albumDir(albumName) + ".site_photos/versions")
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return counts
+	}
+
+	for _, photo := range photos {
+		if photo.IsDir() && isValidName(photo.Name()) {
+			if versions := readPhotoVersions(albumName, photo.Name()); len(versions) > 0 {
+				counts[photo.Name()] = len(versions)
+			}
+		}
+	}
+
+	return counts
+}
+
+func readPhotoVersions(albumName string, photoName string) []*PhotoVersion {
+	versions := make([]*PhotoVersion, 0)
+
+	jsonData, err := os.ReadFile(versionsDirPath(albumName, photoName) + "versions.json")
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return versions
+	}
+
+	if err := json.Unmarshal(jsonData, &versions); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return versions
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func editablePhotoPath(albumName string, photoName string) (string, error) {
+	if !isValidName(albumName) || !isValidName(photoName) {
+		return "", errors.New("invalid album or photo name")
+	}
+	if jpg_re.FindStringIndex(photoName) == nil {
+		return "", errors.New("only JPEG photos can be edited")
+	}
+
+	photoPath := albumDir(albumName) + photoName
+	if _, err := os.Stat(photoPath); err != nil {
+		return "", errors.New("photo not found: " + albumName + "/" + photoName)
+	}
+
+	return photoPath, nil
+}
+
+// Copies the current original into the version history before it is
+// replaced by edit.
+func savePhotoVersion(albumName string, photoName string, editor string, edit string) error {
+	versionsDir := versionsDirPath(albumName, photoName)
+	if err := os.MkdirAll(versionsDir, 0755); err != nil {
+		return err
+	}
+
+	versions := readPhotoVersions(albumName, photoName)
+	version := &PhotoVersion{Version: len(versions) + 1, Time: time.Now(), Editor: editor, Edit: edit}
+
+	if err := copyFile(albumDir(albumName)+photoName, fmt.Sprintf("%s%d.jpg", versionsDir, version.Version)); err != nil {
+		return err
+	}
+
+	jsonData, err := json.MarshalIndent(append(versions, version), "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(versionsDir+"versions.json", jsonData, 0644)
+}
+
+// Drops the photo's gallery site photos and cached hash so they are recreated
+// from the new original, rebuilds the album cover if it was made from this
+// photo and reindexes the album.
+func regenerateSitePhotos(albumName string, photoName string) {
+	forgetPhotoHash(albumName, photoName)
+
+	sitePhotoDirPath := albumDir(albumName) + ".site_photos"
+
+	photos, err := os.ReadDir(sitePhotoDirPath)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	prefix := strings.TrimSuffix(photoName, filepath.Ext(photoName)) + "-gp"
+	for _, photo := range photos {
+		if !photo.IsDir() && strings.HasPrefix(photo.Name(), prefix) {
+			if err := os.Remove(filepath.Join(sitePhotoDirPath, photo.Name())); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+
+	if GetAlbumSettings(albumName).Cover == photoName {
+		if _, err := setAlbumCover(albumName, photoName); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	reindexAlbums(albumName)
+}
+
+func applyPhotoEdit(img image.Image, edit *PhotoEdit) (image.Image, error) {
+	switch edit.Op {
+	case "rotate_left":
+		return imaging.Rotate90(img), nil
+	case "rotate_right":
+		return imaging.Rotate270(img), nil
+	case "rotate_180":
+		return imaging.Rotate180(img), nil
+	case "flip_horizontal":
+		return imaging.FlipH(img), nil
+	case "flip_vertical":
+		return imaging.FlipV(img), nil
+	case "crop":
+		rect := image.Rect(edit.X, edit.Y, edit.X+edit.Width, edit.Y+edit.Height)
+		if edit.Width <= 0 || edit.Height <= 0 || !rect.In(img.Bounds()) {
+			return nil, errors.New("crop rectangle is outside the photo")
+		}
+		return imaging.Crop(img, rect), nil
+	}
+	return nil, errors.New("unknown edit: " + edit.Op)
+}
+
+func EditPhoto(albumName string, photoName string, editor string, edit *PhotoEdit) error {
+	logger.Debug("EditPhoto", "albumName", albumName, "photoName", photoName, "editor", editor, "edit.Op", edit.Op)
+
+	photoPath, err := editablePhotoPath(albumName, photoName)
+	if err != nil {
+		return err
+	}
+
+	photoVersionsMutex.Lock()
+	defer photoVersionsMutex.Unlock()
+
+	img, err := imaging.Open(photoPath, imaging.AutoOrientation(true))
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	edited, err := applyPhotoEdit(img, edit)
+	if err != nil {
+		return err
+	}
+
+	if err := savePhotoVersion(albumName, photoName, editor, edit.Op); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	// Saved beside the versions, then moved over the original in one step
+	editPath := versionsDirPath(albumName, photoName) + "edit.jpg"
+	if err := imaging.Save(edited, editPath, imaging.JPEGQuality(95)); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	if err := os.Rename(editPath, photoPath); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	regenerateSitePhotos(albumName, photoName)
+
+	return nil
+}
+
+// Replaces the original with an uploaded JPEG, keeping the old one as a
+// version.
+func ReplacePhoto(albumName string, photoName string, editor string, r io.Reader) error {
+	logger.Debug("ReplacePhoto", "albumName", albumName, "photoName", photoName, "editor", editor)
+
+	photoPath, err := editablePhotoPath(albumName, photoName)
+	if err != nil {
+		return err
+	}
+
+	photoVersionsMutex.Lock()
+	defer photoVersionsMutex.Unlock()
+
+	versionsDir := versionsDirPath(albumName, photoName)
+	if err := os.MkdirAll(versionsDir, 0755); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	editPath := versionsDir + "edit.jpg"
+	f, err := os.OpenFile(editPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		os.Remove(editPath)
+		return err
+	}
+
+	if _, format, err := decodePhotoConfig(editPath); err != nil || format != "jpeg" {
+		os.Remove(editPath)
+		return errors.New("replacement must be a JPEG photo")
+	}
+
+	if err := savePhotoVersion(albumName, photoName, editor, "replace"); err != nil {
+		logger.Error(err.Error())
+		os.Remove(editPath)
+		return err
+	}
+
+	if err := os.Rename(editPath, photoPath); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	regenerateSitePhotos(albumName, photoName)
+
+	return nil
+}
+
+func decodePhotoConfig(path string) (image.Config, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	defer f.Close()
+
+	return image.DecodeConfig(f)
+}
+
+// Restores an earlier version. The current original is kept as a new version
+// so a revert can itself be reverted.
+func RevertPhoto(albumName string, photoName string, version int, editor string) error {
+	logger.Debug("RevertPhoto", "albumName", albumName, "photoName", photoName, "version", version, "editor", editor)
+
+	photoPath, err := editablePhotoPath(albumName, photoName)
+	if err != nil {
+		return err
+	}
+
+	photoVersionsMutex.Lock()
+	defer photoVersionsMutex.Unlock()
+
+	if version < 1 || version > len(readPhotoVersions(albumName, photoName)) {
+		return fmt.Errorf("version %d not found", version)
+	}
+
+	versionPath := fmt.Sprintf("%s%d.jpg", versionsDirPath(albumName, photoName), version)
+	editPath := versionsDirPath(albumName, photoName) + "edit.jpg"
+	if err := copyFile(versionPath, editPath); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	if err := savePhotoVersion(albumName, photoName, editor, fmt.Sprintf("revert to version %d", version)); err != nil {
+		logger.Error(err.Error())
+		os.Remove(editPath)
+		return err
+	}
+
+	if err := os.Rename(editPath, photoPath); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	regenerateSitePhotos(albumName, photoName)
+
+	return nil
+}
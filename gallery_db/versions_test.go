@@ -0,0 +1,45 @@
+package gallery_db
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestApplyPhotoEditCrop(t *testing.T) {
+	photo := image.NewRGBA(image.Rect(0, 0, 100, 80))
+
+	tests := []struct {
+		name   string
+		edit   PhotoEdit
+		width  int
+		height int
+		valid  bool
+	}{
+		{"inside", PhotoEdit{X: 10, Y: 20, Width: 30, Height: 40}, 30, 40, true},
+		{"the whole photo", PhotoEdit{Width: 100, Height: 80}, 100, 80, true},
+		{"touching the far corner", PhotoEdit{X: 99, Y: 79, Width: 1, Height: 1}, 1, 1, true},
+		{"one pixel too wide", PhotoEdit{X: 1, Width: 100, Height: 80}, 0, 0, false},
+		{"one pixel too tall", PhotoEdit{Y: 1, Width: 100, Height: 80}, 0, 0, false},
+		{"negative origin", PhotoEdit{X: -1, Y: 0, Width: 10, Height: 10}, 0, 0, false},
+		{"zero width", PhotoEdit{X: 10, Y: 10, Width: 0, Height: 10}, 0, 0, false},
+		{"negative height", PhotoEdit{X: 10, Y: 50, Width: 10, Height: -10}, 0, 0, false},
+		{"outside", PhotoEdit{X: 200, Y: 200, Width: 10, Height: 10}, 0, 0, false},
+		{"overflowing width", PhotoEdit{X: 10, Y: 10, Width: math.MaxInt, Height: 10}, 0, 0, false},
+		{"overflowing origin", PhotoEdit{X: math.MaxInt - 5, Y: 10, Width: 10, Height: 10}, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		edit := test.edit
+		edit.Op = "crop"
+
+		cropped, err := applyPhotoEdit(photo, &edit)
+		if test.valid != (err == nil) {
+			t.Errorf("%s: error = %v, want valid %v", test.name, err, test.valid)
+			continue
+		}
+		if test.valid && (cropped.Bounds().Dx() != test.width || cropped.Bounds().Dy() != test.height) {
+			t.Errorf("%s: cropped to %v, want %dx%d", test.name, cropped.Bounds(), test.width, test.height)
+		}
+	}
+}
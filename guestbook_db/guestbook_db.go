@@ -0,0 +1,171 @@
+package guestbook_db
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+// Guestbook entries are left by visitors and only shown once an admin has
+// approved them.
+type Entry struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `json:"name"`
+	Message    string     `json:"message"`
+	IP         string     `json:"ip"`
+	Created    time.Time  `json:"created"`
+	Approved   bool       `gorm:"index" json:"approved"`
+	ApprovedBy string     `json:"approved_by,omitempty"`
+	ApprovedAt *time.Time `json:"approved_at,omitempty"`
+}
+
+// Entries would otherwise share the entries table of challenge_db.
+func (Entry) TableName() string { return "guestbook_entries" }
+
+const (
+	maxNameLength    = 64
+	maxMessageLength = 2000
+)
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	migrateEntriesTable(db)
+
+	if err := db.AutoMigrate(&Entry{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// The guestbook used to keep its entries in the entries table. Whichever of the
+// guestbook and challenge_db created that table first owns it, and the other
+// couldn't migrate, so only a table with guestbook columns and no challenge
+// columns holds entries to move.
+func migrateEntriesTable(gdb *gorm.DB) {
+	migrator := gdb.Migrator()
+	if migrator.HasTable(&Entry{}) || !migrator.HasTable("entries") ||
+		!migrator.HasColumn("entries", "message") || migrator.HasColumn("entries", "challenge_id") {
+		return
+	}
+
+	if err := migrator.RenameTable("entries", &Entry{}); err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	logger.Info("Moved guestbook entries out of the entries table")
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+// Queues the entry for moderation.
+func AddEntry(entry *Entry) error {
+	entry.Name = strings.TrimSpace(entry.Name)
+	entry.Message = strings.TrimSpace(entry.Message)
+	if len(entry.Name) == 0 || len(entry.Message) == 0 {
+		return errors.New("please leave your name and a message")
+	}
+	if utf8.RuneCountInString(entry.Name) > maxNameLength {
+		return errors.New("names are at most 64 characters")
+	}
+	if utf8.RuneCountInString(entry.Message) > maxMessageLength {
+		return errors.New("messages are at most 2000 characters")
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("guestbook database not available")
+	}
+
+	entry.ID = 0
+	entry.Created = time.Now()
+	entry.Approved = false
+	entry.ApprovedBy = ""
+	entry.ApprovedAt = nil
+
+	return gdb.Create(entry).Error
+}
+
+func getEntries(approved bool, order string) []*Entry {
+	entries := make([]*Entry, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return entries
+	}
+
+	if err := gdb.Where("approved = ?", approved).Order(order).Find(&entries).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return entries
+}
+
+// Newest first.
+func GetApprovedEntries() []*Entry {
+	return getEntries(true, "created desc")
+}
+
+// The moderation queue, oldest first.
+func GetPendingEntries() []*Entry {
+	return getEntries(false, "created")
+}
+
+func GetEntry(id uint) *Entry {
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	entry := new(Entry)
+	if err := gdb.First(entry, id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	return entry
+}
+
+func ApproveEntry(entry *Entry, username string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("guestbook database not available")
+	}
+
+	now := time.Now()
+	entry.Approved = true
+	entry.ApprovedBy = username
+	entry.ApprovedAt = &now
+
+	return gdb.Save(entry).Error
+}
+
+func DeleteEntry(id uint) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("guestbook database not available")
+	}
+
+	return gdb.Delete(&Entry{}, id).Error
+}
@@ -0,0 +1,52 @@
+package guestbook_db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+)
+
+func TestMigrateEntriesTable(t *testing.T) {
+	gdb := blaze_db.GetDB()
+	if gdb == nil {
+		t.Fatal("database not available")
+	}
+
+	// A challenge table in the way is left alone
+	challengeEntries := struct {
+		ChallengeID uint   `gorm:"primaryKey"`
+		Username    string `gorm:"primaryKey"`
+		Day         string `gorm:"primaryKey"`
+		Value       int
+	}{1, "dave", "2024-07-01", 5}
+	if err := gdb.Table("entries").AutoMigrate(&challengeEntries); err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.Table("entries").Create(&challengeEntries).Error; err != nil {
+		t.Fatal(err)
+	}
+	migrateEntriesTable(gdb)
+	if !gdb.Migrator().HasTable("entries") || gdb.Migrator().HasTable(&Entry{}) {
+		t.Fatal("challenge entries table was moved")
+	}
+	if err := gdb.Migrator().DropTable("entries"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A guestbook table is moved with its entries
+	entry := &Entry{Name: "Dave", Message: "Lovely site", Created: time.Now(), Approved: true}
+	if err := gdb.Table("entries").AutoMigrate(&Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.Table("entries").Create(entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	migrateEntriesTable(gdb)
+	if gdb.Migrator().HasTable("entries") {
+		t.Error("entries table kept")
+	}
+	if moved := GetEntry(entry.ID); moved == nil || moved.Message != "Lovely site" || !moved.Approved {
+		t.Errorf("entry = %+v, want the approved entry moved", moved)
+	}
+}
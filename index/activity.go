@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/activity_db"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+)
+
+// The activity feed merges what members can see into one stream, newest
+// first, for the activity page, dashboards and other external consumers.
+// Events are recorded in activity_db as they happen and filtered by what
+// the viewer is allowed to see when read.
+const (
+	ActivityArticle = "article"
+	ActivityAlbum   = "album"
+	ActivityPhotos  = "photos"
+)
+
+type ActivityEvent struct {
+	ID    string    `json:"id"`
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	Title string    `json:"title"`
+	URL   string    `json:"url"`
+	Count int       `json:"count,omitempty"`
+	New   bool      `json:"new,omitempty"`
+}
+
+type ActivityPage struct {
+	Events     []*ActivityEvent `json:"events"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+type ActivityView struct {
+	Title    string           `json:"title"`
+	Events   []*ActivityEvent `json:"events"`
+	NextURL  string           `json:"next_url,omitempty"`
+	NewCount int              `json:"new_count"`
+}
+
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 200
+)
+
+// Events are read from the database a batch at a time and filtered by what
+// the viewer can see, until the page is full. Scanning stops after
+// maxActivityScan events so a viewer who can see little still gets a quick
+// answer, with a cursor to carry on from.
+const maxActivityScan = 2000
+
+// Returns the page of events after cursor that username can see, limited to
+// types if any are given.
+func getActivity(username string, cursor string, limit int, types []string) (*ActivityPage, bool) {
+	var beforeTime time.Time
+	var beforeID uint
+	if len(cursor) > 0 {
+		var ok bool
+		if beforeTime, beforeID, ok = decodeActivityCursor(cursor); !ok {
+			return nil, false
+		}
+	}
+
+	page := &ActivityPage{Events: make([]*ActivityEvent, 0, limit)}
+	albums := make(map[string]bool)
+	batchSize := max(limit, defaultActivityLimit)
+
+	for scanned := 0; scanned < maxActivityScan; {
+		batch := activity_db.GetEvents(beforeTime, beforeID, types, batchSize)
+		for _, event := range batch {
+			if len(page.Events) == limit {
+				page.NextCursor = encodeActivityCursor(beforeTime, beforeID)
+				return page, true
+			}
+			beforeTime, beforeID = event.Time, event.ID
+			scanned = scanned + 1
+
+			if activityEvent := visibleActivity(username, event, albums); activityEvent != nil {
+				page.Events = append(page.Events, activityEvent)
+			}
+		}
+		if len(batch) < batchSize {
+			return page, true
+		}
+	}
+
+	page.NextCursor = encodeActivityCursor(beforeTime, beforeID)
+	return page, true
+}
+
+// Returns the event as shown to username, or nil if they can't see what it
+// is about. Album visibility is remembered in albums for the request.
+func visibleActivity(username string, event *activity_db.Event, albums map[string]bool) *ActivityEvent {
+	activityEvent := &ActivityEvent{
+		ID:    event.Type + ":" + strconv.FormatUint(uint64(event.ID), 10),
+		Type:  event.Type,
+		Time:  event.Time,
+		Actor: event.Actor,
+		Title: event.Title,
+		Count: event.Count,
+	}
+
+	switch event.Type {
+	case ActivityArticle:
+		article := blog_db.GetArticle(event.Ref)
+		if article == nil || !article.CanView(username) {
+			return nil
+		}
+		activityEvent.Title = article.Title
+		activityEvent.URL = article.Permalink()
+	case ActivityAlbum, ActivityPhotos:
+		visible, ok := albums[event.Ref]
+		if !ok {
+			visible = canViewAlbum(username, event.Ref)
+			albums[event.Ref] = visible
+		}
+		if !visible {
+			return nil
+		}
+		activityEvent.URL = "/album?name=" + url.QueryEscape(event.Ref)
+	default:
+		return nil
+	}
+
+	return activityEvent
+}
+
+func recordAlbumChange(change *gallery_db.AlbumChange) {
+	eventType := ActivityPhotos
+	if change.New {
+		eventType = ActivityAlbum
+	}
+
+	activity_db.Record(eventType, gallery_db.GetAlbumSettings(change.Album).Owner, change.Album, change.Album, change.Added)
+}
+
+// Seeds an empty activity log from the articles and albums already on the
+// site, so upgrading doesn't start with an empty feed. Albums are dated by
+// when files were last added or removed, or when a scheduled album was
+// published if that was later.
+func backfillActivity() {
+	if activity_db.HasEvents() {
+		return
+	}
+
+	events := make([]*activity_db.Event, 0)
+	now := time.Now()
+
+	for _, article := range blog_db.GetAllArticles() {
+		date, err := time.ParseInLocation("2006-01-02", article.Date, time.Local)
+		if err != nil || date.After(now) {
+			continue
+		}
+
+		events = append(events, &activity_db.Event{Type: ActivityArticle, Time: date, Actor: article.Author, Title: article.Title, Ref: article.Key()})
+	}
+
+	for _, album := range gallery_db.GetAllAlbums() {
+		info, err := os.Stat(gallery_db.GetAlbumDir(album.Name))
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+
+		settings := gallery_db.GetAlbumSettings(album.Name)
+		updated := info.ModTime()
+		if settings.PublishAt != nil && settings.PublishAt.After(updated) && settings.PublishAt.Before(now) {
+			updated = *settings.PublishAt
+		}
+
+		events = append(events, &activity_db.Event{Type: ActivityAlbum, Time: updated, Actor: settings.Owner, Title: album.Name, Ref: album.Name})
+	}
+
+	if err := activity_db.Backfill(events); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// Cursors encode the time and ID of the last event read, newest first with
+// ties broken by ID so the position is stable.
+func encodeActivityCursor(beforeTime time.Time, beforeID uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(beforeTime.UnixNano(), 10) + "|" + strconv.FormatUint(uint64(beforeID), 10)))
+}
+
+func decodeActivityCursor(cursor string) (time.Time, uint, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+
+	nanos, id, found := strings.Cut(string(data), "|")
+	if !found {
+		return time.Time{}, 0, false
+	}
+
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	eventID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+
+	return time.Unix(0, unixNano), uint(eventID), true
+}
+
+func activityLimit(value string) int {
+	if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+		return min(parsed, maxActivityLimit)
+	}
+	return defaultActivityLimit
+}
+
+func servActivityAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servActivityAPI()")
+
+	query := r.URL.Query()
+
+	page, ok := getActivity(username, query.Get("cursor"), activityLimit(query.Get("limit")), query["type"])
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+// Events since the member's last visit are marked new. Later pages carry
+// the time of that visit along so the marking stays the same.
+func servActivity(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	query := r.URL.Query()
+	cursor := query.Get("cursor")
+
+	var since time.Time
+	if len(cursor) == 0 && isImpersonated(r) {
+		since = activity_db.LastSeen(username)
+	} else if len(cursor) == 0 {
+		since = activity_db.MarkSeen(username)
+	} else if seconds, err := strconv.ParseInt(query.Get("since"), 10, 64); err == nil {
+		since = time.Unix(seconds, 0)
+	}
+
+	logger.DebugContext(r.Context(), "servActivity()", "cursor", cursor, "since", since)
+
+	page, ok := getActivity(username, cursor, defaultActivityLimit, nil)
+	if !ok {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	pageData := new(ActivityView)
+	pageData.Title = "Activity"
+	pageData.Events = page.Events
+	for _, event := range pageData.Events {
+		if !since.IsZero() && event.Time.After(since) {
+			event.New = true
+			pageData.NewCount = pageData.NewCount + 1
+		}
+	}
+	if len(page.NextCursor) > 0 {
+		pageData.NextURL = "/activity?cursor=" + page.NextCursor
+		if !since.IsZero() {
+			pageData.NextURL = pageData.NextURL + "&since=" + strconv.FormatInt(since.Unix(), 10)
+		}
+	}
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/activity.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+	"github.com/jeffereydecker/blazemarker/guestbook_db"
+)
+
+// Admins are listed one username per line in ../blaze_auth/admins, next to
+// the .htpasswd file.
+const adminsFile = "../blaze_auth/admins"
+
+func isAdmin(username string) bool {
+	f, err := os.Open(adminsFile)
+	if err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); len(line) > 0 && !strings.HasPrefix(line, "#") && line == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+func adminAuth(w http.ResponseWriter, r *http.Request) (bool, string) {
+	ok, username := basicAuth(w, r)
+	if !ok {
+		return false, username
+	}
+
+	if !isAdmin(username) {
+		logger.InfoContext(r.Context(), "Blazemarker, adminAuth(), Forbidden", "username", username, "r.URL.Path", r.URL.Path)
+		writeJSONError(w, http.StatusForbidden, "Admin access required")
+		return false, username
+	}
+
+	return true, username
+}
+
+func servDuplicatePhotosAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servDuplicatePhotosAPI()")
+
+	writeJSON(w, http.StatusOK, gallery_db.FindDuplicatePhotos())
+}
+
+func servRemoveDuplicatePhotosAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	hash := r.PathValue("hash")
+	keep := r.URL.Query().Get("keep")
+	logger.DebugContext(r.Context(), "servRemoveDuplicatePhotosAPI()", "hash", hash, "keep", keep, "username", username)
+
+	if len(keep) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "keep is required")
+		return
+	}
+
+	removed, err := gallery_db.RemoveDuplicatePhotos(hash, keep)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "removed": removed})
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Duplicate photos removed", "hash", hash, "keep", keep, "removed", removed, "username", username)
+	audit_db.Record(audit_db.ActionDuplicatesRemoved, username, clientIP(r), "kept "+keep+", removed "+strings.Join(removed, ", "))
+	writeJSON(w, http.StatusOK, map[string]any{"kept": keep, "removed": removed})
+}
+
+type AdminDashboard struct {
+	Title      string            `json:"title"`
+	Storage    *StorageStatus    `json:"storage"`
+	LogLevel   string            `json:"log_level"`
+	LogLevels  []string          `json:"log_levels"`
+	Holds      *Holds            `json:"holds"`
+	Integrity  IntegrityReport   `json:"integrity"`
+	Categories []*Category       `json:"categories"`
+	Guestbook  []*GuestbookEntry `json:"guestbook"`
+}
+
+func servAdmin(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servAdmin()")
+
+	pageData := new(AdminDashboard)
+	pageData.Title = "Blazemarker Admin"
+	pageData.Storage = getStorageStatus()
+	pageData.LogLevel = blaze_log.GetLevel()
+	pageData.LogLevels = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+	pageData.Holds = getHolds()
+	pageData.Integrity = gallery_db.GetIntegrityReport()
+	pageData.Categories = blog_db.GetCategories()
+	pageData.Guestbook = guestbook_db.GetPendingEntries()
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/admin.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servGalleryIndexAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servGalleryIndexAPI()")
+
+	writeJSON(w, http.StatusOK, gallery_db.GetGalleryIndexStatus())
+}
+
+func servSyncGalleryIndexAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Gallery index sync requested", "username", username)
+	audit_db.Record(audit_db.ActionGalleryReindex, username, clientIP(r), "")
+
+	if gallery_db.GetGalleryIndexStatus().Running {
+		writeJSONError(w, http.StatusConflict, "Gallery index sync already running")
+		return
+	}
+
+	go func() {
+		if err := gallery_db.SyncGalleryIndex(); err != nil {
+			logger.ErrorContext(r.Context(), err.Error())
+		}
+	}()
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": "Gallery index sync started"})
+}
+
+func servLogsAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	query := r.URL.Query()
+	logQuery := new(blaze_log.LogQuery)
+	logQuery.Level = query.Get("level")
+	logQuery.Module = query.Get("module")
+	logQuery.Username = query.Get("username")
+	logQuery.RequestID = query.Get("request_id")
+	logQuery.Text = query.Get("q")
+	logQuery.Offset, _ = strconv.Atoi(query.Get("offset"))
+	logQuery.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+	for name, t := range map[string]*time.Time{"since": &logQuery.Since, "until": &logQuery.Until} {
+		if value := query.Get(name); len(value) > 0 {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, name+" must be an RFC 3339 time")
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	page, err := blaze_log.QueryLog(logQuery)
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to read log")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func servLogLevelAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"level": blaze_log.GetLevel()})
+}
+
+// Changes the log level until the next restart.
+func servSetLogLevelAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	request := struct {
+		Level string `json:"level"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	if err := blaze_log.SetLevel(request.Level); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.WarnContext(r.Context(), "Log level changed", "username", username, "level", blaze_log.GetLevel())
+	audit_db.Record(audit_db.ActionLogLevelChanged, username, clientIP(r), blaze_log.GetLevel())
+
+	writeJSON(w, http.StatusOK, map[string]string{"level": blaze_log.GetLevel()})
+}
+
+type AlbumHold = gallery_db.AlbumHold
+type ArticleHold = blog_db.ArticleHold
+type IntegrityReport = gallery_db.IntegrityReport
+
+type Holds struct {
+	Albums   []*AlbumHold   `json:"albums"`
+	Articles []*ArticleHold `json:"articles"`
+}
+
+func getHolds() *Holds {
+	return &Holds{Albums: gallery_db.GetAlbumHolds(), Articles: blog_db.GetArticleHolds()}
+}
+
+func servHoldsAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servHoldsAPI()")
+
+	writeJSON(w, http.StatusOK, getHolds())
+}
+
+func decodeHoldReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	request := struct {
+		Reason string `json:"reason"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return "", false
+	}
+	return request.Reason, true
+}
+
+// Puts an album on hold so its photos can't be removed or moved out.
+func servPlaceHoldAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	albumName := r.PathValue("album")
+
+	reason, ok := decodeHoldReason(w, r)
+	if !ok {
+		return
+	}
+
+	hold, err := gallery_db.PlaceAlbumHold(albumName, reason, username)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Album hold placed", "albumName", albumName, "reason", reason, "username", username)
+	audit_db.Record(audit_db.ActionHoldPlaced, username, clientIP(r), albumName+": "+reason)
+
+	writeJSON(w, http.StatusOK, hold)
+}
+
+func servLiftHoldAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	albumName := r.PathValue("album")
+
+	if err := gallery_db.LiftAlbumHold(albumName); err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Album hold lifted", "albumName", albumName, "username", username)
+	audit_db.Record(audit_db.ActionHoldLifted, username, clientIP(r), albumName)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Puts an article on hold so it can't be deleted or purged from the trash.
+func servPlaceArticleHoldAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	key := r.PathValue("key")
+
+	reason, ok := decodeHoldReason(w, r)
+	if !ok {
+		return
+	}
+
+	hold, err := blog_db.PlaceArticleHold(key, reason, username)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Article hold placed", "key", key, "reason", reason, "username", username)
+	audit_db.Record(audit_db.ActionHoldPlaced, username, clientIP(r), hold.Title+": "+reason)
+
+	writeJSON(w, http.StatusOK, hold)
+}
+
+func servLiftArticleHoldAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	key := r.PathValue("key")
+
+	if err := blog_db.LiftArticleHold(key); err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Article hold lifted", "key", key, "username", username)
+	audit_db.Record(audit_db.ActionHoldLifted, username, clientIP(r), key)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func servIntegrityAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servIntegrityAPI()")
+
+	writeJSON(w, http.StatusOK, gallery_db.GetIntegrityReport())
+}
+
+func servCheckIntegrityAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	if gallery_db.GetIntegrityReport().Running {
+		writeJSONError(w, http.StatusConflict, "Integrity check already running")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Integrity check requested", "username", username)
+
+	go func() {
+		if _, err := gallery_db.CheckIntegrity(); err != nil {
+			logger.Error(err.Error())
+		}
+	}()
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": "Integrity check started"})
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+)
+
+type SmartAlbum = gallery_db.SmartAlbum
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+func servAlbumsAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servAlbumsAPI()")
+
+	pageData := new(Gallery)
+	pageData.Title = "Decker Photo Albums"
+	if gallery_db.IsGalleryIndexed() {
+		pageData.Albums = gallery_db.GetIndexedAlbums()
+	} else {
+		pageData.Albums = gallery_db.GetAllAlbums()
+	}
+	pageData.Albums = visibleAlbums(username, pageData.Albums)
+	pageData.SmartAlbums = gallery_db.GetAllSmartAlbums()
+
+	writeJSON(w, http.StatusOK, pageData)
+}
+
+// Returns the album with its photos, including how many earlier versions of
+// each edited photo are kept.
+func servAlbumAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	name := r.PathValue("name")
+	logger.DebugContext(r.Context(), "servAlbumAPI()", "name", name)
+
+	if !canViewAlbum(username, name) {
+		writeJSONError(w, http.StatusNotFound, "Album not found")
+		return
+	}
+
+	album := gallery_db.GetIndexedAlbum(name)
+	if album != nil {
+		album.SitePhotos, album.OriginalPhotos = gallery_db.GetIndexedAlbumPhotos(name)
+	} else {
+		album = new(Album)
+		album.Name = name
+		album.SitePhotos, album.OriginalPhotos = gallery_db.GetAlbumPhotos(name)
+		album.HasRawPairs = gallery_db.HasRawPairs(name)
+	}
+	if album.OriginalPhotos == nil {
+		writeJSONError(w, http.StatusNotFound, "Album not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, album)
+}
+
+func servSmartAlbumsAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servSmartAlbumsAPI()")
+
+	writeJSON(w, http.StatusOK, gallery_db.GetSmartAlbums())
+}
+
+func servSmartAlbumPhotosAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	name := r.PathValue("name")
+	logger.DebugContext(r.Context(), "servSmartAlbumPhotosAPI()", "name", name)
+
+	if gallery_db.GetSmartAlbum(name) == nil {
+		writeJSONError(w, http.StatusNotFound, "Smart album not found")
+		return
+	}
+
+	album := new(Album)
+	album.Name = name
+	album.SitePhotos, album.OriginalPhotos = gallery_db.GetSmartAlbumPhotos(name)
+
+	writeJSON(w, http.StatusOK, album)
+}
+
+func canManageSmartAlbum(username string, smartAlbum *SmartAlbum) bool {
+	return isAdmin(username) || (len(username) > 0 && smartAlbum.Owner == username)
+}
+
+func servSaveSmartAlbumAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	smartAlbum := new(SmartAlbum)
+	if err := json.NewDecoder(r.Body).Decode(smartAlbum); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servSaveSmartAlbumAPI()", "Name", smartAlbum.Name, "Filter", smartAlbum.Filter, "username", username)
+
+	// A new smart album belongs to whoever saves it, an existing one keeps
+	// its owner even when an admin changes it
+	smartAlbum.Owner = username
+	if existing := gallery_db.GetSmartAlbum(smartAlbum.Name); existing != nil {
+		if !canManageSmartAlbum(username, existing) {
+			logger.InfoContext(r.Context(), "Smart album change not allowed", "name", smartAlbum.Name, "username", username)
+			writeJSONError(w, http.StatusForbidden, "Only admins and the smart album owner can change it")
+			return
+		}
+		smartAlbum.Owner = existing.Owner
+	}
+
+	if err := gallery_db.SaveSmartAlbum(smartAlbum); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Smart album saved", "name", smartAlbum.Name, "filter", smartAlbum.Filter, "username", username)
+	audit_db.Record(audit_db.ActionSmartAlbumSaved, username, clientIP(r), smartAlbum.Name+": "+smartAlbum.Filter)
+
+	writeJSON(w, http.StatusOK, smartAlbum)
+}
+
+func servDeleteSmartAlbumAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	name := r.PathValue("name")
+	logger.DebugContext(r.Context(), "servDeleteSmartAlbumAPI()", "name", name, "username", username)
+
+	smartAlbum := gallery_db.GetSmartAlbum(name)
+	if smartAlbum == nil {
+		writeJSONError(w, http.StatusNotFound, "Smart album not found")
+		return
+	}
+	if !canManageSmartAlbum(username, smartAlbum) {
+		logger.InfoContext(r.Context(), "Smart album delete not allowed", "name", name, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only admins and the smart album owner can delete it")
+		return
+	}
+
+	if err := gallery_db.DeleteSmartAlbum(name); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete smart album")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Smart album deleted", "name", name, "username", username)
+	audit_db.Record(audit_db.ActionSmartAlbumDeleted, username, clientIP(r), name+": "+smartAlbum.Filter)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func servBulkEditAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	edit := new(gallery_db.BulkEdit)
+	if err := json.NewDecoder(r.Body).Decode(edit); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servBulkEditAPI()", "username", username)
+
+	if edit.Album != nil && storageCritical() {
+		writeJSONError(w, http.StatusInsufficientStorage, "Storage is critically low, please try again later")
+		return
+	}
+
+	if err := gallery_db.ValidateBulkEdit(edit); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// A filter only looks in albums the user manages, but listed photos and
+	// the destination must be checked
+	photoIDs, err := gallery_db.SelectBulkEditPhotos(edit, func(albumName string) bool { return canManageAlbum(username, albumName) })
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	albums := gallery_db.BulkEditAlbums(photoIDs)
+	if edit.Album != nil {
+		albums = append(albums, *edit.Album)
+	}
+	for _, albumName := range albums {
+		if !canManageAlbum(username, albumName) {
+			logger.InfoContext(r.Context(), "Bulk edit not allowed", "albumName", albumName, "username", username)
+			writeJSONError(w, http.StatusForbidden, "Only admins and the album owner can edit photos in "+albumName)
+			return
+		}
+	}
+
+	job, err := gallery_db.StartBulkEdit(username, edit, photoIDs)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func servBulkEditJobAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	job := gallery_db.GetBulkEditJob(r.PathValue("id"))
+	if job == nil || (job.Username != username && !isAdmin(username)) {
+		writeJSONError(w, http.StatusNotFound, "Bulk edit job not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func servUndoBulkEditAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id := r.PathValue("id")
+	logger.DebugContext(r.Context(), "servUndoBulkEditAPI()", "id", id, "username", username)
+
+	job := gallery_db.GetBulkEditJob(id)
+	if job == nil {
+		writeJSONError(w, http.StatusNotFound, "Bulk edit job not found")
+		return
+	}
+	if job.Username != username {
+		writeJSONError(w, http.StatusForbidden, "Only the user who started a bulk edit can undo it")
+		return
+	}
+
+	if err := gallery_db.UndoBulkEdit(id); err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, gallery_db.GetBulkEditJob(id))
+}
+
+func canManageAlbum(username string, albumName string) bool {
+	return isAdmin(username) || (len(username) > 0 && gallery_db.GetAlbumSettings(albumName).Owner == username)
+}
+
+func servAlbumCoverAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	albumName := r.PathValue("name")
+
+	request := struct {
+		Photo string `json:"photo"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servAlbumCoverAPI()", "albumName", albumName, "photo", request.Photo, "username", username)
+
+	if !canManageAlbum(username, albumName) {
+		logger.InfoContext(r.Context(), "Album cover change not allowed", "albumName", albumName, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only admins and the album owner can change the cover")
+		return
+	}
+
+	albumCoverPath, err := gallery_db.SetAlbumCover(albumName, request.Photo)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Album cover changed", "albumName", albumName, "photo", request.Photo, "username", username)
+	audit_db.Record(audit_db.ActionAlbumCoverChanged, username, clientIP(r), albumName+"/"+request.Photo)
+	writeJSON(w, http.StatusOK, map[string]string{"album": albumName, "cover": request.Photo, "path": albumCoverPath})
+}
+
+// Hides an album, optionally scheduling it to appear at publish_at, or
+// publishes it immediately.
+func servAlbumPublishingAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	albumName := r.PathValue("name")
+
+	request := struct {
+		Hidden    bool       `json:"hidden"`
+		PublishAt *time.Time `json:"publish_at"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servAlbumPublishingAPI()", "albumName", albumName, "hidden", request.Hidden, "publishAt", request.PublishAt, "username", username)
+
+	if !canManageAlbum(username, albumName) {
+		logger.InfoContext(r.Context(), "Album publishing change not allowed", "albumName", albumName, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only admins and the album owner can publish albums")
+		return
+	}
+
+	settings, err := gallery_db.SetAlbumPublishing(albumName, request.Hidden, request.PublishAt)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	details := albumName + " published"
+	if settings.Hidden {
+		details = albumName + " hidden"
+		if settings.PublishAt != nil {
+			details = details + " until " + settings.PublishAt.Format(time.RFC3339)
+		}
+	}
+
+	logger.InfoContext(r.Context(), "Album publishing changed", "albumName", albumName, "details", details, "username", username)
+	audit_db.Record(audit_db.ActionAlbumPublishing, username, clientIP(r), details)
+	writeJSON(w, http.StatusOK, settings)
+}
+
+func servOfTheDayAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servOfTheDayAPI()")
+
+	day := time.Now()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"date":    day.Format("2006-01-02"),
+		"article": blog_db.GetArticleOfTheDay(day),
+		"photo":   gallery_db.GetPhotoOfTheDay(day),
+	})
+}
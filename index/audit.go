@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+)
+
+type AuditPage struct {
+	Title  string                 `json:"title"`
+	Action string                 `json:"action"`
+	Actor  string                 `json:"actor"`
+	Since  string                 `json:"since"`
+	Until  string                 `json:"until"`
+	Offset int                    `json:"offset"`
+	Limit  int                    `json:"limit"`
+	Total  int64                  `json:"total"`
+	Events []*audit_db.AuditEvent `json:"events"`
+
+	Actions    []string `json:"-"`
+	PrevOffset int      `json:"-"`
+	NextOffset int      `json:"-"`
+}
+
+var auditActions = []string{
+	audit_db.ActionLogin,
+	audit_db.ActionLoginFailed,
+	audit_db.ActionLockout,
+	audit_db.ActionUserCreated,
+	audit_db.ActionInviteCreated,
+	audit_db.ActionUserDisabled,
+	audit_db.ActionUserEnabled,
+	audit_db.ActionUserDeleted,
+	audit_db.ActionImpersonationStarted,
+	audit_db.ActionImpersonationEnded,
+	audit_db.ActionImpersonatedRequest,
+	audit_db.ActionArticleCreated,
+	audit_db.ActionArticleEdited,
+	audit_db.ActionArticleDeleted,
+	audit_db.ActionArticleRestored,
+	audit_db.ActionArticlePurged,
+	audit_db.ActionMediaCleaned,
+	audit_db.ActionTagRenamed,
+	audit_db.ActionTagDeleted,
+	audit_db.ActionNowUpdated,
+	audit_db.ActionCategoryCreated,
+	audit_db.ActionCategoryDeleted,
+	audit_db.ActionAlbumCoverChanged,
+	audit_db.ActionPhotoEdited,
+	audit_db.ActionAlbumPublishing,
+	audit_db.ActionHoldPlaced,
+	audit_db.ActionHoldLifted,
+	audit_db.ActionDuplicatesRemoved,
+	audit_db.ActionGalleryReindex,
+	audit_db.ActionBackupCreated,
+	audit_db.ActionBackupRestored,
+	audit_db.ActionLogLevelChanged,
+	audit_db.ActionMaintenanceScheduled,
+	audit_db.ActionBannerPosted,
+	audit_db.ActionGuestbookApproved,
+	audit_db.ActionGuestbookRejected,
+	audit_db.ActionSmartAlbumSaved,
+	audit_db.ActionSmartAlbumDeleted,
+}
+
+// Basic auth re-sends credentials with every request, so a login is only
+// recorded once an hour per user and address.
+var (
+	lastLogins      = make(map[string]time.Time)
+	lastLoginsMutex sync.Mutex
+)
+
+// Addresses or CIDR ranges of reverse proxies, from the comma separated
+// BLAZEMARKER_TRUSTED_PROXIES. Only these may say who the client is with
+// X-Forwarded-For.
+var trustedProxies = sync.OnceValue(func() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0)
+	for _, value := range strings.Split(os.Getenv("BLAZEMARKER_TRUSTED_PROXIES"), ",") {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				logger.Error(err.Error(), "BLAZEMARKER_TRUSTED_PROXIES", value)
+				continue
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			logger.Error(err.Error(), "BLAZEMARKER_TRUSTED_PROXIES", value)
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+})
+
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies() {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// The address of the connection, or when that is a trusted proxy, the last
+// address in X-Forwarded-For that isn't one. Anything further left was
+// added by the client and can't be believed.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if _, err := netip.ParseAddr(ip); err != nil {
+			break
+		}
+		host = ip
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return host
+}
+
+func recordLogin(username string, r *http.Request) {
+	ip := clientIP(r)
+	key := username + "@" + ip
+
+	lastLoginsMutex.Lock()
+	last, ok := lastLogins[key]
+	recent := ok && time.Since(last) < time.Hour
+	if !recent {
+		lastLogins[key] = time.Now()
+	}
+	lastLoginsMutex.Unlock()
+
+	if !recent {
+		audit_db.Record(audit_db.ActionLogin, username, ip, "")
+	}
+}
+
+func servAudit(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	query := r.URL.Query()
+
+	pageData := new(AuditPage)
+	pageData.Title = "Audit Log"
+	pageData.Action = query.Get("action")
+	pageData.Actor = query.Get("actor")
+	pageData.Since = query.Get("since")
+	pageData.Until = query.Get("until")
+	pageData.Offset, _ = strconv.Atoi(query.Get("offset"))
+	pageData.Limit = 100
+
+	auditQuery := &audit_db.AuditQuery{Action: pageData.Action, Actor: pageData.Actor, Offset: pageData.Offset, Limit: pageData.Limit}
+	if since, err := time.ParseInLocation("2006-01-02", pageData.Since, time.Local); err == nil {
+		auditQuery.Since = since
+	}
+	if until, err := time.ParseInLocation("2006-01-02", pageData.Until, time.Local); err == nil {
+		auditQuery.Until = until.AddDate(0, 0, 1)
+	}
+
+	logger.DebugContext(r.Context(), "servAudit()", "action", pageData.Action, "actor", pageData.Actor, "since", pageData.Since, "until", pageData.Until)
+
+	pageData.Events, pageData.Total = audit_db.GetEvents(auditQuery)
+	pageData.Actions = auditActions
+	pageData.PrevOffset = max(0, pageData.Offset-pageData.Limit)
+	if int64(pageData.Offset+pageData.Limit) < pageData.Total {
+		pageData.NextOffset = pageData.Offset + pageData.Limit
+	}
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/audit.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
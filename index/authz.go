@@ -0,0 +1,144 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/bookmark_db"
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+)
+
+// Central authorization checks shared by handlers and templates.
+
+// Any of an article's authors can edit it. Admins can edit other members'
+// articles, but not private ones.
+func canEditArticle(username string, article *Article) bool {
+	return len(username) > 0 && article != nil && (article.HasAuthor(username) || (isAdmin(username) && !article.Private))
+}
+
+// Like articles, recipes can be changed by their author and by admins.
+func canEditRecipe(username string, recipe *Recipe) bool {
+	return len(username) > 0 && recipe != nil && (recipe.Author == username || isAdmin(username))
+}
+
+// Inventory items can be changed by whoever added them and by admins.
+func canEditInventoryItem(username string, item *InventoryItem) bool {
+	return len(username) > 0 && item != nil && (item.Owner == username || isAdmin(username))
+}
+
+// Polls can be closed or deleted by their creator and by admins.
+func canManagePoll(username string, poll *Poll) bool {
+	return len(username) > 0 && poll != nil && (poll.CreatedBy == username || isAdmin(username))
+}
+
+func visibleArticles(username string, articles []*Article) []*Article {
+	return slices.DeleteFunc(articles, func(article *Article) bool { return !article.CanView(username) })
+}
+
+// Identifies the viewer of a public page without challenging for credentials.
+func currentUser(r *http.Request) string {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return ""
+	}
+
+	ip := clientIP(r)
+	if loginLockout(ip, username) > 0 {
+		return ""
+	}
+
+	matched, err := matchCredentials(r, username, password)
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return ""
+	}
+	if !matched {
+		recordLoginFailure(ip, username)
+		return ""
+	}
+
+	if isDisabled(username) {
+		return ""
+	}
+
+	return effectiveUser(r, username)
+}
+
+// Template funcs are bound to the current user so templates can hide actions
+// the viewer isn't allowed to take.
+func templateFuncs(r *http.Request, username string) template.FuncMap {
+	// Pages such as albums ask about many bookmarks, so the member's are
+	// loaded once, the first time one is asked about
+	bookmarked := sync.OnceValue(func() map[string]bool {
+		saved := make(map[string]bool)
+		if len(username) > 0 {
+			for _, bookmark := range bookmark_db.GetBookmarks(username) {
+				saved[bookmark.Kind+":"+bookmark.Ref] = true
+			}
+		}
+		return saved
+	})
+
+	return template.FuncMap{
+		"currentUser": func() string { return username },
+		"isMember":    func() bool { return len(username) > 0 },
+		"isAdmin":     func() bool { return isAdmin(username) },
+		"banners":     func() []*Banner { return activeBanners(username) },
+		"impersonator": func() string {
+			if session := impersonationFrom(r.Context()); session != nil && session.Username == username {
+				return session.Admin
+			}
+			return ""
+		},
+		"unreadArticles": func() int {
+			if len(username) == 0 {
+				return 0
+			}
+			return blog_db.GetUnreadCount(username)
+		},
+		"linkPreviews": func(article *Article) []*blog_db.LinkPreview {
+			return blog_db.GetCachedLinkPreviews(blog_db.ArticleLinks(article))
+		},
+		"articlePolls": func(article *Article) []*PollView {
+			return getPollViews(article.Key(), username)
+		},
+		"isBookmarked": func(kind string, ref string) bool {
+			return bookmarked()[kind+":"+ref]
+		},
+		"canEditArticle": func(article *Article) bool {
+			return canEditArticle(username, article)
+		},
+		"canManageAlbum": func(albumName string) bool {
+			return canManageAlbum(username, albumName)
+		},
+	}
+}
+
+func parseTemplates(r *http.Request, username string, filenames ...string) (*template.Template, error) {
+	t, err := template.New(filepath.Base(filenames[0])).Funcs(templateFuncs(r, username)).ParseFiles(filenames...)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	return t, err
+}
+
+// Members can view published albums; hidden ones only by those who manage
+// them.
+func canViewAlbum(username string, albumName string) bool {
+	return len(username) > 0 && (gallery_db.IsAlbumPublished(albumName) || canManageAlbum(username, albumName))
+}
+
+func visibleAlbums(username string, albums []*Album) []*Album {
+	visible := make([]*Album, 0, len(albums))
+	for _, album := range albums {
+		if canViewAlbum(username, album.Name) {
+			album.Index = len(visible)
+			visible = append(visible, album)
+		}
+	}
+	return visible
+}
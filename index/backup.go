@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blaze_backup"
+)
+
+func servBackupsAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servBackupsAPI()")
+
+	writeJSON(w, http.StatusOK, blaze_backup.ListBackups())
+}
+
+func servCreateBackupAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	backup, err := blaze_backup.CreateBackup()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Unable to create backup")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Database backup requested", "username", username, "name", backup.Name)
+	audit_db.Record(audit_db.ActionBackupCreated, username, clientIP(r), backup.Name)
+
+	writeJSON(w, http.StatusOK, backup)
+}
+
+// The restore is only staged here; it replaces the database when the server
+// next starts.
+func servRestoreBackupAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	name := r.PathValue("name")
+
+	if err := blaze_backup.StageRestore(name); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Unable to stage restore: "+name)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Database restore requested", "username", username, "name", name)
+	audit_db.Record(audit_db.ActionBackupRestored, username, clientIP(r), name)
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": "Restore staged, restart the server to apply it"})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+)
+
+// Site banners are announcements shown at the top of every page between
+// Start and End (either may be left open). Members can dismiss a banner for
+// themselves; visitors who aren't logged in always see it.
+
+const (
+	BannerInfo    = "info"
+	BannerWarning = "warning"
+)
+
+type Banner struct {
+	ID          int       `json:"id"`
+	Level       string    `json:"level"`
+	Message     string    `json:"message"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	DismissedBy []string  `json:"-"`
+}
+
+// Who dismissed a banner is only kept in bannersFile, never sent to clients.
+type storedBanner struct {
+	Banner
+	DismissedBy []string `json:"dismissed_by,omitempty"`
+}
+
+const bannersFile = "../banners.json"
+
+var bannersMutex sync.Mutex
+
+func readBanners() []*Banner {
+	banners := make([]*Banner, 0)
+
+	jsonData, err := os.ReadFile(bannersFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return banners
+	}
+
+	stored := make([]*storedBanner, 0)
+	if err := json.Unmarshal(jsonData, &stored); err != nil {
+		logger.Error(err.Error())
+	}
+
+	for _, s := range stored {
+		banner := s.Banner
+		banner.DismissedBy = s.DismissedBy
+		banners = append(banners, &banner)
+	}
+
+	return banners
+}
+
+func writeBanners(banners []*Banner) error {
+	stored := make([]*storedBanner, 0, len(banners))
+	for _, banner := range banners {
+		stored = append(stored, &storedBanner{Banner: *banner, DismissedBy: banner.DismissedBy})
+	}
+
+	jsonData, err := json.MarshalIndent(stored, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(bannersFile, jsonData, 0644)
+}
+
+func (banner *Banner) isActive(now time.Time) bool {
+	return (banner.Start.IsZero() || !now.Before(banner.Start)) && (banner.End.IsZero() || now.Before(banner.End))
+}
+
+// Banners the user should see now.
+func activeBanners(username string) []*Banner {
+	now := time.Now()
+
+	return slices.DeleteFunc(readBanners(), func(banner *Banner) bool {
+		return !banner.isActive(now) || (len(username) > 0 && slices.Contains(banner.DismissedBy, username))
+	})
+}
+
+func servBannersAPI(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "servBannersAPI()")
+
+	writeJSON(w, http.StatusOK, activeBanners(currentUser(r)))
+}
+
+func servDismissBannerAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid banner id")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servDismissBannerAPI()", "id", id, "username", username)
+
+	bannersMutex.Lock()
+	defer bannersMutex.Unlock()
+
+	banners := readBanners()
+	index := slices.IndexFunc(banners, func(banner *Banner) bool { return banner.ID == id })
+	if index < 0 {
+		writeJSONError(w, http.StatusNotFound, "Banner not found")
+		return
+	}
+
+	if !slices.Contains(banners[index].DismissedBy, username) {
+		banners[index].DismissedBy = append(banners[index].DismissedBy, username)
+		if err := writeBanners(banners); err != nil {
+			logger.ErrorContext(r.Context(), err.Error())
+			writeJSONError(w, http.StatusInternalServerError, "Unable to dismiss banner")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"id": id})
+}
+
+func servAdminBannersAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servAdminBannersAPI()")
+
+	writeJSON(w, http.StatusOK, readBanners())
+}
+
+func servAddBannerAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	banner := new(Banner)
+	if err := json.NewDecoder(r.Body).Decode(banner); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if len(banner.Message) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "A banner needs a message")
+		return
+	}
+	if banner.Level != BannerWarning {
+		banner.Level = BannerInfo
+	}
+	if !banner.End.IsZero() && !banner.End.After(banner.Start) {
+		writeJSONError(w, http.StatusBadRequest, "A banner's end must be after its start")
+		return
+	}
+
+	bannersMutex.Lock()
+	defer bannersMutex.Unlock()
+
+	// Expired banners are dropped whenever the list is rewritten
+	now := time.Now()
+	banners := readBanners()
+	banner.ID = 0
+	banner.DismissedBy = nil
+	for _, existing := range banners {
+		banner.ID = max(banner.ID, existing.ID)
+	}
+	banner.ID = banner.ID + 1
+	banners = slices.DeleteFunc(banners, func(existing *Banner) bool {
+		return !existing.End.IsZero() && !now.Before(existing.End)
+	})
+	banners = append(banners, banner)
+
+	if err := writeBanners(banners); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save banner")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Banner added", "username", username, "id", banner.ID, "level", banner.Level)
+	audit_db.Record(audit_db.ActionBannerPosted, username, clientIP(r), banner.Level+": "+banner.Message)
+
+	writeJSON(w, http.StatusOK, banner)
+}
+
+func servDeleteBannerAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid banner id")
+		return
+	}
+
+	bannersMutex.Lock()
+	defer bannersMutex.Unlock()
+
+	banners := readBanners()
+	remaining := slices.DeleteFunc(slices.Clone(banners), func(banner *Banner) bool { return banner.ID == id })
+	if len(remaining) == len(banners) {
+		writeJSONError(w, http.StatusNotFound, "Banner not found")
+		return
+	}
+
+	if err := writeBanners(remaining); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save banners")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Banner removed", "username", username, "id", id)
+
+	writeJSON(w, http.StatusOK, map[string]int{"id": id})
+}
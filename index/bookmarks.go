@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/bookmark_db"
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+)
+
+type SavedPhoto struct {
+	Album   string `json:"album"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Caption string `json:"caption"`
+}
+
+type SavedPage struct {
+	Title    string        `json:"title"`
+	Articles []*Article    `json:"articles"`
+	Photos   []*SavedPhoto `json:"photos"`
+}
+
+// Finds a photo the member can see, with its site photo for display.
+func findSavedPhoto(username string, albumName string, photoName string) *SavedPhoto {
+	photos := findSavedPhotos(username, albumName, []string{photoName})
+	if len(photos) == 0 {
+		return nil
+	}
+
+	return photos[0]
+}
+
+// Looks up several photos from one album, loading the album only once. The
+// photos come back in the order they were asked for.
+func findSavedPhotos(username string, albumName string, photoNames []string) []*SavedPhoto {
+	if !canViewAlbum(username, albumName) {
+		return nil
+	}
+
+	var sitePhotos, originalPhotos []*Photo
+	if gallery_db.GetIndexedAlbum(albumName) != nil {
+		sitePhotos, originalPhotos = gallery_db.GetIndexedAlbumPhotos(albumName)
+	} else {
+		sitePhotos, originalPhotos = gallery_db.GetAlbumPhotos(albumName)
+	}
+
+	found := make(map[string]*SavedPhoto)
+	for i, originalPhoto := range originalPhotos {
+		if i < len(sitePhotos) {
+			found[originalPhoto.Name] = &SavedPhoto{Album: albumName, Name: originalPhoto.Name, Path: sitePhotos[i].Path, Caption: sitePhotos[i].Caption}
+		}
+	}
+
+	photos := make([]*SavedPhoto, 0, len(photoNames))
+	for _, photoName := range photoNames {
+		if photo, ok := found[photoName]; ok {
+			photos = append(photos, photo)
+		}
+	}
+
+	return photos
+}
+
+// Bookmarks of content that has since been deleted, or that the member can
+// no longer see, are left out. Photos are grouped by album, in the order
+// each album was first bookmarked.
+func getSavedPage(username string) *SavedPage {
+	pageData := &SavedPage{Title: "Saved", Articles: make([]*Article, 0), Photos: make([]*SavedPhoto, 0)}
+
+	albums := make([]string, 0)
+	albumPhotos := make(map[string][]string)
+	for _, bookmark := range bookmark_db.GetBookmarks(username) {
+		switch bookmark.Kind {
+		case bookmark_db.KindArticle:
+			if article := blog_db.GetArticle(bookmark.Ref); article != nil && article.CanView(username) {
+				pageData.Articles = append(pageData.Articles, article)
+			}
+		case bookmark_db.KindPhoto:
+			albumName, photoName, _ := strings.Cut(bookmark.Ref, "/")
+			if _, ok := albumPhotos[albumName]; !ok {
+				albums = append(albums, albumName)
+			}
+			albumPhotos[albumName] = append(albumPhotos[albumName], photoName)
+		}
+	}
+
+	for _, albumName := range albums {
+		pageData.Photos = append(pageData.Photos, findSavedPhotos(username, albumName, albumPhotos[albumName])...)
+	}
+
+	return pageData
+}
+
+func servSaved(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servSaved()")
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/saved.html")
+	err := t.Execute(w, getSavedPage(username))
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servBookmarksAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servBookmarksAPI()")
+
+	writeJSON(w, http.StatusOK, getSavedPage(username))
+}
+
+// Returns the bookmark's kind and reference for the request's path, checking
+// that the member can see what it refers to.
+func bookmarkFromPath(r *http.Request, username string) (string, string, bool) {
+	if key := r.PathValue("key"); len(key) > 0 {
+		article := blog_db.GetArticle(key)
+		return bookmark_db.KindArticle, key, article != nil && article.CanView(username)
+	}
+
+	albumName, photoName := r.PathValue("album"), r.PathValue("photo")
+	return bookmark_db.KindPhoto, albumName + "/" + photoName, findSavedPhoto(username, albumName, photoName) != nil
+}
+
+func servAddBookmarkAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	kind, ref, found := bookmarkFromPath(r, username)
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "Nothing to bookmark at "+r.URL.Path)
+		return
+	}
+
+	bookmark, err := bookmark_db.AddBookmark(username, kind, ref)
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save bookmark")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servAddBookmarkAPI()", "kind", kind, "ref", ref, "username", username)
+
+	writeJSON(w, http.StatusOK, bookmark)
+}
+
+// Removing doesn't check the content, so bookmarks of deleted articles and
+// photos can still be cleared.
+func servRemoveBookmarkAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	kind, ref := bookmark_db.KindArticle, r.PathValue("key")
+	if len(ref) == 0 {
+		kind, ref = bookmark_db.KindPhoto, r.PathValue("album")+"/"+r.PathValue("photo")
+	}
+
+	if err := bookmark_db.RemoveBookmark(username, kind, ref); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to remove bookmark")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servRemoveBookmarkAPI()", "kind", kind, "ref", ref, "username", username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
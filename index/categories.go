@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+)
+
+type Category = blog_db.Category
+
+type CategoryPage struct {
+	Title         string          `json:"title"`
+	Category      *Category       `json:"category"`
+	Breadcrumbs   []*Category     `json:"breadcrumbs"`
+	Subcategories []*Category     `json:"subcategories"`
+	Articles      []*Article      `json:"articles"`
+	Unread        map[string]bool `json:"-"`
+}
+
+func servCategory(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	slug := r.PathValue("slug")
+
+	logger.DebugContext(r.Context(), "servCategory()", "slug", slug)
+
+	pageData := new(CategoryPage)
+	if pageData.Category = blog_db.GetCategory(slug); pageData.Category == nil {
+		http.NotFound(w, r)
+		return
+	}
+	pageData.Title = pageData.Category.Name
+	pageData.Breadcrumbs = blog_db.GetCategoryPath(slug)
+	pageData.Subcategories = blog_db.GetSubcategories(slug)
+	pageData.Articles = visibleArticles(username, blog_db.GetArticlesInCategory(slug))
+	blog_db.SortByDate(pageData.Articles)
+	pageData.Unread = unreadArticles(username, pageData.Articles)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/category.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servCategoriesAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servCategoriesAPI()")
+
+	writeJSON(w, http.StatusOK, blog_db.GetCategories())
+}
+
+func servAddCategoryAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	request := struct {
+		Name   string `json:"name"`
+		Parent string `json:"parent"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	category, err := blog_db.AddCategory(request.Name, request.Parent)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Category created", "slug", category.Slug, "parent", category.Parent, "username", username)
+	audit_db.Record(audit_db.ActionCategoryCreated, username, clientIP(r), category.Slug)
+
+	writeJSON(w, http.StatusCreated, blog_db.GetCategory(category.Slug))
+}
+
+func servDeleteCategoryAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	slug := r.PathValue("slug")
+
+	count, err := blog_db.DeleteCategory(slug)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Category deleted", "slug", slug, "articles", count, "username", username)
+	audit_db.Record(audit_db.ActionCategoryDeleted, username, clientIP(r), slug+", "+strconv.Itoa(count)+" articles moved up")
+
+	writeJSON(w, http.StatusOK, map[string]int{"articles": count})
+}
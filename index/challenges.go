@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/challenge_db"
+)
+
+type Challenge = challenge_db.Challenge
+
+// Leaderboards cover the last leaderboardDays days unless ?days= says
+// otherwise.
+const leaderboardDays = 7
+
+type ChallengeView struct {
+	*Challenge
+	Participant *challenge_db.Participant `json:"participant,omitempty"`
+	Leaderboard []*challenge_db.Standing  `json:"leaderboard"`
+	Entries     []*challenge_db.Entry     `json:"entries,omitempty"`
+}
+
+type ChallengesPage struct {
+	Title      string           `json:"title"`
+	Days       int              `json:"days"`
+	Today      string           `json:"today"`
+	Challenges []*ChallengeView `json:"challenges"`
+}
+
+func leaderboardSince(days int) time.Time {
+	return time.Now().AddDate(0, 0, 1-days)
+}
+
+func getChallengeView(challenge *Challenge, username string, days int) *ChallengeView {
+	view := &ChallengeView{Challenge: challenge}
+	view.Participant = challenge_db.GetParticipant(challenge.ID, username)
+	view.Leaderboard = challenge_db.Leaderboard(challenge.ID, username, leaderboardSince(days))
+	if view.Participant != nil {
+		view.Entries = challenge_db.GetEntries(challenge.ID, username, leaderboardSince(days))
+	}
+	return view
+}
+
+func getChallengesPage(r *http.Request, username string) *ChallengesPage {
+	pageData := new(ChallengesPage)
+	pageData.Title = "Challenges"
+	pageData.Today = time.Now().Format("2006-01-02")
+
+	pageData.Days = leaderboardDays
+	if days, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && days > 0 && days <= 366 {
+		pageData.Days = days
+	}
+
+	pageData.Challenges = make([]*ChallengeView, 0)
+	for _, challenge := range challenge_db.GetChallenges() {
+		pageData.Challenges = append(pageData.Challenges, getChallengeView(challenge, username, pageData.Days))
+	}
+
+	return pageData
+}
+
+func challengeID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil || challenge_db.GetChallenge(uint(id)) == nil {
+		writeJSONError(w, http.StatusNotFound, "Challenge not found")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func servChallenges(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servChallenges()")
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/challenges.html")
+	err := t.Execute(w, getChallengesPage(r, username))
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servChallengesAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servChallengesAPI()")
+
+	writeJSON(w, http.StatusOK, getChallengesPage(r, username).Challenges)
+}
+
+func servCreateChallengeAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	challenge := new(Challenge)
+	if err := json.NewDecoder(r.Body).Decode(challenge); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	challenge.CreatedBy = username
+
+	if err := challenge_db.CreateChallenge(challenge); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Challenge created", "challenge.ID", challenge.ID, "challenge.Name", challenge.Name, "username", username)
+
+	writeJSON(w, http.StatusCreated, getChallengeView(challenge, username, leaderboardDays))
+}
+
+// Joins the challenge or changes who sees the member's numbers.
+func servJoinChallengeAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, ok := challengeID(w, r)
+	if !ok {
+		return
+	}
+
+	request := struct {
+		Sharing string `json:"sharing"`
+	}{Sharing: challenge_db.SharingParticipants}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	participant, err := challenge_db.Join(id, username, request.Sharing)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, participant)
+}
+
+func servLeaveChallengeAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, ok := challengeID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := challenge_db.Leave(id, username); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to leave challenge")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Left challenge"})
+}
+
+func servLogChallengeAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, ok := challengeID(w, r)
+	if !ok {
+		return
+	}
+
+	request := struct {
+		Day   string `json:"day"`
+		Value int    `json:"value"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	day := time.Now()
+	if len(request.Day) > 0 {
+		var err error
+		if day, err = time.ParseInLocation("2006-01-02", request.Day, time.Local); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid day, expected YYYY-MM-DD")
+			return
+		}
+	}
+
+	entry, err := challenge_db.LogEntry(id, username, day, request.Value)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entry)
+}
+
+func servChallengeLeaderboardAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, ok := challengeID(w, r)
+	if !ok {
+		return
+	}
+
+	days := leaderboardDays
+	if value, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && value > 0 && value <= 366 {
+		days = value
+	}
+
+	writeJSON(w, http.StatusOK, challenge_db.Leaderboard(id, username, leaderboardSince(days)))
+}
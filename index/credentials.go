@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tg123/go-htpasswd"
+)
+
+// Checking a password parses .htpasswd and runs bcrypt, too slow to repeat
+// for each of the photos on a page, so credentials that matched are
+// remembered for a short while. They are keyed by a hash of the
+// Authorization header so the password itself is never kept.
+const credentialTTL = 5 * time.Minute
+
+type verifiedCredential struct {
+	Username string
+	Expires  time.Time
+}
+
+var (
+	credentials      = make(map[[sha256.Size]byte]*verifiedCredential)
+	credentialsMutex sync.Mutex
+)
+
+func credentialKey(r *http.Request) [sha256.Size]byte {
+	return sha256.Sum256([]byte(r.Header.Get("Authorization")))
+}
+
+// Returns the member whose credentials the request carries, if they were
+// verified within credentialTTL.
+func verifiedUser(r *http.Request) (string, bool) {
+	if len(r.Header.Get("Authorization")) == 0 {
+		return "", false
+	}
+
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	credential, ok := credentials[credentialKey(r)]
+	if !ok || time.Now().After(credential.Expires) {
+		return "", false
+	}
+	return credential.Username, true
+}
+
+// Matches the request's basic auth credentials against .htpasswd, unless
+// the same credentials matched recently.
+func matchCredentials(r *http.Request, username string, password string) (bool, error) {
+	if verified, ok := verifiedUser(r); ok && verified == username {
+		return true, nil
+	}
+
+	myauth, err := htpasswd.New(htpasswdFile, htpasswd.DefaultSystems, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if !myauth.Match(username, password) {
+		return false, nil
+	}
+
+	credentialsMutex.Lock()
+	credentials[credentialKey(r)] = &verifiedCredential{Username: username, Expires: time.Now().Add(credentialTTL)}
+	credentialsMutex.Unlock()
+
+	return true, nil
+}
+
+// Forgets the member's verified credentials, so a removed account can't keep
+// using them until they expire.
+func forgetCredentials(username string) {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	for key, credential := range credentials {
+		if credential.Username == username {
+			delete(credentials, key)
+		}
+	}
+}
+
+func pruneCredentials(now time.Time) {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	for key, credential := range credentials {
+		if now.After(credential.Expires) {
+			delete(credentials, key)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func photoRequest(username string, password string) *http.Request {
+	r := httptest.NewRequest("GET", "/photos/galleries/Beach/IMG_0001.jpg", nil)
+	if len(username) > 0 {
+		r.SetBasicAuth(username, password)
+	}
+	return r
+}
+
+func TestVerifiedUser(t *testing.T) {
+	now := time.Now()
+
+	credentialsMutex.Lock()
+	credentials = map[[sha256.Size]byte]*verifiedCredential{
+		credentialKey(photoRequest("alice", "secret")):     {Username: "alice", Expires: now.Add(credentialTTL)},
+		credentialKey(photoRequest("alice", "old secret")): {Username: "alice", Expires: now.Add(-time.Second)},
+		credentialKey(photoRequest("bob", "secret")):       {Username: "bob", Expires: now.Add(credentialTTL)},
+	}
+	credentialsMutex.Unlock()
+	forgetCredentials("bob")
+
+	tests := []struct {
+		name     string
+		request  *http.Request
+		username string
+		verified bool
+	}{
+		{"recently verified", photoRequest("alice", "secret"), "alice", true},
+		{"another password", photoRequest("alice", "guess"), "", false},
+		{"expired", photoRequest("alice", "old secret"), "", false},
+		{"forgotten", photoRequest("bob", "secret"), "", false},
+		{"no credentials", photoRequest("", ""), "", false},
+	}
+
+	for _, test := range tests {
+		if username, verified := verifiedUser(test.request); username != test.username || verified != test.verified {
+			t.Errorf("%s: verifiedUser = %q, %v, want %q, %v", test.name, username, verified, test.username, test.verified)
+		}
+	}
+
+	pruneCredentials(now)
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	if len(credentials) != 1 {
+		t.Errorf("pruneCredentials kept %d credentials, want 1", len(credentials))
+	}
+}
+
+func TestRateLimitPhotoBucket(t *testing.T) {
+	resetRateLimits(t)
+
+	credentialsMutex.Lock()
+	credentials = map[[sha256.Size]byte]*verifiedCredential{
+		credentialKey(photoRequest("alice", "secret")): {Username: "alice", Expires: time.Now().Add(credentialTTL)},
+	}
+	credentialsMutex.Unlock()
+
+	handler := rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	tokens := func(key string) float64 {
+		bucketsMutex.Lock()
+		defer bucketsMutex.Unlock()
+		if bucket, ok := buckets[key]; ok {
+			return bucket.Tokens
+		}
+		return -1
+	}
+
+	// httptest requests come from 192.0.2.1
+	for i := 0; i < requestBurst; i++ {
+		if code := serve(photoRequest("alice", "secret")); code != http.StatusOK {
+			t.Fatalf("verified photo request %d = %d, want %d", i+1, code, http.StatusOK)
+		}
+	}
+	if remaining := tokens("photos:192.0.2.1"); remaining < photoBurst-requestBurst-1 {
+		t.Errorf("photo bucket has %v tokens, want at least %v", remaining, photoBurst-requestBurst-1)
+	}
+	if remaining := tokens("192.0.2.1"); remaining != -1 {
+		t.Errorf("verified photo requests drew on the address's bucket, %v tokens left", remaining)
+	}
+
+	// Pages and unverified photo requests draw on the address's own bucket
+	serve(httptest.NewRequest("GET", "/", nil))
+	serve(photoRequest("alice", "guess"))
+	if remaining := tokens("192.0.2.1"); remaining < requestBurst-3 || remaining >= requestBurst-1 {
+		t.Errorf("address bucket has %v tokens, want about %v", remaining, requestBurst-2)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_backup"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+)
+
+// The data directory (photos, articles, credentials and the database next to
+// the index directory) is stamped with the layout version it was last
+// migrated to. Layout changes add a migration below and bump
+// currentDataVersion; older directories are backed up and migrated in order
+// at startup, and a directory written by a newer release is refused.
+const (
+	currentDataVersion = 2
+	dataVersionFile    = "../blazemarker.version"
+)
+
+type DataVersion struct {
+	Version  int       `json:"version"`
+	Migrated time.Time `json:"migrated"`
+}
+
+type dataMigration struct {
+	Version     int
+	Description string
+	Migrate     func() error
+}
+
+// Migrations in version order. Each brings the directory from Version-1 to
+// Version.
+var dataMigrations = []*dataMigration{
+	{Version: 2, Description: "move the Now article to the Now page store", Migrate: migrateNowArticles},
+}
+
+// The Now page used to be a single article read from a fixed file. It becomes
+// its author's current Now page; the article itself is left in place.
+func migrateNowArticles() error {
+	for _, article := range blog_db.GetNowArticles() {
+		imported, err := blog_db.ImportNowPage(article)
+		if err != nil {
+			return err
+		}
+		if imported {
+			logger.Info("Imported Now page", "author", article.Author, "article.Title", article.Title)
+		}
+	}
+	return nil
+}
+
+func readDataVersion() (*DataVersion, error) {
+	jsonData, err := os.ReadFile(dataVersionFile)
+	if err != nil {
+		return nil, err
+	}
+
+	dataVersion := new(DataVersion)
+	if err := json.Unmarshal(jsonData, dataVersion); err != nil {
+		return nil, err
+	}
+
+	return dataVersion, nil
+}
+
+func writeDataVersion(version int) error {
+	jsonData, err := json.MarshalIndent(&DataVersion{Version: version, Migrated: time.Now()}, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dataVersionFile, jsonData, 0644)
+}
+
+// Brings the data directory up to currentDataVersion. An unstamped directory
+// predates versioning and is stamped with version 1.
+func migrateDataDir() error {
+	dataVersion, err := readDataVersion()
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Info("Stamping data directory", "version", 1)
+		if err := writeDataVersion(1); err != nil {
+			return err
+		}
+		dataVersion = &DataVersion{Version: 1}
+	} else if err != nil {
+		return fmt.Errorf("unreadable %s: %w", dataVersionFile, err)
+	}
+
+	if dataVersion.Version > currentDataVersion {
+		return fmt.Errorf("data directory is version %d but this release only understands up to version %d", dataVersion.Version, currentDataVersion)
+	}
+	if dataVersion.Version == currentDataVersion {
+		return nil
+	}
+
+	backup, err := blaze_backup.CreateBackup()
+	if err != nil {
+		return fmt.Errorf("not migrating data directory without a database backup: %w", err)
+	}
+	logger.Info("Migrating data directory", "from", dataVersion.Version, "to", currentDataVersion, "backup", backup.Name)
+
+	for _, migration := range dataMigrations {
+		if migration.Version <= dataVersion.Version {
+			continue
+		}
+
+		logger.Info("Running data migration", "version", migration.Version, "description", migration.Description)
+		if err := migration.Migrate(); err != nil {
+			return fmt.Errorf("data migration to version %d (%s) failed, restore with -restore %s: %w", migration.Version, migration.Description, backup.Name, err)
+		}
+		if err := writeDataVersion(migration.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"slices"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/tg123/go-htpasswd"
+)
+
+// The doctor checks the installation up front, so a missing template or a
+// read only directory is reported with a fix at startup instead of failing
+// at first use. Run it alone with -doctor.
+
+type Finding struct {
+	Check   string `json:"check"`
+	OK      bool   `json:"ok"`
+	Problem string `json:"problem,omitempty"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+func checkTemplates() error {
+	filenames, err := filepath.Glob("../templates/*.html")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(filenames, "../templates/base.html") {
+		return errors.New("../templates/base.html is missing")
+	}
+
+	for _, filename := range filenames {
+		if filename == "../templates/base.html" {
+			continue
+		}
+		if _, err := template.New("base.html").Funcs(templateFuncs(nil, "")).ParseFiles("../templates/base.html", filename); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Admins and disabled users must be members with a password.
+func checkCredentials() error {
+	if _, err := htpasswd.New(htpasswdFile, htpasswd.DefaultSystems, nil); err != nil {
+		return err
+	}
+
+	usernames, err := readUsernames(htpasswdFile)
+	if err != nil {
+		return err
+	}
+	if len(usernames) == 0 {
+		return errors.New(htpasswdFile + " has no users")
+	}
+
+	admins, err := readUsernames("../blaze_auth/admins")
+	if err != nil {
+		return err
+	}
+	if len(admins) == 0 {
+		return errors.New("no admins listed in ../blaze_auth/admins")
+	}
+
+	disabled, err := readUsernames(disabledFile)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range append(admins, disabled...) {
+		if !slices.Contains(usernames, name) {
+			return fmt.Errorf("%s is listed as an admin or disabled but has no password in %s", name, htpasswdFile)
+		}
+	}
+
+	return nil
+}
+
+func runDoctor() []*Finding {
+	findings := make([]*Finding, 0)
+
+	addFinding := func(check string, err error, fix string) {
+		finding := &Finding{Check: check, OK: err == nil}
+		if err != nil {
+			finding.Problem = err.Error()
+			finding.Fix = fix
+		}
+		findings = append(findings, finding)
+	}
+
+	addFinding("templates", checkTemplates(), "restore the templates directory from the release")
+	for _, dir := range writableDirs() {
+		addFinding("writable "+dir, checkWritable(dir), "create "+dir+" and make it writable by the server's user")
+	}
+	addFinding("database integrity", audit_db.IntegrityCheck(), "restore the database from a backup with -restore")
+	addFinding("credentials", checkCredentials(), "add the missing users with htpasswd or fix ../blaze_auth/admins and ../blaze_auth/disabled")
+
+	return findings
+}
+
+// Prints the findings and reports whether every check passed.
+func printDoctor(findings []*Finding) bool {
+	healthy := true
+	for _, finding := range findings {
+		if finding.OK {
+			fmt.Println("ok     ", finding.Check)
+			continue
+		}
+		healthy = false
+		fmt.Println("FAILED ", finding.Check+":", finding.Problem)
+		fmt.Println("        fix:", finding.Fix)
+	}
+	return healthy
+}
+
+func logDoctor(findings []*Finding) {
+	for _, finding := range findings {
+		if !finding.OK {
+			logger.Warn("Doctor check failed", "check", finding.Check, "problem", finding.Problem, "fix", finding.Fix)
+		}
+	}
+}
@@ -3,15 +3,71 @@ module github.com/jeffereydecker/blazemarker/index
 go 1.22.5
 
 require (
+	github.com/jeffereydecker/blazemarker/activity_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/audit_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/blaze_backup v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/blaze_db v0.0.0-00010101000000-000000000000
 	github.com/jeffereydecker/blazemarker/blaze_log v0.0.0-20240721023413-f4c6ed51da8c
 	github.com/jeffereydecker/blazemarker/blog_db v0.0.0-20240721023413-f4c6ed51da8c
+	github.com/jeffereydecker/blazemarker/bookmark_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/challenge_db v0.0.0-00010101000000-000000000000
 	github.com/jeffereydecker/blazemarker/gallery_db v0.0.0-20240721023413-f4c6ed51da8c
+	github.com/jeffereydecker/blazemarker/guestbook_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/inventory_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/list_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/location_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/poll_db v0.0.0-00010101000000-000000000000
+	github.com/jeffereydecker/blazemarker/recipe_db v0.0.0-00010101000000-000000000000
 	github.com/tg123/go-htpasswd v1.2.2
+	golang.org/x/crypto v0.24.0
+	gorm.io/gorm v1.25.11
 )
 
 require (
 	github.com/GehirnInc/crypt v0.0.0-20200316065508-bb7000b8a962 // indirect
+	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/disintegration/imaging v1.6.2 // indirect
-	golang.org/x/crypto v0.17.0 // indirect
-	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	github.com/jeffereydecker/blazemarker/blaze_http v0.0.0-00010101000000-000000000000 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	github.com/microcosm-cc/bluemonday v1.0.27 // indirect
+	github.com/yuin/goldmark v1.7.4 // indirect
+	golang.org/x/image v0.18.0 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	gorm.io/driver/sqlite v1.5.6 // indirect
 )
+
+replace github.com/jeffereydecker/blazemarker/audit_db => ../audit_db
+
+replace github.com/jeffereydecker/blazemarker/blaze_backup => ../blaze_backup
+
+replace github.com/jeffereydecker/blazemarker/challenge_db => ../challenge_db
+
+replace github.com/jeffereydecker/blazemarker/list_db => ../list_db
+
+replace github.com/jeffereydecker/blazemarker/recipe_db => ../recipe_db
+
+replace github.com/jeffereydecker/blazemarker/location_db => ../location_db
+
+replace github.com/jeffereydecker/blazemarker/poll_db => ../poll_db
+
+replace github.com/jeffereydecker/blazemarker/guestbook_db => ../guestbook_db
+
+replace github.com/jeffereydecker/blazemarker/bookmark_db => ../bookmark_db
+
+replace github.com/jeffereydecker/blazemarker/activity_db => ../activity_db
+
+replace github.com/jeffereydecker/blazemarker/blaze_log => ../blaze_log
+
+replace github.com/jeffereydecker/blazemarker/blog_db => ../blog_db
+
+replace github.com/jeffereydecker/blazemarker/gallery_db => ../gallery_db
+
+replace github.com/jeffereydecker/blazemarker/blaze_db => ../blaze_db
+
+replace github.com/jeffereydecker/blazemarker/blaze_http => ../blaze_http
+
+replace github.com/jeffereydecker/blazemarker/inventory_db => ../inventory_db
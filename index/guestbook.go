@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/guestbook_db"
+)
+
+type GuestbookEntry = guestbook_db.Entry
+
+type GuestbookPage struct {
+	Title    string            `json:"title"`
+	Entries  []*GuestbookEntry `json:"entries"`
+	Name     string            `json:"name"`
+	Message  string            `json:"message"`
+	Status   string            `json:"status"`
+	Captcha  string            `json:"captcha"`
+	Question string            `json:"question"`
+}
+
+// Visitors answer a small sum before they can sign. Challenges are signed
+// rather than stored, so asking for them costs the server nothing, and each
+// address can sign a few times an hour. A challenge can only be answered once:
+// its nonce is remembered from the first attempt until the challenge expires.
+const (
+	captchaTTL         = 10 * time.Minute
+	maxGuestbookPosts  = 3
+	guestbookPostEvery = time.Hour
+)
+
+var (
+	captchaKey = newCaptchaKey()
+
+	guestbookPosts      = make(map[string][]time.Time)
+	usedCaptchas        = make(map[string]time.Time) // Nonce to expiry
+	guestbookPostsMutex sync.Mutex
+)
+
+// Challenges handed out before a restart can no longer be answered.
+func newCaptchaKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func captchaMAC(nonce string, expires string, answer int) string {
+	mac := hmac.New(sha256.New, captchaKey)
+	mac.Write([]byte(nonce + "." + expires + "." + strconv.Itoa(answer)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// The token carries a nonce, the expiry and a MAC over both and the answer.
+func newCaptcha() (string, string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	a, c := int(b[0]%10)+1, int(b[1]%10)+1
+	nonce := hex.EncodeToString(b[2:])
+	expires := strconv.FormatInt(time.Now().Add(captchaTTL).Unix(), 10)
+	token := nonce + "." + expires + "." + captchaMAC(nonce, expires, a+c)
+
+	return token, "What is " + strconv.Itoa(a) + " plus " + strconv.Itoa(c) + "?", nil
+}
+
+func checkCaptcha(token string, answer string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	// Wrong answers use the challenge up too, so its few possible sums can't
+	// be tried in turn
+	guestbookPostsMutex.Lock()
+	_, used := usedCaptchas[parts[0]]
+	if !used {
+		usedCaptchas[parts[0]] = time.Unix(expires, 0)
+	}
+	guestbookPostsMutex.Unlock()
+	if used {
+		return false
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal([]byte(parts[2]), []byte(captchaMAC(parts[0], parts[1], value)))
+}
+
+// Records a post from ip unless it has already signed maxGuestbookPosts
+// times in the last guestbookPostEvery.
+func allowGuestbookPost(ip string) bool {
+	guestbookPostsMutex.Lock()
+	defer guestbookPostsMutex.Unlock()
+
+	now := time.Now()
+	posts := make([]time.Time, 0, maxGuestbookPosts)
+	for _, posted := range guestbookPosts[ip] {
+		if now.Sub(posted) < guestbookPostEvery {
+			posts = append(posts, posted)
+		}
+	}
+
+	if len(posts) >= maxGuestbookPosts {
+		guestbookPosts[ip] = posts
+		return false
+	}
+
+	guestbookPosts[ip] = append(posts, now)
+	return true
+}
+
+// Drops addresses that haven't signed within guestbookPostEvery and the
+// nonces of challenges that have expired.
+func pruneGuestbookPosts(now time.Time) {
+	guestbookPostsMutex.Lock()
+	defer guestbookPostsMutex.Unlock()
+
+	for ip, posts := range guestbookPosts {
+		if len(posts) == 0 || now.Sub(posts[len(posts)-1]) >= guestbookPostEvery {
+			delete(guestbookPosts, ip)
+		}
+	}
+
+	for nonce, expires := range usedCaptchas {
+		if now.After(expires) {
+			delete(usedCaptchas, nonce)
+		}
+	}
+}
+
+func servGuestbook(w http.ResponseWriter, r *http.Request) {
+	pageData := new(GuestbookPage)
+	pageData.Title = "Guestbook"
+
+	switch r.Method {
+	case http.MethodGet:
+		logger.DebugContext(r.Context(), "servGuestbook()[GET]")
+		if r.URL.Query().Has("signed") {
+			pageData.Status = "Thanks! Your message will appear once it has been approved."
+		}
+	case http.MethodPost:
+		logger.DebugContext(r.Context(), "servGuestbook()[POST]")
+
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorContext(r.Context(), "Form parsing error")
+			http.Error(w, "Form parsing error", http.StatusBadRequest)
+			return
+		}
+
+		ip := clientIP(r)
+		pageData.Name = r.FormValue("name")
+		pageData.Message = r.FormValue("message")
+
+		entry := &GuestbookEntry{Name: pageData.Name, Message: pageData.Message, IP: ip}
+		if !checkCaptcha(r.FormValue("captcha"), r.FormValue("answer")) {
+			pageData.Status = "That answer wasn't right, please try again"
+		} else if !allowGuestbookPost(ip) {
+			logger.WarnContext(r.Context(), "Guestbook rate limit exceeded", "ip", ip)
+			pageData.Status = "You've signed the guestbook a few times already, please try again later"
+		} else if err := guestbook_db.AddEntry(entry); err != nil {
+			pageData.Status = err.Error()
+		} else {
+			logger.InfoContext(r.Context(), "Guestbook signed", "entry.ID", entry.ID, "entry.Name", entry.Name, "ip", ip)
+			http.Redirect(w, r, "/guestbook?signed", http.StatusFound)
+			return
+		}
+	default:
+		logger.InfoContext(r.Context(), "Method not allowed", "r.Method", r.Method)
+		return
+	}
+
+	var err error
+	if pageData.Captcha, pageData.Question, err = newCaptcha(); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		http.Error(w, "Guestbook unavailable", http.StatusInternalServerError)
+		return
+	}
+	pageData.Entries = guestbook_db.GetApprovedEntries()
+
+	t, _ := parseTemplates(r, currentUser(r), "../templates/base.html", "../templates/guestbook.html")
+	err = t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func guestbookEntryFromPath(w http.ResponseWriter, r *http.Request) *GuestbookEntry {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Entry not found")
+		return nil
+	}
+
+	entry := guestbook_db.GetEntry(uint(id))
+	if entry == nil {
+		writeJSONError(w, http.StatusNotFound, "Entry not found")
+		return nil
+	}
+
+	return entry
+}
+
+func servGuestbookQueueAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servGuestbookQueueAPI()")
+
+	writeJSON(w, http.StatusOK, guestbook_db.GetPendingEntries())
+}
+
+func servApproveGuestbookAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	entry := guestbookEntryFromPath(w, r)
+	if entry == nil {
+		return
+	}
+
+	if err := guestbook_db.ApproveEntry(entry, username); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to approve entry")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Guestbook entry approved", "entry.ID", entry.ID, "username", username)
+	audit_db.Record(audit_db.ActionGuestbookApproved, username, clientIP(r), "entry "+strconv.FormatUint(uint64(entry.ID), 10)+" by "+entry.Name)
+
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// Rejects a queued entry, or takes down an approved one.
+func servDeleteGuestbookAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	entry := guestbookEntryFromPath(w, r)
+	if entry == nil {
+		return
+	}
+
+	if err := guestbook_db.DeleteEntry(entry.ID); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to delete entry")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Guestbook entry deleted", "entry.ID", entry.ID, "username", username)
+	audit_db.Record(audit_db.ActionGuestbookRejected, username, clientIP(r), "entry "+strconv.FormatUint(uint64(entry.ID), 10)+" by "+entry.Name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testCaptcha(nonce string, expires time.Time, answer int) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return nonce + "." + expiry + "." + captchaMAC(nonce, expiry, answer)
+}
+
+func TestCheckCaptcha(t *testing.T) {
+	guestbookPostsMutex.Lock()
+	usedCaptchas = make(map[string]time.Time)
+	guestbookPostsMutex.Unlock()
+
+	later := time.Now().Add(captchaTTL)
+
+	type attempt struct {
+		answer string
+		valid  bool
+	}
+	tests := []struct {
+		name     string
+		token    string
+		attempts []attempt
+	}{
+		{"right answer", testCaptcha("a1", later, 7), []attempt{{"7", true}}},
+		{"answer with spaces", testCaptcha("a2", later, 7), []attempt{{" 7 ", true}}},
+		{"wrong answer", testCaptcha("a3", later, 7), []attempt{{"8", false}}},
+		{"not a number", testCaptcha("a4", later, 7), []attempt{{"seven", false}}},
+		{"reused after a right answer", testCaptcha("a5", later, 7), []attempt{{"7", true}, {"7", false}}},
+		{"retried after a wrong answer", testCaptcha("a6", later, 7), []attempt{{"8", false}, {"7", false}}},
+		{"expired", testCaptcha("a7", time.Now().Add(-time.Second), 7), []attempt{{"7", false}}},
+		{"answer signed under another nonce", "a8" + testCaptcha("x", later, 7)[1:], []attempt{{"7", false}}},
+		{"missing part", "a9." + strconv.FormatInt(later.Unix(), 10), []attempt{{"7", false}}},
+		{"bad expiry", "a10.soon.mac", []attempt{{"7", false}}},
+		{"empty", "", []attempt{{"7", false}}},
+	}
+
+	for _, test := range tests {
+		for i, attempt := range test.attempts {
+			if valid := checkCaptcha(test.token, attempt.answer); valid != attempt.valid {
+				t.Errorf("%s: attempt %d with %q = %v, want %v", test.name, i+1, attempt.answer, valid, attempt.valid)
+			}
+		}
+	}
+
+	pruneGuestbookPosts(later.Add(time.Second))
+	guestbookPostsMutex.Lock()
+	defer guestbookPostsMutex.Unlock()
+	if len(usedCaptchas) != 0 {
+		t.Errorf("pruneGuestbookPosts kept %d used challenges, want none", len(usedCaptchas))
+	}
+}
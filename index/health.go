@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+	"github.com/tg123/go-htpasswd"
+)
+
+// /healthz only reports that the process is serving. /readyz also checks the
+// dependencies a request needs and answers 503 when any of them fail, for
+// systemd or container probes. Neither requires authentication.
+
+type HealthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Ready   bool           `json:"ready"`
+	Checked time.Time      `json:"checked"`
+	Checks  []*HealthCheck `json:"checks"`
+}
+
+var startTime = time.Now()
+
+// Every gallery root needs to be writable for its site photos.
+func writableDirs() []string {
+	dirs := make([]string, 0)
+	for _, root := range gallery_db.GetGalleryRoots() {
+		dirs = append(dirs, strings.TrimSuffix(root.Path, "/"))
+	}
+	return append(dirs, "../articles", "../logs")
+}
+
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+
+	return os.Remove(name)
+}
+
+func checkReadiness() *Readiness {
+	readiness := &Readiness{Ready: true, Checked: time.Now()}
+
+	addCheck := func(name string, err error) {
+		check := &HealthCheck{Name: name, OK: err == nil}
+		if err != nil {
+			check.Error = err.Error()
+			readiness.Ready = false
+		}
+		readiness.Checks = append(readiness.Checks, check)
+	}
+
+	addCheck("database", audit_db.Ping())
+
+	_, err := htpasswd.New("../blaze_auth/.htpasswd", htpasswd.DefaultSystems, nil)
+	addCheck("credentials", err)
+
+	for _, dir := range writableDirs() {
+		addCheck("writable "+dir, checkWritable(dir))
+	}
+
+	return readiness
+}
+
+func servHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status": "ok",
+		"uptime": time.Since(startTime).Round(time.Second).String(),
+	})
+}
+
+func servReadyz(w http.ResponseWriter, r *http.Request) {
+	readiness := checkReadiness()
+
+	status := http.StatusOK
+	if !readiness.Ready {
+		for _, check := range readiness.Checks {
+			if !check.OK {
+				logger.WarnContext(r.Context(), "Readiness check failed", "check", check.Name, "error", check.Error)
+			}
+		}
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, readiness)
+}
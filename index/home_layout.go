@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Members choose which home page widgets they see and in what order. Layouts
+// are stored per username in ../home_layouts.json; members without one get
+// defaultHomeLayout.
+
+const (
+	WidgetWelcome         = "welcome"
+	WidgetPhotoOfTheDay   = "photo_of_the_day"
+	WidgetArticleOfTheDay = "article_of_the_day"
+	WidgetActivity        = "activity"
+	WidgetNow             = "now"
+)
+
+type HomeWidget struct {
+	Name    string `json:"name"`
+	Visible bool   `json:"visible"`
+}
+
+var defaultHomeLayout = []*HomeWidget{
+	{Name: WidgetWelcome, Visible: true},
+	{Name: WidgetPhotoOfTheDay, Visible: true},
+	{Name: WidgetArticleOfTheDay, Visible: true},
+	{Name: WidgetActivity, Visible: false},
+	{Name: WidgetNow, Visible: false},
+}
+
+const (
+	homeLayoutsFile  = "../home_layouts.json"
+	homeActivitySize = 5
+	homeNowSize      = 2
+)
+
+var homeLayoutsMutex sync.Mutex
+
+func readHomeLayouts() map[string][]*HomeWidget {
+	layouts := make(map[string][]*HomeWidget)
+
+	jsonData, err := os.ReadFile(homeLayoutsFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return layouts
+	}
+
+	if err := json.Unmarshal(jsonData, &layouts); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return layouts
+}
+
+// Returns the user's layout with any widgets added since it was saved
+// appended, hidden.
+func getHomeLayout(username string) []*HomeWidget {
+	layout, ok := readHomeLayouts()[username]
+	if !ok {
+		layout = make([]*HomeWidget, 0, len(defaultHomeLayout))
+		for _, widget := range defaultHomeLayout {
+			layout = append(layout, &HomeWidget{Name: widget.Name, Visible: widget.Visible})
+		}
+		return layout
+	}
+
+	for _, widget := range defaultHomeLayout {
+		if !slices.ContainsFunc(layout, func(w *HomeWidget) bool { return w.Name == widget.Name }) {
+			layout = append(layout, &HomeWidget{Name: widget.Name, Visible: false})
+		}
+	}
+
+	return layout
+}
+
+func validateHomeLayout(layout []*HomeWidget) error {
+	seen := make(map[string]bool)
+	for _, widget := range layout {
+		if !slices.ContainsFunc(defaultHomeLayout, func(w *HomeWidget) bool { return w.Name == widget.Name }) {
+			return errors.New("unknown widget: " + widget.Name)
+		}
+		if seen[widget.Name] {
+			return errors.New("widget listed twice: " + widget.Name)
+		}
+		seen[widget.Name] = true
+	}
+	return nil
+}
+
+func saveHomeLayout(username string, layout []*HomeWidget) error {
+	homeLayoutsMutex.Lock()
+	defer homeLayoutsMutex.Unlock()
+
+	layouts := readHomeLayouts()
+	layouts[username] = layout
+
+	jsonData, err := json.MarshalIndent(layouts, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(homeLayoutsFile, jsonData, 0644)
+}
+
+func servHomeLayoutAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servHomeLayoutAPI()")
+
+	writeJSON(w, http.StatusOK, getHomeLayout(username))
+}
+
+// Widgets left out of the saved layout are shown hidden at the end.
+func servSaveHomeLayoutAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	layout := make([]*HomeWidget, 0)
+	if err := json.NewDecoder(r.Body).Decode(&layout); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	if err := validateHomeLayout(layout); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servSaveHomeLayoutAPI()", "username", username)
+
+	if err := saveHomeLayout(username, layout); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save home layout")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, getHomeLayout(username))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+)
+
+// Admins can view the site as another member to debug what that member sees.
+// Starting it issues a token, held in a cookie, that only works together with
+// the admin's own credentials and expires after impersonationTTL. While it is
+// active the admin is read only: anything other than GET or HEAD is refused,
+// so no passwords, content or settings can change under the member's name,
+// and pages skip the bookkeeping they'd normally do on a visit, such as
+// marking articles read.
+const (
+	impersonationCookie = "blazemarker_view_as"
+	impersonationTTL    = time.Hour
+)
+
+type impersonation struct {
+	Token    string
+	Admin    string
+	Username string
+	Expires  time.Time
+}
+
+type impersonationKey struct{}
+
+// Requests carry their own record of the session so each one is audited once,
+// however many times it looks up the user.
+type impersonatedRequest struct {
+	session *impersonation
+	audited sync.Once
+}
+
+var (
+	impersonations      = make(map[string]*impersonation)
+	impersonationsMutex sync.Mutex
+)
+
+func newImpersonation(admin string, username string) (*impersonation, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	session := &impersonation{Token: hex.EncodeToString(token), Admin: admin, Username: username, Expires: time.Now().Add(impersonationTTL)}
+
+	impersonationsMutex.Lock()
+	defer impersonationsMutex.Unlock()
+
+	for token, existing := range impersonations {
+		if existing.Admin == admin || time.Now().After(existing.Expires) {
+			delete(impersonations, token)
+		}
+	}
+	impersonations[session.Token] = session
+
+	return session, nil
+}
+
+func endImpersonation(token string) {
+	impersonationsMutex.Lock()
+	defer impersonationsMutex.Unlock()
+
+	delete(impersonations, token)
+}
+
+// The session applies when the request's basic auth user is the admin who
+// started it. basicAuth still checks the admin's password before switching.
+func requestImpersonation(r *http.Request) *impersonation {
+	cookie, err := r.Cookie(impersonationCookie)
+	if err != nil {
+		return nil
+	}
+	username, _, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+
+	impersonationsMutex.Lock()
+	defer impersonationsMutex.Unlock()
+
+	session, ok := impersonations[cookie.Value]
+	if !ok || session.Admin != username || time.Now().After(session.Expires) {
+		return nil
+	}
+
+	return session
+}
+
+func impersonationFrom(ctx context.Context) *impersonation {
+	if request, ok := ctx.Value(impersonationKey{}).(*impersonatedRequest); ok {
+		return request.session
+	}
+	return nil
+}
+
+// Whether the request is an admin viewing as someone else, in which case
+// nothing should be written on the member's behalf.
+func isImpersonated(r *http.Request) bool {
+	return impersonationFrom(r.Context()) != nil
+}
+
+// Returns who to treat an authenticated user as for this request, recording
+// every request made while viewing as someone else.
+func effectiveUser(r *http.Request, username string) string {
+	request, ok := r.Context().Value(impersonationKey{}).(*impersonatedRequest)
+	if !ok || request.session.Admin != username || !isAdmin(username) {
+		return username
+	}
+	session := request.session
+
+	request.audited.Do(func() {
+		logger.InfoContext(r.Context(), "Impersonated request", "admin", session.Admin, "username", session.Username, "r.URL.Path", r.URL.Path)
+		go audit_db.Record(audit_db.ActionImpersonatedRequest, session.Admin, clientIP(r), session.Username+" "+r.Method+" "+r.URL.Path)
+	})
+
+	return session.Username
+}
+
+func impersonationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := requestImpersonation(r)
+		if session == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		stopping := r.Method == http.MethodDelete && r.URL.Path == "/api/impersonation"
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !stopping {
+			logger.InfoContext(r.Context(), "Refused change while impersonating", "admin", session.Admin, "username", session.Username, "r.Method", r.Method, "r.URL.Path", r.URL.Path)
+			writeJSONError(w, http.StatusForbidden, "Read only while viewing as "+session.Username)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), impersonationKey{}, &impersonatedRequest{session: session})))
+	})
+}
+
+func servImpersonateAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	target := r.PathValue("name")
+
+	if exists, err := userExists(target); err != nil || !exists {
+		writeJSONError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if target == username || isDisabled(target) {
+		writeJSONError(w, http.StatusBadRequest, "Can't view as "+target)
+		return
+	}
+
+	session, err := newImpersonation(username, target)
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to start impersonation")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonationCookie,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	logger.InfoContext(r.Context(), "Impersonation started", "admin", username, "username", target)
+	audit_db.Record(audit_db.ActionImpersonationStarted, username, clientIP(r), target)
+
+	writeJSON(w, http.StatusOK, map[string]any{"username": target, "expires": session.Expires})
+}
+
+func servStopImpersonationAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: impersonationCookie, Value: "", Path: "/", MaxAge: -1})
+
+	session := impersonationFrom(r.Context())
+	if session == nil {
+		writeJSON(w, http.StatusOK, map[string]string{"message": "Not impersonating"})
+		return
+	}
+	endImpersonation(session.Token)
+
+	logger.InfoContext(r.Context(), "Impersonation ended", "admin", session.Admin, "username", session.Username)
+	audit_db.Record(audit_db.ActionImpersonationEnded, session.Admin, clientIP(r), session.Username)
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Stopped viewing as " + session.Username})
+}
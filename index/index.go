@@ -1,20 +1,26 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"html/template"
 	"log"
 	"log/slog"
 	"mime"
 	"net/http"
+	"os"
 	"os/user"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/jeffereydecker/blazemarker/activity_db"
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blaze_backup"
 	"github.com/jeffereydecker/blazemarker/blaze_log"
 	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/bookmark_db"
 	"github.com/jeffereydecker/blazemarker/gallery_db"
-	"github.com/tg123/go-htpasswd"
+	"github.com/jeffereydecker/blazemarker/poll_db"
 )
 
 // Aliases
@@ -25,40 +31,37 @@ type Album = gallery_db.Album
 var logger *slog.Logger = blaze_log.GetLogger()
 
 type Blog struct {
-	Title    string     `json:"title"`
-	Articles []*Article `json:"articles"`
+	Title           string           `json:"title"`
+	Articles        []*Article       `json:"articles"`
+	Widgets         []string         `json:"widgets,omitempty"`
+	ArticleOfTheDay *Article         `json:"article_of_the_day,omitempty"`
+	PhotoOfTheDay   *Photo           `json:"photo_of_the_day,omitempty"`
+	Activity        []*ActivityEvent `json:"activity,omitempty"`
+	NowArticles     []*Article       `json:"now_articles,omitempty"`
+	Tags            []*TagCount      `json:"tags,omitempty"`
+	Tag             string           `json:"tag,omitempty"`
+	Categories      []*Category      `json:"categories,omitempty"`
+	Filter          string           `json:"filter,omitempty"`
+	Unread          map[string]bool  `json:"-"`
 }
 
-type Gallery struct {
-	Title  string   `json:"title"`
-	Albums []*Album `json:"albums"`
+type ArticleEditor struct {
+	Title      string      `json:"title"`
+	Key        string      `json:"key,omitempty"`
+	Article    *Article    `json:"article"`
+	Categories []*Category `json:"categories"`
 }
 
-func servNow(w http.ResponseWriter, r *http.Request) {
-	// The root handler "/" matches every path that wasn't match by other
-	// matchers, so we have to further filter it here. Only accept actual root
-	// paths.
-
-	//if path := strings.Trim(r.URL.Path, "/index"); len(path) > 0 {
-	//	fmt.Println("/index NOT FOUND r.URL.Path" + r.URL.Path)
-	//
-	//	http.NotFound(w, r)
-	//	return
-	//}
-
-	logger.Debug("servNow()")
-
-	pageData := new(Blog)
-	pageData.Title = "Jefferey Decker"
-	pageData.Articles = blog_db.GetNowArticles()
-
-	t, _ := template.ParseFiles("../templates/base.html", "../templates/index.html")
-	err := t.Execute(w, pageData)
+type ArticlePage struct {
+	Title        string   `json:"title"`
+	Article      *Article `json:"article"`
+	CanonicalURL string   `json:"canonical_url"`
+}
 
-	if err != nil {
-		logger.Error(err.Error())
-		return
-	}
+type Gallery struct {
+	Title       string   `json:"title"`
+	Albums      []*Album `json:"albums"`
+	SmartAlbums []*Album `json:"smart_albums"`
 }
 
 func servIndex(w http.ResponseWriter, r *http.Request) {
@@ -73,17 +76,48 @@ func servIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.Debug("servIndex()")
+	logger.DebugContext(r.Context(), "servIndex()")
+
+	username := currentUser(r)
 
 	pageData := new(Blog)
 	pageData.Title = "Jefferey Decker"
 	pageData.Articles = blog_db.GetIndexArticles()
+	pageData.Widgets = []string{WidgetWelcome}
+
+	// Family content is only featured for signed in members, laid out as
+	// they chose
+	if len(username) > 0 {
+		pageData.Widgets = make([]string, 0)
+		for _, widget := range getHomeLayout(username) {
+			if !widget.Visible {
+				continue
+			}
+			pageData.Widgets = append(pageData.Widgets, widget.Name)
+
+			switch widget.Name {
+			case WidgetPhotoOfTheDay:
+				pageData.PhotoOfTheDay = gallery_db.GetPhotoOfTheDay(time.Now())
+			case WidgetArticleOfTheDay:
+				pageData.ArticleOfTheDay = blog_db.GetArticleOfTheDay(time.Now())
+			case WidgetActivity:
+				if page, ok := getActivity(username, "", homeActivitySize, nil); ok {
+					pageData.Activity = page.Events
+				}
+			case WidgetNow:
+				for _, page := range blog_db.GetNowPages() {
+					pageData.NowArticles = append(pageData.NowArticles, page.Article())
+				}
+				pageData.NowArticles = pageData.NowArticles[:min(homeNowSize, len(pageData.NowArticles))]
+			}
+		}
+	}
 
-	t, _ := template.ParseFiles("../templates/base.html", "../templates/index.html")
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/index.html")
 	err := t.Execute(w, pageData)
 
 	if err != nil {
-		logger.Error(err.Error())
+		logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 }
@@ -96,27 +130,46 @@ func basicAuth(w http.ResponseWriter, r *http.Request) (bool, string) {
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte(`{"message": "No basic auth present"}`))
 
-		logger.Error("No basic auth present")
+		logger.ErrorContext(r.Context(), "No basic auth present")
 		return ok, ""
 	}
 
-	myauth, err := htpasswd.New("../blaze_auth/.htpasswd", htpasswd.DefaultSystems, nil)
+	ip := clientIP(r)
+	if remaining := loginLockout(ip, username); remaining > 0 {
+		logger.InfoContext(r.Context(), "Blazemarker, basicAuth(), Locked out", "username", username, "ip", ip)
+		writeLockedOut(w, remaining)
+		return false, username
+	}
+
+	ok, err := matchCredentials(r, username, password)
 	if err != nil {
-		logger.Error(err.Error())
+		logger.ErrorContext(r.Context(), err.Error())
 		return false, ""
 	}
 
-	if ok = myauth.Match(username, password); !ok {
+	if !ok {
+		time.Sleep(recordLoginFailure(ip, username))
+
 		w.Header().Add("WWW-Authenticate", `Basic realm="Give username and password"`)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte(`{"message": "No basic auth present"}`))
 
-		logger.Info("Blazemarker, basicAuth(), Unauthorized", "username", username)
+		logger.InfoContext(r.Context(), "Blazemarker, basicAuth(), Unauthorized", "username", username)
+		audit_db.Record(audit_db.ActionLoginFailed, username, ip, r.URL.Path)
 		return ok, username
 	}
 
-	logger.Info("Blazemarker, basicAuth(), Authorized", "username", username, "password", password)
-	return true, username
+	clearLoginFailures(ip, username)
+
+	if isDisabled(username) {
+		logger.InfoContext(r.Context(), "Blazemarker, basicAuth(), Disabled", "username", username)
+		writeJSONError(w, http.StatusForbidden, "Account disabled")
+		return false, username
+	}
+
+	logger.InfoContext(r.Context(), "Blazemarker, basicAuth(), Authorized", "username", username)
+	recordLogin(username, r)
+	return true, effectiveUser(r, username)
 }
 
 //TODO:
@@ -133,46 +186,73 @@ func basicAuth(w http.ResponseWriter, r *http.Request) (bool, string) {
 //  Create an input to go direclty to page
 
 func servGallery(w http.ResponseWriter, r *http.Request) {
-	if ok, _ := basicAuth(w, r); !ok {
-		logger.Info("Failed baseAuth attempt")
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
 		return
 	}
 
 	pageData := new(Gallery)
 	pageData.Title = "Decker Photo Albums"
-	pageData.Albums = gallery_db.GetAllAlbums()
+	if gallery_db.IsGalleryIndexed() {
+		pageData.Albums = gallery_db.GetIndexedAlbums()
+	} else {
+		pageData.Albums = gallery_db.GetAllAlbums()
+	}
+	pageData.Albums = visibleAlbums(username, pageData.Albums)
+	pageData.SmartAlbums = gallery_db.GetAllSmartAlbums()
 
-	t, _ := template.ParseFiles("../templates/base.html", "../templates/gallery.html")
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/gallery.html")
 	err := t.Execute(w, pageData)
 
 	if err != nil {
-		logger.Error(err.Error())
+		logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 }
 
 func servAlbum(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
 
-	if ok, _ := basicAuth(w, r); !ok {
-		logger.Info("Failed baseAuth attempt")
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
 		return
 	}
 
 	pageData := new(Album)
-	pageData.Name = r.URL.Query().Get("name")
-	if len(pageData.Name) == 0 {
-		logger.Warn("HTTP Request Filter Not Available: name")
-		return
+	if smartName := r.URL.Query().Get("smart"); len(smartName) > 0 {
+		pageData.Name = smartName
+		pageData.SitePhotos, pageData.OriginalPhotos = gallery_db.GetSmartAlbumPhotos(pageData.Name)
+	} else {
+		pageData.Name = r.URL.Query().Get("name")
+		if len(pageData.Name) == 0 {
+			logger.WarnContext(r.Context(), "HTTP Request Filter Not Available: name")
+			return
+		}
+		if !canViewAlbum(username, pageData.Name) {
+			logger.InfoContext(r.Context(), "Album not published", "name", pageData.Name, "username", username)
+			http.NotFound(w, r)
+			return
+		}
+		if indexedAlbum := gallery_db.GetIndexedAlbum(pageData.Name); indexedAlbum != nil {
+			pageData = indexedAlbum
+			pageData.SitePhotos, pageData.OriginalPhotos = gallery_db.GetIndexedAlbumPhotos(pageData.Name)
+		} else {
+			pageData.SitePhotos, pageData.OriginalPhotos = gallery_db.GetAlbumPhotos(pageData.Name)
+			pageData.HasRawPairs = gallery_db.HasRawPairs(pageData.Name)
+		}
 	}
-	pageData.SitePhotos, pageData.OriginalPhotos = gallery_db.GetAlbumPhotos(pageData.Name)
 
-	logger.Debug("servAlbum()", "r.URL.Path", r.URL.Path, "pageData.Name", pageData.Name, "pageData.Path", pageData.Path)
+	logger.DebugContext(r.Context(), "servAlbum()", "r.URL.Path", r.URL.Path, "pageData.Name", pageData.Name, "pageData.Path", pageData.Path)
 
-	t, _ := template.ParseFiles("../templates/base.html", "../templates/album.html")
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/album.html")
 	err := t.Execute(w, pageData)
 
 	if err != nil {
-		logger.Error(err.Error())
+		logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 }
@@ -182,85 +262,321 @@ func servArticle(w http.ResponseWriter, r *http.Request) {
 	var ok bool
 
 	if ok, username = basicAuth(w, r); !ok {
-		logger.Info("Failed baseAuth attempt")
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
 		return
 	}
 	switch r.Method {
 	case http.MethodGet:
-		pageData := new(Article)
+		pageData := new(ArticleEditor)
 		pageData.Title = "New Article"
+		pageData.Categories = blog_db.GetCategories()
+		pageData.Article = &Article{Format: blog_db.FormatHTML}
+
+		// ?edit= loads an existing article, Markdown ones as their source
+		if key := r.URL.Query().Get("edit"); len(key) > 0 {
+			article := blog_db.GetArticle(key)
+			if article == nil {
+				http.NotFound(w, r)
+				return
+			}
+			if !canEditArticle(username, article) {
+				logger.InfoContext(r.Context(), "Article edit not allowed", "key", key, "username", username)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			pageData.Title = "Edit Article"
+			pageData.Key = key
+			pageData.Article = article
+		}
 
-		logger.Debug("servArticle()[GET]")
+		logger.DebugContext(r.Context(), "servArticle()[GET]", "pageData.Key", pageData.Key)
 
-		t, _ := template.ParseFiles("../templates/base.html", "../templates/newarticle.html")
+		t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/newarticle.html")
 		err := t.Execute(w, pageData)
 
 		if err != nil {
-			logger.Error(err.Error())
+			logger.ErrorContext(r.Context(), err.Error())
 			return
 		}
 	case http.MethodPost:
-		logger.Debug("servArticle()[POST]")
+		logger.DebugContext(r.Context(), "servArticle()[POST]")
 
 		if err := r.ParseForm(); err != nil {
-			logger.Error("Form parsing error")
+			logger.ErrorContext(r.Context(), "Form parsing error")
 			http.Error(w, "Form parsing error", http.StatusBadRequest)
 			return
 		}
+
+		key := r.FormValue("key")
 		article := new(Article)
-		article.Title = r.FormValue("title")
-		article.Content = template.HTML(r.FormValue("content"))
 		article.Date = time.Now().Format("2006-01-02")
 		article.Author = username
 
-		if ok := blog_db.SaveArticle(article); !ok {
-			logger.Error("Failed to save article", "article.Title", article.Title, "article.Author", article.Title)
+		if len(key) > 0 {
+			if article = blog_db.GetArticle(key); article == nil {
+				http.NotFound(w, r)
+				return
+			}
+			if !canEditArticle(username, article) {
+				logger.InfoContext(r.Context(), "Article edit not allowed", "key", key, "username", username)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		format := r.FormValue("format")
+		body := r.FormValue("content")
+		if format == blog_db.FormatMarkdown {
+			body = r.FormValue("source")
+		}
+
+		article.Title = r.FormValue("title")
+		if err := article.SetBody(format, body); err != nil {
+			logger.ErrorContext(r.Context(), err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		article.Tags = blog_db.ParseTags(r.FormValue("tags"))
+		article.Category = ""
+		if category := blog_db.GetCategory(r.FormValue("category")); category != nil {
+			article.Category = category.Slug
+		}
+		// Only the original author picks the co-authors and who can see it
+		if article.Author == username {
+			article.CoAuthors = parseMembers(r.FormValue("co_authors"), article.Author)
+			article.Private = r.FormValue("private") == "on"
+			article.SharedWith = nil
+			if article.Private {
+				article.SharedWith = parseMembers(r.FormValue("shared_with"), article.Author)
+			}
+		}
+
+		if storageCritical() {
+			logger.ErrorContext(r.Context(), "Article not saved, storage critically low", "article.Title", article.Title, "article.Author", article.Author)
+			http.Error(w, "Storage is critically low, please try again later", http.StatusInsufficientStorage)
 			return
 		}
 
+		if len(key) > 0 {
+			if err := blog_db.UpdateArticle(key, article); err != nil {
+				logger.ErrorContext(r.Context(), "Failed to update article", "key", key, "error", err.Error())
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			audit_db.Record(audit_db.ActionArticleEdited, username, clientIP(r), article.Title)
+			if key != article.Key() {
+				articleRenamed(r, key, article.Key())
+			}
+		} else {
+			if err := blog_db.CreateArticle(article); err != nil {
+				logger.ErrorContext(r.Context(), "Failed to save article", "article.Title", article.Title, "article.Author", article.Author, "error", err.Error())
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			audit_db.Record(audit_db.ActionArticleCreated, username, clientIP(r), article.Title)
+			activity_db.Record(ActivityArticle, username, article.Title, article.Key(), 0)
+		}
+		blog_db.MarkArticlesRead(username, []*Article{article})
+		go blog_db.FetchArticleLinkPreviews(article)
+
 		http.Redirect(w, r, "/articles", http.StatusFound)
 	default:
-		logger.Info("Method not allowed", "r.Method", r.Method)
+		logger.InfoContext(r.Context(), "Method not allowed", "r.Method", r.Method)
 	}
 
 }
 
+// Points everything recorded against an article's old key at its new one.
+func articleRenamed(r *http.Request, from string, to string) {
+	if err := activity_db.RenameRef(ActivityArticle, from, to); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+	}
+	if err := poll_db.RenameArticle(from, to); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+	}
+	if err := bookmark_db.RenameRef(bookmark_db.KindArticle, from, to); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// Keeps the members from a comma separated list who exist, other than the
+// author.
+func parseMembers(value string, author string) []string {
+	usernames, err := activeMembers()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil
+	}
+
+	members := make([]string, 0)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != author && slices.Contains(usernames, name) && !slices.Contains(members, name) {
+			members = append(members, name)
+		}
+	}
+
+	return members
+}
+
+// Returns which of the articles username hasn't opened yet.
+func unreadArticles(username string, articles []*Article) map[string]bool {
+	read := blog_db.GetReadArticles(username)
+
+	unread := make(map[string]bool)
+	for _, article := range articles {
+		if !read[article.Key()] {
+			unread[article.Key()] = true
+		}
+	}
+
+	return unread
+}
+
+// Articles are read once they are opened. Nothing is marked while an admin is
+// viewing as the member.
+func markArticleRead(r *http.Request, username string, article *Article) {
+	if !isImpersonated(r) {
+		blog_db.MarkArticlesRead(username, []*Article{article})
+	}
+}
+
 func servArticles(w http.ResponseWriter, r *http.Request) {
-	if ok, _ := basicAuth(w, r); !ok {
-		logger.Info("Failed baseAuth attempt")
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
 		return
 	}
 
 	pageData := new(Blog)
 	pageData.Title = "Decker News"
 
-	logger.Debug("servArticles()")
+	pageData.Tag = blog_db.NormalizeTag(r.URL.Query().Get("tag"))
+	pageData.Filter = r.URL.Query().Get("filter")
+
+	logger.DebugContext(r.Context(), "servArticles()", "pageData.Tag", pageData.Tag, "pageData.Filter", pageData.Filter)
 
-	pageData.Articles = blog_db.GetAllArticles()
+	if len(pageData.Tag) > 0 {
+		pageData.Articles = blog_db.GetArticlesByTag(pageData.Tag)
+	} else {
+		pageData.Articles = blog_db.GetAllArticles()
+	}
+	pageData.Articles = visibleArticles(username, pageData.Articles)
+	if pageData.Filter == "private" {
+		pageData.Articles = slices.DeleteFunc(pageData.Articles, func(article *Article) bool { return !article.Private })
+	}
+	pageData.Tags = blog_db.GetTags()
+	pageData.Categories = blog_db.GetCategories()
 	blog_db.SortByDate(pageData.Articles)
 
-	t, _ := template.ParseFiles("../templates/base.html", "../templates/articles.html")
+	pageData.Unread = unreadArticles(username, pageData.Articles)
+	if pageData.Filter == "unread" {
+		pageData.Articles = slices.DeleteFunc(pageData.Articles, func(article *Article) bool { return !pageData.Unread[article.Key()] })
+	}
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/articles.html")
 	err := t.Execute(w, pageData)
 
 	if err != nil {
-		logger.Error(err.Error())
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+// Shows one article at its permalink.
+func servArticlePage(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servArticlePage()", "r.URL.Path", r.URL.Path)
+
+	article := blog_db.GetArticleByPermalink(r.PathValue("year"), r.PathValue("month"), r.PathValue("slug"), username)
+	if article == nil || !article.CanView(username) {
+		http.NotFound(w, r)
+		return
+	}
+
+	pageData := new(ArticlePage)
+	pageData.Title = article.Title
+	pageData.Article = article
+	pageData.CanonicalURL = siteURL() + article.Permalink()
+	markArticleRead(r, username, article)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/article.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 }
 
 func main() {
+	restore := flag.String("restore", "", "restore the database from the named backup and exit")
+	doctor := flag.Bool("doctor", false, "check the installation, print what needs fixing and exit")
+	reprocessPhotos := flag.Bool("reprocess-photos", false, "recreate every album's site photos upright from the originals and exit")
+	flag.Parse()
 
 	currentUser, err := user.Current()
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
 
+	if len(*restore) > 0 {
+		if err := blaze_backup.StageRestore(*restore); err != nil {
+			log.Fatalf(err.Error())
+		}
+		if err := blaze_backup.ApplyPendingRestore(); err != nil {
+			log.Fatalf(err.Error())
+		}
+		fmt.Println("Restored database from", *restore)
+		return
+	}
+
+	if *doctor {
+		if !printDoctor(runDoctor()) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *reprocessPhotos {
+		count, err := gallery_db.ReprocessSitePhotos()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		fmt.Println("Reprocessed site photos in", count, "albums")
+		return
+	}
+
+	// Swap in a restore staged from the admin API before the database is opened
+	if err := blaze_backup.ApplyPendingRestore(); err != nil {
+		logger.Error(err.Error())
+	}
+
+	if err := migrateDataDir(); err != nil {
+		logger.Error(err.Error())
+		log.Fatalf(err.Error())
+	}
+
+	logDoctor(runDoctor())
+
 	// TODO: Test general access to file system
 	// TODO: Look for ways to lock down to specific directories
-	http.Handle("/photos/galleries/", http.StripPrefix("/photos/galleries/", http.FileServer(http.Dir("../photos/galleries"))))
+	for _, root := range gallery_db.GetGalleryRoots() {
+		http.Handle(root.URLPrefix(), http.StripPrefix(root.URLPrefix(), servGalleryFiles(root)))
+	}
 	http.Handle("/bootstrap-5.3.0-dist/", http.StripPrefix("/bootstrap-5.3.0-dist/", http.FileServer(http.Dir("../bootstrap-5.3.0-dist"))))
 	http.Handle("/tinymce/", http.StripPrefix("/tinymce/", http.FileServer(http.Dir("../tinymce"))))
 	http.Handle("/css/", http.StripPrefix("/css/", http.FileServer(http.Dir("../css"))))
+	http.Handle("/media/", http.StripPrefix("/media/", http.FileServer(http.Dir("../media"))))
 
 	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "../static/favicon.ico")
@@ -287,27 +603,188 @@ func main() {
 	})
 
 	// TODO: Update /index to show photos, videos and blog and maybe an random photo, video or blog?  Or an about page
+	http.HandleFunc("GET /healthz", servHealthz)
+	http.HandleFunc("GET /readyz", servReadyz)
+	http.HandleFunc("GET /status", servStatus)
+	http.HandleFunc("GET /api/banners", servBannersAPI)
+	http.HandleFunc("POST /api/banners/{id}/dismiss", servDismissBannerAPI)
+
 	http.HandleFunc("/index", servIndex)
 	http.HandleFunc("/", servIndex)
 	http.HandleFunc("/now", servNow)
 	http.HandleFunc("/articles", servArticles)
 	http.HandleFunc("/article", servArticle)
+	http.HandleFunc("GET /article/{year}/{month}/{slug}", servArticlePage)
+	http.HandleFunc("GET /sitemap.xml", servSitemap)
+	http.HandleFunc("GET /articles/trash", servTrash)
+	http.HandleFunc("DELETE /api/articles/{key}", servDeleteArticleAPI)
+	http.HandleFunc("POST /api/trash/{key}/restore", servRestoreArticleAPI)
+	http.HandleFunc("DELETE /api/trash/{key}", servPurgeArticleAPI)
+	http.HandleFunc("POST /api/upload-article-image", servUploadArticleImage)
+	http.HandleFunc("GET /api/media", servMediaAPI)
+	http.HandleFunc("GET /api/link_preview", servLinkPreviewAPI)
+	http.HandleFunc("GET /api/of_the_day", servOfTheDayAPI)
+	http.HandleFunc("GET /api/home_layout", servHomeLayoutAPI)
+	http.HandleFunc("PUT /api/home_layout", servSaveHomeLayoutAPI)
+	http.HandleFunc("GET /api/v1/activity", servActivityAPI)
+	http.HandleFunc("GET /api/activity", servActivityAPI)
+	http.HandleFunc("GET /activity", servActivity)
+	http.HandleFunc("GET /category/{slug}", servCategory)
+	http.HandleFunc("GET /challenges", servChallenges)
+	http.HandleFunc("GET /trips", servTrips)
+	http.HandleFunc("GET /trip", servTrip)
+	http.HandleFunc("GET /api/trips", servTripsAPI)
+	http.HandleFunc("POST /api/trips", servCreateTripAPI)
+	http.HandleFunc("GET /api/trips/{id}", servTripAPI)
+	http.HandleFunc("PUT /api/trips/{id}", servUpdateTripAPI)
+	http.HandleFunc("DELETE /api/trips/{id}", servDeleteTripAPI)
+	http.HandleFunc("POST /api/trips/{id}/recap", servTripRecapAPI)
+	http.HandleFunc("GET /api/challenges", servChallengesAPI)
+	http.HandleFunc("POST /api/challenges", servCreateChallengeAPI)
+	http.HandleFunc("PUT /api/challenges/{id}/membership", servJoinChallengeAPI)
+	http.HandleFunc("DELETE /api/challenges/{id}/membership", servLeaveChallengeAPI)
+	http.HandleFunc("POST /api/challenges/{id}/entries", servLogChallengeAPI)
+	http.HandleFunc("GET /api/challenges/{id}/leaderboard", servChallengeLeaderboardAPI)
+	http.HandleFunc("GET /lists", servLists)
+	http.HandleFunc("GET /api/lists", servListsAPI)
+	http.HandleFunc("POST /api/lists", servCreateListAPI)
+	http.HandleFunc("GET /api/lists/{id}", servListAPI)
+	http.HandleFunc("DELETE /api/lists/{id}", servDeleteListAPI)
+	http.HandleFunc("POST /api/lists/{id}/items", servAddListItemAPI)
+	http.HandleFunc("PUT /api/lists/{id}/items/{item}", servUpdateListItemAPI)
+	http.HandleFunc("DELETE /api/lists/{id}/items/{item}", servDeleteListItemAPI)
+	http.HandleFunc("POST /api/lists/{id}/clear", servClearListAPI)
+	http.HandleFunc("GET /recipes", servRecipes)
+	http.HandleFunc("GET /recipe", servRecipe)
+	http.HandleFunc("GET /api/recipes", servRecipesAPI)
+	http.HandleFunc("POST /api/recipes", servCreateRecipeAPI)
+	http.HandleFunc("GET /api/recipes/{id}", servRecipeAPI)
+	http.HandleFunc("PUT /api/recipes/{id}", servUpdateRecipeAPI)
+	http.HandleFunc("DELETE /api/recipes/{id}", servDeleteRecipeAPI)
+	http.HandleFunc("POST /api/recipes/{id}/shopping", servRecipeShoppingAPI)
+	http.HandleFunc("GET /inventory", servInventory)
+	http.HandleFunc("GET /inventory/item", servInventoryItem)
+	http.HandleFunc("GET /api/inventory", servInventoryAPI)
+	http.HandleFunc("POST /api/inventory", servCreateInventoryItemAPI)
+	http.HandleFunc("GET /api/inventory/expiring", servExpiringInventoryAPI)
+	http.HandleFunc("GET /api/inventory/{id}", servInventoryItemAPI)
+	http.HandleFunc("PUT /api/inventory/{id}", servUpdateInventoryItemAPI)
+	http.HandleFunc("DELETE /api/inventory/{id}", servDeleteInventoryItemAPI)
+	http.HandleFunc("GET /polls", servPolls)
+	http.HandleFunc("GET /api/polls", servPollsAPI)
+	http.HandleFunc("POST /api/polls", servCreatePollAPI)
+	http.HandleFunc("GET /api/polls/{id}", servPollAPI)
+	http.HandleFunc("DELETE /api/polls/{id}", servDeletePollAPI)
+	http.HandleFunc("PUT /api/polls/{id}/vote", servVotePollAPI)
+	http.HandleFunc("POST /api/polls/{id}/close", servClosePollAPI)
+	http.HandleFunc("GET /saved", servSaved)
+	http.HandleFunc("GET /api/bookmarks", servBookmarksAPI)
+	http.HandleFunc("PUT /api/bookmarks/articles/{key}", servAddBookmarkAPI)
+	http.HandleFunc("DELETE /api/bookmarks/articles/{key}", servRemoveBookmarkAPI)
+	http.HandleFunc("PUT /api/bookmarks/photos/{album}/{photo}", servAddBookmarkAPI)
+	http.HandleFunc("DELETE /api/bookmarks/photos/{album}/{photo}", servRemoveBookmarkAPI)
+	http.HandleFunc("GET /map", servMap)
+	http.HandleFunc("GET /api/checkins", servCheckInsAPI)
+	http.HandleFunc("POST /api/checkins", servAddCheckInAPI)
+	http.HandleFunc("DELETE /api/checkins/{id}", servDeleteCheckInAPI)
+	http.HandleFunc("GET /api/checkins/settings", servCheckInSettingsAPI)
+	http.HandleFunc("PUT /api/checkins/settings", servSaveCheckInSettingsAPI)
+	http.HandleFunc("GET /api/tags", servTagsAPI)
+	http.HandleFunc("GET /api/categories", servCategoriesAPI)
+	http.HandleFunc("GET /api/now", servNowPagesAPI)
+	http.HandleFunc("PUT /api/now", servSaveNowPageAPI)
 
 	// TODO: upate gallery to have paging, update color scheme
 	http.HandleFunc("/gallery", servGallery)
 	// TODO: code /album functionality. For example, carousel?
 	http.HandleFunc("/album", servAlbum)
 
+	http.HandleFunc("GET /api/albums", servAlbumsAPI)
+	http.HandleFunc("GET /api/smart_albums", servSmartAlbumsAPI)
+	http.HandleFunc("POST /api/smart_albums", servSaveSmartAlbumAPI)
+	http.HandleFunc("DELETE /api/smart_albums/{name}", servDeleteSmartAlbumAPI)
+	http.HandleFunc("GET /api/smart_albums/{name}", servSmartAlbumPhotosAPI)
+	http.HandleFunc("GET /api/album/{name}", servAlbumAPI)
+	http.HandleFunc("POST /api/album/{name}/cover", servAlbumCoverAPI)
+	http.HandleFunc("GET /api/album/{name}/download", servAlbumDownload)
+	http.HandleFunc("POST /api/album/{name}/publishing", servAlbumPublishingAPI)
+	http.HandleFunc("GET /api/photo/{album}/{photo}/versions", servPhotoVersionsAPI)
+	http.HandleFunc("POST /api/photo/{album}/{photo}/edit", servEditPhotoAPI)
+	http.HandleFunc("PUT /api/photo/{album}/{photo}", servReplacePhotoAPI)
+	http.HandleFunc("POST /api/photo/{album}/{photo}/versions/{version}/revert", servRevertPhotoAPI)
+	http.HandleFunc("POST /api/photos/bulk", servBulkEditAPI)
+	http.HandleFunc("GET /api/photos/bulk/{id}", servBulkEditJobAPI)
+	http.HandleFunc("POST /api/photos/bulk/{id}/undo", servUndoBulkEditAPI)
+
+	http.HandleFunc("/admin", servAdmin)
+	http.HandleFunc("/admin/audit", servAudit)
+	http.HandleFunc("GET /api/admin/invites", servInvitesAPI)
+	http.HandleFunc("POST /api/admin/invites", servCreateInviteAPI)
+	http.HandleFunc("/register", servRegister)
+	http.HandleFunc("/guestbook", servGuestbook)
+	http.HandleFunc("GET /api/admin/guestbook", servGuestbookQueueAPI)
+	http.HandleFunc("POST /api/admin/guestbook/{id}/approve", servApproveGuestbookAPI)
+	http.HandleFunc("DELETE /api/admin/guestbook/{id}", servDeleteGuestbookAPI)
+	http.HandleFunc("GET /api/admin/users", servUsersAPI)
+	http.HandleFunc("POST /api/admin/users/{name}/impersonate", servImpersonateAPI)
+	http.HandleFunc("DELETE /api/impersonation", servStopImpersonationAPI)
+	http.HandleFunc("POST /api/admin/users/{name}/disable", servDisableUserAPI)
+	http.HandleFunc("POST /api/admin/users/{name}/enable", servEnableUserAPI)
+	http.HandleFunc("DELETE /api/admin/users/{name}", servDeleteUserAPI)
+	http.HandleFunc("GET /api/admin/backups", servBackupsAPI)
+	http.HandleFunc("POST /api/admin/backups", servCreateBackupAPI)
+	http.HandleFunc("POST /api/admin/backups/{name}/restore", servRestoreBackupAPI)
+	http.HandleFunc("POST /api/admin/categories", servAddCategoryAPI)
+	http.HandleFunc("DELETE /api/admin/categories/{slug}", servDeleteCategoryAPI)
+	http.HandleFunc("PUT /api/admin/tags/{name}", servRenameTagAPI)
+	http.HandleFunc("DELETE /api/admin/tags/{name}", servDeleteTagAPI)
+	http.HandleFunc("POST /api/admin/media/cleanup", servCleanupMediaAPI)
+	http.HandleFunc("GET /api/admin/storage", servStorageAPI)
+	http.HandleFunc("GET /api/admin/logs", servLogsAPI)
+	http.HandleFunc("GET /api/admin/log_level", servLogLevelAPI)
+	http.HandleFunc("PUT /api/admin/log_level", servSetLogLevelAPI)
+	http.HandleFunc("GET /api/admin/gallery/index", servGalleryIndexAPI)
+	http.HandleFunc("POST /api/admin/gallery/index", servSyncGalleryIndexAPI)
+	http.HandleFunc("GET /api/admin/banners", servAdminBannersAPI)
+	http.HandleFunc("POST /api/admin/banners", servAddBannerAPI)
+	http.HandleFunc("DELETE /api/admin/banners/{id}", servDeleteBannerAPI)
+	http.HandleFunc("GET /api/admin/maintenance", servMaintenanceAPI)
+	http.HandleFunc("POST /api/admin/maintenance", servAddMaintenanceAPI)
+	http.HandleFunc("DELETE /api/admin/maintenance/{id}", servDeleteMaintenanceAPI)
+	http.HandleFunc("GET /api/admin/holds", servHoldsAPI)
+	http.HandleFunc("PUT /api/admin/holds/{album}", servPlaceHoldAPI)
+	http.HandleFunc("DELETE /api/admin/holds/{album}", servLiftHoldAPI)
+	http.HandleFunc("PUT /api/admin/holds/articles/{key}", servPlaceArticleHoldAPI)
+	http.HandleFunc("DELETE /api/admin/holds/articles/{key}", servLiftArticleHoldAPI)
+	http.HandleFunc("GET /api/admin/integrity", servIntegrityAPI)
+	http.HandleFunc("POST /api/admin/integrity", servCheckIntegrityAPI)
+	http.HandleFunc("GET /api/admin/duplicates", servDuplicatePhotosAPI)
+	http.HandleFunc("DELETE /api/admin/duplicates/{hash}", servRemoveDuplicatePhotosAPI)
+
 	mime.AddExtensionType(".css", "text/css")
 	mime.AddExtensionType(".js", "application/javascript")
 	mime.AddExtensionType(".jpeg", "image/jpeg")
 	mime.AddExtensionType(".jpg", "image/jpeg")
 	mime.AddExtensionType(".gif", "image/gif")
 	mime.AddExtensionType(".png", "image/png")
+	mime.AddExtensionType(".webp", "image/webp")
+	mime.AddExtensionType(".heic", "image/heic")
+	mime.AddExtensionType(".heif", "image/heif")
 	mime.AddExtensionType(".svg", "image/svg+xml")
 	mime.AddExtensionType(".svgz", "image/svg+xml")
 
+	startStorageMonitor(5 * time.Minute)
+	startRateLimitCleanup(10 * time.Minute)
+	backfillActivity()
+	gallery_db.OnAlbumChange(recordAlbumChange)
+	gallery_db.StartGalleryIndexer(time.Hour)
+	blaze_backup.StartBackups(24*time.Hour, 14)
+	gallery_db.StartIntegrityChecker(24 * time.Hour)
+	blog_db.OnArticlePurged(articlePurged)
+	blog_db.StartTrashPurger(24 * time.Hour)
+	blog_db.StartMediaCleanup(24 * time.Hour)
+
 	logger.Info("Blazemarker server starting", "Name", currentUser.Name, "Id", currentUser.Uid, "Port", "3000")
-	http.ListenAndServe(":3000", nil)
+	http.ListenAndServe(":3000", requestID(rateLimit(impersonationMiddleware(http.DefaultServeMux))))
 
 }
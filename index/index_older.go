@@ -1,3 +1,5 @@
+//go:build ignore
+
 package main
 
 func createSitePhoto_ch(imageSourcePath string, imageName string, imageDestPath string, imageDestDir os.FileInfo, photoType string, photoSize string, wg *sync.WaitGroup) (string, os.FileInfo) {
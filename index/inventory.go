@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/inventory_db"
+)
+
+// Warranties running out within this many days are listed at the top of the
+// inventory page as a reminder.
+const warrantyReminderDays = 30
+
+type InventoryItem = inventory_db.Item
+
+type InventoryPage struct {
+	Title    string           `json:"title"`
+	Query    string           `json:"query"`
+	Items    []*InventoryItem `json:"items"`
+	Expiring []*InventoryItem `json:"expiring"`
+}
+
+type InventoryItemPage struct {
+	Title   string         `json:"title"`
+	Item    *InventoryItem `json:"item"`
+	CanEdit bool           `json:"can_edit"`
+}
+
+func inventoryItemFromPath(w http.ResponseWriter, r *http.Request) *InventoryItem {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Item not found")
+		return nil
+	}
+
+	item := inventory_db.GetItem(uint(id))
+	if item == nil {
+		writeJSONError(w, http.StatusNotFound, "Item not found")
+		return nil
+	}
+
+	return item
+}
+
+func decodeInventoryItem(w http.ResponseWriter, r *http.Request) *InventoryItem {
+	item := new(InventoryItem)
+	if err := json.NewDecoder(r.Body).Decode(item); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return nil
+	}
+
+	return item
+}
+
+func servInventory(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	pageData := new(InventoryPage)
+	pageData.Title = "Inventory"
+	pageData.Query = r.URL.Query().Get("q")
+	pageData.Items = inventory_db.SearchItems(pageData.Query)
+	pageData.Expiring = inventory_db.GetExpiringItems(time.Now(), warrantyReminderDays)
+
+	logger.DebugContext(r.Context(), "servInventory()", "pageData.Query", pageData.Query)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/inventory.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servInventoryItem(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servInventoryItem()", "id", id)
+
+	pageData := new(InventoryItemPage)
+	if pageData.Item = inventory_db.GetItem(uint(id)); pageData.Item == nil {
+		http.NotFound(w, r)
+		return
+	}
+	pageData.Title = pageData.Item.Name
+	pageData.CanEdit = canEditInventoryItem(username, pageData.Item)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/inventory_item.html")
+	err = t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servInventoryAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servInventoryAPI()")
+
+	writeJSON(w, http.StatusOK, inventory_db.SearchItems(r.URL.Query().Get("q")))
+}
+
+// Lists the items whose warranty runs out within ?days=, warrantyReminderDays
+// by default.
+func servExpiringInventoryAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	days := warrantyReminderDays
+	if value := r.URL.Query().Get("days"); len(value) > 0 {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid days")
+			return
+		}
+		days = parsed
+	}
+
+	logger.DebugContext(r.Context(), "servExpiringInventoryAPI()", "days", days)
+
+	writeJSON(w, http.StatusOK, inventory_db.GetExpiringItems(time.Now(), days))
+}
+
+func servInventoryItemAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	if item := inventoryItemFromPath(w, r); item != nil {
+		writeJSON(w, http.StatusOK, item)
+	}
+}
+
+func servCreateInventoryItemAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	item := decodeInventoryItem(w, r)
+	if item == nil {
+		return
+	}
+	item.Owner = username
+
+	if err := inventory_db.CreateItem(item); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Inventory item created", "item.ID", item.ID, "item.Name", item.Name, "username", username)
+
+	writeJSON(w, http.StatusCreated, item)
+}
+
+func servUpdateInventoryItemAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	item := inventoryItemFromPath(w, r)
+	if item == nil {
+		return
+	}
+	if !canEditInventoryItem(username, item) {
+		logger.InfoContext(r.Context(), "Inventory item edit not allowed", "item.ID", item.ID, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only the item's owner can change it")
+		return
+	}
+
+	update := decodeInventoryItem(w, r)
+	if update == nil {
+		return
+	}
+	item.Name = update.Name
+	item.Location = update.Location
+	item.PurchaseDate = update.PurchaseDate
+	item.WarrantyExpires = update.WarrantyExpires
+	item.Photo = update.Photo
+	item.Documents = update.Documents
+	item.Notes = update.Notes
+
+	if err := inventory_db.UpdateItem(item); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Inventory item updated", "item.ID", item.ID, "item.Name", item.Name, "username", username)
+
+	writeJSON(w, http.StatusOK, item)
+}
+
+func servDeleteInventoryItemAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	item := inventoryItemFromPath(w, r)
+	if item == nil {
+		return
+	}
+	if !canEditInventoryItem(username, item) {
+		logger.InfoContext(r.Context(), "Inventory item delete not allowed", "item.ID", item.ID, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only the item's owner can delete it")
+		return
+	}
+
+	if err := inventory_db.DeleteItem(item.ID); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to delete item")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Inventory item deleted", "item.ID", item.ID, "item.Name", item.Name, "username", username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/list_db"
+)
+
+type List = list_db.List
+
+type ListView struct {
+	*List
+	Items []*list_db.Item `json:"items"`
+}
+
+type ListsPage struct {
+	Title   string          `json:"title"`
+	Lists   []*ListView     `json:"lists"`
+	Due     []*list_db.Item `json:"due"`
+	Members []string        `json:"members"`
+}
+
+func getListView(list *List) *ListView {
+	return &ListView{List: list, Items: list_db.GetItems(list.ID)}
+}
+
+// Returns the list from the path if username can use it.
+func listFromPath(w http.ResponseWriter, r *http.Request, username string) *List {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "List not found")
+		return nil
+	}
+
+	list := list_db.GetList(uint(id))
+	if list == nil || !list.CanAccess(username) {
+		writeJSONError(w, http.StatusNotFound, "List not found")
+		return nil
+	}
+
+	return list
+}
+
+func itemFromPath(w http.ResponseWriter, r *http.Request, list *List) *list_db.Item {
+	id, err := strconv.ParseUint(r.PathValue("item"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Item not found")
+		return nil
+	}
+
+	item := list_db.GetItem(list.ID, uint(id))
+	if item == nil {
+		writeJSONError(w, http.StatusNotFound, "Item not found")
+		return nil
+	}
+
+	return item
+}
+
+func isMemberName(name string) bool {
+	usernames, err := activeMembers()
+	if err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+	return slices.Contains(usernames, name)
+}
+
+func servLists(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servLists()")
+
+	pageData := new(ListsPage)
+	pageData.Title = "Lists"
+	pageData.Due = list_db.GetDueItems(username, time.Now())
+	pageData.Lists = make([]*ListView, 0)
+	for _, list := range list_db.GetLists(username) {
+		pageData.Lists = append(pageData.Lists, getListView(list))
+	}
+
+	members, err := activeMembers()
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+	}
+	pageData.Members = members
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/lists.html")
+	err = t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servListsAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servListsAPI()")
+
+	writeJSON(w, http.StatusOK, list_db.GetLists(username))
+}
+
+func servCreateListAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	list := new(List)
+	if err := json.NewDecoder(r.Body).Decode(list); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	list.CreatedBy = username
+
+	if err := list_db.CreateList(list); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "List created", "list.ID", list.ID, "list.Name", list.Name, "username", username)
+
+	writeJSON(w, http.StatusCreated, getListView(list))
+}
+
+func servListAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	list := listFromPath(w, r, username)
+	if list == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, getListView(list))
+}
+
+// Only the list's creator can delete it.
+func servDeleteListAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	list := listFromPath(w, r, username)
+	if list == nil {
+		return
+	}
+	if list.CreatedBy != username {
+		writeJSONError(w, http.StatusForbidden, "Only the list's creator can delete it")
+		return
+	}
+
+	if err := list_db.DeleteList(list.ID); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to delete list")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "List deleted", "list.ID", list.ID, "list.Name", list.Name, "username", username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func servAddListItemAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	list := listFromPath(w, r, username)
+	if list == nil {
+		return
+	}
+
+	item := new(list_db.Item)
+	if err := json.NewDecoder(r.Body).Decode(item); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if len(item.Assignee) > 0 && !isMemberName(item.Assignee) {
+		writeJSONError(w, http.StatusBadRequest, "Unknown assignee: "+item.Assignee)
+		return
+	}
+	item.AddedBy = username
+
+	if err := list_db.AddItem(list.ID, item); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, item)
+}
+
+// Changes the fields present in the body, e.g. {"checked": true}.
+func servUpdateListItemAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	list := listFromPath(w, r, username)
+	if list == nil {
+		return
+	}
+	item := itemFromPath(w, r, list)
+	if item == nil {
+		return
+	}
+
+	update := *item
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if len(update.Assignee) > 0 && update.Assignee != item.Assignee && !isMemberName(update.Assignee) {
+		writeJSONError(w, http.StatusBadRequest, "Unknown assignee: "+update.Assignee)
+		return
+	}
+
+	item.Text = update.Text
+	item.Assignee = update.Assignee
+	item.Due = update.Due
+	if item.Checked != update.Checked {
+		item.Checked = update.Checked
+		item.CheckedAt = nil
+	}
+
+	if err := list_db.UpdateItem(item, username); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, item)
+}
+
+func servDeleteListItemAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	list := listFromPath(w, r, username)
+	if list == nil {
+		return
+	}
+	item := itemFromPath(w, r, list)
+	if item == nil {
+		return
+	}
+
+	if err := list_db.DeleteItem(list.ID, item.ID); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to delete item")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func servClearListAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	list := listFromPath(w, r, username)
+	if list == nil {
+		return
+	}
+
+	count, err := list_db.ClearChecked(list.ID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to clear list")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int64{"removed": count})
+}
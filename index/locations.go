@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/location_db"
+)
+
+type CheckIn = location_db.CheckIn
+
+// The map shows check-ins from the last mapDays days unless ?days= says
+// otherwise. Other members' check-ins are limited to
+// location_db.CheckInVisibility either way.
+const mapDays = 7
+
+type MapPage struct {
+	Title    string                `json:"title"`
+	Days     int                   `json:"days"`
+	Settings *location_db.Settings `json:"settings"`
+	CheckIns []*CheckIn            `json:"check_ins"`
+}
+
+func checkInsSince(r *http.Request) (int, time.Time) {
+	days := mapDays
+	if value, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && value > 0 && value <= 366 {
+		days = value
+	}
+	return days, time.Now().AddDate(0, 0, -days)
+}
+
+func servMap(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servMap()")
+
+	pageData := new(MapPage)
+	pageData.Title = "Family Map"
+	pageData.Settings = location_db.GetSettings(username)
+
+	var since time.Time
+	pageData.Days, since = checkInsSince(r)
+	pageData.CheckIns = location_db.GetVisibleCheckIns(username, since)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/map.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servCheckInsAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servCheckInsAPI()")
+
+	_, since := checkInsSince(r)
+	writeJSON(w, http.StatusOK, location_db.GetVisibleCheckIns(username, since))
+}
+
+func servAddCheckInAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	checkIn := new(CheckIn)
+	if err := json.NewDecoder(r.Body).Decode(checkIn); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	checkIn.Username = username
+
+	if err := location_db.AddCheckIn(checkIn); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Checked in", "checkIn.ID", checkIn.ID, "username", username)
+
+	writeJSON(w, http.StatusCreated, checkIn)
+}
+
+func servDeleteCheckInAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Check-in not found")
+		return
+	}
+
+	if err := location_db.DeleteCheckIn(uint(id), username); err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func servCheckInSettingsAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, location_db.GetSettings(username))
+}
+
+func servSaveCheckInSettingsAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	settings := new(location_db.Settings)
+	if err := json.NewDecoder(r.Body).Decode(settings); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	settings.Username = username
+
+	if err := location_db.SaveSettings(settings); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save settings")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Location sharing changed", "share", settings.Share, "username", username)
+
+	writeJSON(w, http.StatusOK, settings)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+)
+
+const maxArticleImageSize = 16 << 20
+
+// Accepts an image from the article editor. TinyMCE inserts the returned
+// location, other clients can use the srcset of resized renditions.
+func servUploadArticleImage(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servUploadArticleImage()")
+
+	if storageCritical() {
+		writeJSONError(w, http.StatusInsufficientStorage, "Storage is critically low, please try again later")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArticleImageSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Expected an image in the file field")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Image too large")
+		return
+	}
+
+	media, err := blog_db.SaveMedia(username, header.Filename, data)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Article image uploaded", "filename", media.Filename, "size", media.Size, "username", username)
+
+	writeJSON(w, http.StatusCreated, map[string]any{"location": media.URL, "srcset": media.Srcset, "media": media})
+}
+
+// Lists the member's uploads for the editor's image browser. Admins can list
+// everyone's with ?all=true.
+func servMediaAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servMediaAPI()")
+
+	owner := username
+	if r.URL.Query().Get("all") == "true" && isAdmin(username) {
+		owner = ""
+	}
+
+	writeJSON(w, http.StatusOK, blog_db.GetMedia(owner))
+}
+
+func servCleanupMediaAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	count, err := blog_db.CleanupOrphanedMedia(time.Now())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Orphaned media cleaned up", "removed", count, "username", username)
+	audit_db.Record(audit_db.ActionMediaCleaned, username, clientIP(r), strconv.Itoa(count)+" files removed")
+
+	writeJSON(w, http.StatusOK, map[string]int{"removed": count})
+}
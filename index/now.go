@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+)
+
+type NowPage = blog_db.NowPage
+
+// /now lists everyone's Now pages, /now?user= shows one with its archived
+// history and /now?edit opens the signed in member's page in the editor.
+// Visitors who aren't signed in only see the pages made public.
+type NowPageData struct {
+	Title   string     `json:"title"`
+	Pages   []*NowPage `json:"pages,omitempty"`
+	Page    *NowPage   `json:"page,omitempty"`
+	User    string     `json:"user,omitempty"`
+	History []*Article `json:"history,omitempty"`
+	Editing bool       `json:"editing,omitempty"`
+}
+
+func servNow(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	switch r.Method {
+	case http.MethodGet:
+		pageData := new(NowPageData)
+		pageData.Title = "Now"
+
+		if r.URL.Query().Has("edit") {
+			if ok, username = basicAuth(w, r); !ok {
+				logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+				return
+			}
+			pageData.Editing = true
+			pageData.User = username
+		} else {
+			username = currentUser(r)
+			pageData.User = r.URL.Query().Get("user")
+		}
+
+		logger.DebugContext(r.Context(), "servNow()[GET]", "pageData.User", pageData.User, "pageData.Editing", pageData.Editing)
+
+		if len(pageData.User) > 0 {
+			pageData.Page = blog_db.GetNowPage(pageData.User)
+			if pageData.Page != nil && !pageData.Page.CanView(username) {
+				pageData.Page = nil
+			}
+			if pageData.Page == nil && pageData.User != username {
+				http.NotFound(w, r)
+				return
+			}
+
+			// Older versions are family content
+			if len(username) > 0 {
+				pageData.History = blog_db.GetNowHistory(pageData.User)
+			}
+		} else {
+			pageData.Pages = visibleNowPages(username)
+		}
+
+		t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/now.html")
+		err := t.Execute(w, pageData)
+
+		if err != nil {
+			logger.ErrorContext(r.Context(), err.Error())
+			return
+		}
+	case http.MethodPost:
+		if ok, username = basicAuth(w, r); !ok {
+			logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+			return
+		}
+
+		logger.DebugContext(r.Context(), "servNow()[POST]")
+
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorContext(r.Context(), "Form parsing error")
+			http.Error(w, "Form parsing error", http.StatusBadRequest)
+			return
+		}
+
+		if storageCritical() {
+			logger.ErrorContext(r.Context(), "Now page not saved, storage critically low", "username", username)
+			http.Error(w, "Storage is critically low, please try again later", http.StatusInsufficientStorage)
+			return
+		}
+
+		if _, err := blog_db.SaveNowPage(username, template.HTML(r.FormValue("content")), r.FormValue("public") == "on"); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to save Now page", "username", username, "error", err.Error())
+			http.Error(w, "Unable to save Now page", http.StatusInternalServerError)
+			return
+		}
+		audit_db.Record(audit_db.ActionNowUpdated, username, clientIP(r), "")
+
+		http.Redirect(w, r, "/now?user="+url.QueryEscape(username), http.StatusFound)
+	default:
+		logger.InfoContext(r.Context(), "Method not allowed", "r.Method", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func visibleNowPages(username string) []*NowPage {
+	return slices.DeleteFunc(blog_db.GetNowPages(), func(page *NowPage) bool { return !page.CanView(username) })
+}
+
+func servNowPagesAPI(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "servNowPagesAPI()")
+
+	writeJSON(w, http.StatusOK, visibleNowPages(currentUser(r)))
+}
+
+func servSaveNowPageAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	request := struct {
+		Content string `json:"content"`
+		Public  bool   `json:"public"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	if storageCritical() {
+		writeJSONError(w, http.StatusInsufficientStorage, "Storage is critically low, please try again later")
+		return
+	}
+
+	page, err := blog_db.SaveNowPage(username, template.HTML(request.Content), request.Public)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Now page updated", "username", username)
+	audit_db.Record(audit_db.ActionNowUpdated, username, clientIP(r), "")
+
+	writeJSON(w, http.StatusOK, page)
+}
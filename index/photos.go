@@ -0,0 +1,169 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+)
+
+// Serves a gallery root's files to members who can view the album. Only
+// photos are served, either an album's originals or the site photos in its
+// .site_photos directory: directory listings and the JSON kept next to the
+// site photos are not. HEIC/HEIF originals are negotiated down to their JPEG
+// rendition when the browser doesn't list the format in Accept. PNG and WebP
+// are served as they are, since every browser displays them and image
+// requests rarely list them.
+func servGalleryFiles(root *gallery_db.GalleryRoot) http.Handler {
+	fileServer := http.FileServer(http.Dir(root.Path))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var username string
+		var ok bool
+
+		if ok, username = basicAuth(w, r); !ok {
+			logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+			return
+		}
+
+		parts := strings.Split(strings.Trim(path.Clean("/"+r.URL.Path), "/"), "/")
+		albumName, photoName := parts[0], parts[len(parts)-1]
+
+		switch {
+		case len(parts) == 2:
+		case len(parts) == 3 && parts[1] == ".site_photos":
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(albumName, ".") || strings.HasPrefix(photoName, ".") || !gallery_db.IsGalleryPhoto(photoName) {
+			http.NotFound(w, r)
+			return
+		}
+
+		if gallery_db.GetAlbumRoot(albumName) != root.Name || !canViewAlbum(username, albumName) {
+			logger.InfoContext(r.Context(), "Gallery file not allowed", "albumName", albumName, "username", username)
+			http.NotFound(w, r)
+			return
+		}
+
+		if mimeType := gallery_db.PhotoMimeType(photoName); (mimeType == "image/heic" || mimeType == "image/heif") && len(parts) == 2 {
+			w.Header().Add("Vary", "Accept")
+
+			if !strings.Contains(r.Header.Get("Accept"), mimeType) {
+				if renditionPath := gallery_db.GetJPEGRendition(albumName, photoName); len(renditionPath) > 0 {
+					logger.Debug("servGalleryFiles()", "albumName", albumName, "photoName", photoName, "renditionPath", renditionPath)
+					http.ServeFile(w, r, renditionPath)
+					return
+				}
+			}
+		}
+
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// Every gallery root is served under /photos/.
+func isGalleryFile(urlPath string) bool {
+	return strings.HasPrefix(urlPath, "/photos/")
+}
+
+// Streams a ZIP of the album's originals, or of the photos listed in
+// ?photos=a.jpg,b.jpg, straight to the response without buffering.
+func servAlbumDownload(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	albumName := r.PathValue("name")
+	logger.DebugContext(r.Context(), "servAlbumDownload()", "albumName", albumName, "username", username)
+
+	if !canViewAlbum(username, albumName) {
+		writeJSONError(w, http.StatusForbidden, "Album not available")
+		return
+	}
+
+	originals := gallery_db.GetAlbumOriginalPaths(albumName)
+	if len(originals) == 0 {
+		writeJSONError(w, http.StatusNotFound, "Album not found")
+		return
+	}
+
+	if selected := r.URL.Query().Get("photos"); len(selected) > 0 {
+		wanted := make(map[string]bool)
+		for _, name := range strings.Split(selected, ",") {
+			wanted[strings.TrimSpace(name)] = true
+		}
+
+		filtered := make([]string, 0, len(wanted))
+		for _, original := range originals {
+			if wanted[path.Base(original)] {
+				filtered = append(filtered, original)
+			}
+		}
+		originals = filtered
+
+		if len(originals) == 0 {
+			writeJSONError(w, http.StatusNotFound, "No matching photos")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": albumName + ".zip"}))
+
+	zipWriter := zip.NewWriter(w)
+
+	for _, original := range originals {
+		if err := addToZip(zipWriter, original); err != nil {
+			// Headers are already sent, so the truncated archive is the only
+			// signal the client gets.
+			logger.ErrorContext(r.Context(), err.Error(), "albumName", albumName, "original", original)
+			return
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Album downloaded", "albumName", albumName, "photos", len(originals), "username", username)
+}
+
+func addToZip(zipWriter *zip.Writer, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	// Photos are already compressed
+	header.Method = zip.Store
+
+	entry, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, f)
+	return err
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/poll_db"
+)
+
+type Poll = poll_db.Poll
+
+type PollView struct {
+	*Poll
+	Results   *poll_db.Results `json:"results"`
+	Closed    bool             `json:"closed"`
+	CanManage bool             `json:"can_manage"`
+}
+
+type PollsPage struct {
+	Title string      `json:"title"`
+	Polls []*PollView `json:"polls"`
+}
+
+func getPollView(poll *Poll, username string) *PollView {
+	return &PollView{
+		Poll:      poll,
+		Results:   poll_db.GetResults(poll, username),
+		Closed:    poll.IsClosed(time.Now()),
+		CanManage: canManagePoll(username, poll),
+	}
+}
+
+func getPollViews(article string, username string) []*PollView {
+	polls := poll_db.GetPolls(article)
+
+	views := make([]*PollView, 0, len(polls))
+	for _, poll := range polls {
+		views = append(views, getPollView(poll, username))
+	}
+
+	return views
+}
+
+// Polls attached to an article are only visible to those who can see it.
+func canViewPoll(username string, poll *Poll) bool {
+	if len(poll.Article) == 0 {
+		return true
+	}
+	article := blog_db.GetArticle(poll.Article)
+	return article != nil && article.CanView(username)
+}
+
+func pollFromPath(w http.ResponseWriter, r *http.Request, username string) *Poll {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Poll not found")
+		return nil
+	}
+
+	poll := poll_db.GetPoll(uint(id))
+	if poll == nil || !canViewPoll(username, poll) {
+		writeJSONError(w, http.StatusNotFound, "Poll not found")
+		return nil
+	}
+
+	return poll
+}
+
+func servPolls(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servPolls()")
+
+	pageData := new(PollsPage)
+	pageData.Title = "Polls"
+	pageData.Polls = getPollViews("", username)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/polls.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+// Lists the standalone polls, or those attached to ?article=.
+func servPollsAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	key := r.URL.Query().Get("article")
+	if len(key) > 0 {
+		if article := blog_db.GetArticle(key); article == nil || !article.CanView(username) {
+			writeJSONError(w, http.StatusNotFound, "Article not found")
+			return
+		}
+	}
+
+	logger.DebugContext(r.Context(), "servPollsAPI()", "article", key)
+
+	writeJSON(w, http.StatusOK, getPollViews(key, username))
+}
+
+func servPollAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	if poll := pollFromPath(w, r, username); poll != nil {
+		writeJSON(w, http.StatusOK, getPollView(poll, username))
+	}
+}
+
+func servCreatePollAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	poll := new(Poll)
+	if err := json.NewDecoder(r.Body).Decode(poll); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	poll.CreatedBy = username
+
+	// Only the article's authors can attach a poll to it
+	if len(poll.Article) > 0 && !canEditArticle(username, blog_db.GetArticle(poll.Article)) {
+		logger.InfoContext(r.Context(), "Poll on article not allowed", "article", poll.Article, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only the article's authors can add a poll to it")
+		return
+	}
+
+	if err := poll_db.CreatePoll(poll); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Poll created", "poll.ID", poll.ID, "poll.Article", poll.Article, "username", username)
+
+	writeJSON(w, http.StatusCreated, getPollView(poll, username))
+}
+
+// Replaces the member's ballot. An empty list of options retracts it.
+func servVotePollAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	poll := pollFromPath(w, r, username)
+	if poll == nil {
+		return
+	}
+
+	request := struct {
+		Options []int `json:"options"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	if err := poll_db.CastVote(poll, username, request.Options); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Poll vote cast", "poll.ID", poll.ID, "options", request.Options, "username", username)
+
+	writeJSON(w, http.StatusOK, getPollView(poll, username))
+}
+
+func servClosePollAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	poll := pollFromPath(w, r, username)
+	if poll == nil {
+		return
+	}
+	if !canManagePoll(username, poll) {
+		logger.InfoContext(r.Context(), "Poll close not allowed", "poll.ID", poll.ID, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only the poll's creator can close it")
+		return
+	}
+
+	if err := poll_db.ClosePoll(poll); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to close poll")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Poll closed", "poll.ID", poll.ID, "username", username)
+
+	writeJSON(w, http.StatusOK, getPollView(poll, username))
+}
+
+func servDeletePollAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	poll := pollFromPath(w, r, username)
+	if poll == nil {
+		return
+	}
+	if !canManagePoll(username, poll) {
+		logger.InfoContext(r.Context(), "Poll delete not allowed", "poll.ID", poll.ID, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only the poll's creator can delete it")
+		return
+	}
+
+	if err := poll_db.DeletePoll(poll.ID); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to delete poll")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Poll deleted", "poll.ID", poll.ID, "username", username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/jeffereydecker/blazemarker/blog_db"
+)
+
+// Returns the Open Graph preview for ?url=, fetching it if it isn't cached.
+func servLinkPreviewAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	link := r.URL.Query().Get("url")
+
+	logger.DebugContext(r.Context(), "servLinkPreviewAPI()", "link", link)
+
+	if len(link) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Missing url")
+		return
+	}
+
+	preview, err := blog_db.GetLinkPreview(r.Context(), link)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, preview)
+}
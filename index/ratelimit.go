@@ -0,0 +1,239 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+)
+
+// Failed logins are tracked under three keys:
+//
+//   - per client address, allowing maxAddressFailures since members may
+//     share one, or maxLoginFailures when there is no account, e.g. for
+//     registrations
+//   - per account from that address, allowing maxLoginFailures
+//   - per account from anywhere, so rotating addresses doesn't give an
+//     attacker unlimited guesses
+//
+// After too many consecutive failures an address key is locked out,
+// doubling the lockout each time it happens again. The account-wide key is
+// only throttled, for at most maxAccountThrottle, so failures from other
+// addresses can slow a member's login but never lock them out. Every failed
+// challenge is also answered progressively slower.
+const (
+	maxLoginFailures    = 5
+	maxAddressFailures  = 4 * maxLoginFailures
+	baseLockout         = time.Minute
+	maxLockout          = time.Hour
+	baseAccountThrottle = time.Second
+	maxAccountThrottle  = 30 * time.Second
+	maxChallengeWait    = 5 * time.Second
+	failureMemory       = 24 * time.Hour
+)
+
+// Requests per second and burst allowed per client address. Photos requested
+// with credentials that were recently verified draw on a separate, larger
+// bucket, since a single album page can show hundreds of them.
+const (
+	requestRate  = 50
+	requestBurst = 200
+	photoRate    = 200
+	photoBurst   = 1000
+)
+
+type loginFailures struct {
+	Failures    int
+	Lockouts    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+type tokenBucket struct {
+	Tokens float64
+	Last   time.Time
+}
+
+var (
+	failures      = make(map[string]*loginFailures)
+	failuresMutex sync.Mutex
+
+	buckets      = make(map[string]*tokenBucket)
+	bucketsMutex sync.Mutex
+)
+
+// Doubles base the given number of times, stopping at limit rather than
+// overflowing.
+func doubled(base time.Duration, times int, limit time.Duration) time.Duration {
+	for i := 0; i < times && base < limit; i++ {
+		base = base * 2
+	}
+	return min(base, limit)
+}
+
+func failureKeys(ip string, username string) []string {
+	if len(username) == 0 {
+		return []string{"ip:" + ip}
+	}
+	return []string{"ip:" + ip, "user-ip:" + username + "@" + ip, "user:" + username}
+}
+
+// The account-wide key, which is throttled rather than locked out.
+func isAccountKey(key string) bool {
+	return strings.HasPrefix(key, "user:")
+}
+
+// Returns how long the address or account is still locked out or throttled
+// for.
+func loginLockout(ip string, username string) time.Duration {
+	failuresMutex.Lock()
+	defer failuresMutex.Unlock()
+
+	var remaining time.Duration
+	for _, key := range failureKeys(ip, username) {
+		if f, ok := failures[key]; ok {
+			remaining = max(remaining, time.Until(f.LockedUntil))
+		}
+	}
+	return remaining
+}
+
+// Records a failed login and returns how long to wait before answering.
+func recordLoginFailure(ip string, username string) time.Duration {
+	failuresMutex.Lock()
+	defer failuresMutex.Unlock()
+
+	wait := time.Duration(0)
+	now := time.Now()
+
+	for _, key := range failureKeys(ip, username) {
+		f, ok := failures[key]
+		if !ok || now.Sub(f.LastFailure) > failureMemory {
+			f = new(loginFailures)
+			failures[key] = f
+		}
+
+		f.Failures = f.Failures + 1
+		f.LastFailure = now
+
+		limit := maxLoginFailures
+		if len(username) > 0 && strings.HasPrefix(key, "ip:") {
+			limit = maxAddressFailures
+		}
+
+		if isAccountKey(key) {
+			if f.Failures >= limit {
+				throttle := doubled(baseAccountThrottle, f.Failures-limit, maxAccountThrottle)
+				f.LockedUntil = now.Add(throttle)
+
+				logger.Info("Login throttled", "key", key, "throttle", throttle.String())
+			}
+			continue
+		}
+
+		if f.Failures >= limit {
+			lockout := doubled(baseLockout, f.Lockouts, maxLockout)
+			f.LockedUntil = now.Add(lockout)
+			f.Lockouts = f.Lockouts + 1
+			f.Failures = 0
+
+			logger.Warn("Login locked out", "key", key, "lockout", lockout.String())
+			go audit_db.Record(audit_db.ActionLockout, username, ip, key+" locked out for "+lockout.String())
+		}
+
+		wait = max(wait, doubled(100*time.Millisecond, f.Failures+f.Lockouts*maxLoginFailures, maxChallengeWait))
+	}
+
+	return wait
+}
+
+func clearLoginFailures(ip string, username string) {
+	failuresMutex.Lock()
+	defer failuresMutex.Unlock()
+
+	for _, key := range failureKeys(ip, username) {
+		if f, ok := failures[key]; ok && time.Now().After(f.LockedUntil) {
+			delete(failures, key)
+		}
+	}
+}
+
+func allowRequest(key string, rate float64, burst float64) bool {
+	bucketsMutex.Lock()
+	defer bucketsMutex.Unlock()
+
+	now := time.Now()
+	bucket, ok := buckets[key]
+	if !ok {
+		bucket = &tokenBucket{Tokens: burst, Last: now}
+		buckets[key] = bucket
+	}
+
+	bucket.Tokens = min(burst, bucket.Tokens+now.Sub(bucket.Last).Seconds()*rate)
+	bucket.Last = now
+
+	if bucket.Tokens < 1 {
+		return false
+	}
+	bucket.Tokens = bucket.Tokens - 1
+	return true
+}
+
+func rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		key, rate, burst := ip, float64(requestRate), float64(requestBurst)
+		if isGalleryFile(r.URL.Path) {
+			if _, ok := verifiedUser(r); ok {
+				key, rate, burst = "photos:"+ip, photoRate, photoBurst
+			}
+		}
+
+		if !allowRequest(key, rate, burst) {
+			logger.WarnContext(r.Context(), "Rate limit exceeded", "ip", ip, "r.URL.Path", r.URL.Path)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Drops idle rate limiting state so the maps don't grow without bound.
+func startRateLimitCleanup(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			now := time.Now()
+
+			bucketsMutex.Lock()
+			for key, bucket := range buckets {
+				if now.Sub(bucket.Last) > interval {
+					delete(buckets, key)
+				}
+			}
+			bucketsMutex.Unlock()
+
+			failuresMutex.Lock()
+			for key, f := range failures {
+				if now.Sub(f.LastFailure) > failureMemory && now.After(f.LockedUntil) {
+					delete(failures, key)
+				}
+			}
+			failuresMutex.Unlock()
+
+			pruneGuestbookPosts(now)
+			pruneCredentials(now)
+		}
+	}()
+}
+
+func writeLockedOut(w http.ResponseWriter, remaining time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(`{"message": "Too many failed logins, try again later"}`))
+}
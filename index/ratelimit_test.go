@@ -0,0 +1,194 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func resetRateLimits(t *testing.T) {
+	t.Helper()
+
+	failuresMutex.Lock()
+	failures = make(map[string]*loginFailures)
+	failuresMutex.Unlock()
+
+	bucketsMutex.Lock()
+	buckets = make(map[string]*tokenBucket)
+	bucketsMutex.Unlock()
+}
+
+func TestFailureKeys(t *testing.T) {
+	tests := []struct {
+		ip       string
+		username string
+		keys     []string
+	}{
+		{"192.0.2.1", "", []string{"ip:192.0.2.1"}},
+		{"192.0.2.1", "alice", []string{"ip:192.0.2.1", "user-ip:alice@192.0.2.1", "user:alice"}},
+		{"2001:db8::1", "bob", []string{"ip:2001:db8::1", "user-ip:bob@2001:db8::1", "user:bob"}},
+	}
+
+	for _, test := range tests {
+		if keys := failureKeys(test.ip, test.username); !slices.Equal(keys, test.keys) {
+			t.Errorf("failureKeys(%q, %q) = %q, want %q", test.ip, test.username, keys, test.keys)
+		}
+	}
+}
+
+func TestRecordLoginFailure(t *testing.T) {
+	type attempt struct {
+		ip       string
+		username string
+	}
+	repeat := func(n int, a attempt) []attempt {
+		attempts := make([]attempt, n)
+		for i := range attempts {
+			attempts[i] = a
+		}
+		return attempts
+	}
+	rotating := func(n int, username string) []attempt {
+		attempts := make([]attempt, n)
+		for i := range attempts {
+			attempts[i] = attempt{"198.51.100." + string(rune('0'+i)), username}
+		}
+		return attempts
+	}
+
+	tests := []struct {
+		name     string
+		failures []attempt
+		check    attempt
+		min      time.Duration
+		max      time.Duration
+	}{
+		{
+			name:     "a few failures don't lock out",
+			failures: repeat(maxLoginFailures-1, attempt{"192.0.2.1", "alice"}),
+			check:    attempt{"192.0.2.1", "alice"},
+			min:      0,
+			max:      0,
+		},
+		{
+			name:     "the account is locked out from the failing address",
+			failures: repeat(maxLoginFailures, attempt{"192.0.2.1", "alice"}),
+			check:    attempt{"192.0.2.1", "alice"},
+			min:      baseLockout - time.Second,
+			max:      baseLockout,
+		},
+		{
+			name:     "elsewhere the account is only throttled",
+			failures: repeat(maxLoginFailures, attempt{"192.0.2.1", "alice"}),
+			check:    attempt{"203.0.113.9", "alice"},
+			min:      1,
+			max:      maxAccountThrottle,
+		},
+		{
+			name:     "rotating addresses still throttle the account",
+			failures: rotating(maxLoginFailures, "alice"),
+			check:    attempt{"203.0.113.9", "alice"},
+			min:      1,
+			max:      maxAccountThrottle,
+		},
+		{
+			name:     "rotating addresses don't affect other accounts",
+			failures: rotating(maxLoginFailures, "alice"),
+			check:    attempt{"203.0.113.9", "bob"},
+			min:      0,
+			max:      0,
+		},
+		{
+			name:     "an address failing for many accounts is locked out",
+			failures: append(repeat(maxAddressFailures/2, attempt{"192.0.2.1", "alice"}), repeat(maxAddressFailures/2, attempt{"192.0.2.1", "bob"})...),
+			check:    attempt{"192.0.2.1", "carol"},
+			min:      baseLockout - time.Second,
+			max:      maxLockout,
+		},
+		{
+			name:     "without an account the address is locked out sooner",
+			failures: repeat(maxLoginFailures, attempt{"192.0.2.1", ""}),
+			check:    attempt{"192.0.2.1", ""},
+			min:      baseLockout - time.Second,
+			max:      baseLockout,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetRateLimits(t)
+
+			for _, failure := range test.failures {
+				if wait := recordLoginFailure(failure.ip, failure.username); wait <= 0 || wait > maxChallengeWait {
+					t.Fatalf("recordLoginFailure wait = %v, want between 0 and %v", wait, maxChallengeWait)
+				}
+			}
+
+			remaining := loginLockout(test.check.ip, test.check.username)
+			if remaining < test.min || remaining > test.max {
+				t.Errorf("loginLockout = %v, want between %v and %v", remaining, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestAccountThrottleIsBounded(t *testing.T) {
+	resetRateLimits(t)
+
+	for i := 0; i < 50; i++ {
+		recordLoginFailure("198.51.100.1", "alice")
+		// Keep the address itself from being locked out
+		clearLoginFailures("198.51.100.1", "")
+	}
+
+	if remaining := loginLockout("203.0.113.9", "alice"); remaining <= 0 || remaining > maxAccountThrottle {
+		t.Errorf("loginLockout = %v, want between 0 and %v", remaining, maxAccountThrottle)
+	}
+}
+
+func TestAllowRequest(t *testing.T) {
+	resetRateLimits(t)
+
+	for i := 0; i < requestBurst; i++ {
+		if !allowRequest("192.0.2.1", requestRate, requestBurst) {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	if allowRequest("192.0.2.1", requestRate, requestBurst) {
+		t.Error("request beyond the burst allowed")
+	}
+	if !allowRequest("192.0.2.2", requestRate, requestBurst) {
+		t.Error("another address was refused")
+	}
+
+	// Tokens come back at requestRate per second
+	bucketsMutex.Lock()
+	buckets["192.0.2.1"].Last = buckets["192.0.2.1"].Last.Add(-time.Second)
+	bucketsMutex.Unlock()
+	for i := 0; i < requestRate; i++ {
+		if !allowRequest("192.0.2.1", requestRate, requestBurst) {
+			t.Fatalf("request %d refused after refill", i+1)
+		}
+	}
+}
+
+func TestDoubled(t *testing.T) {
+	tests := []struct {
+		base  time.Duration
+		times int
+		limit time.Duration
+		want  time.Duration
+	}{
+		{time.Second, 0, time.Minute, time.Second},
+		{time.Second, 3, time.Minute, 8 * time.Second},
+		{time.Second, 6, time.Minute, time.Minute},
+		{time.Second, 1000, time.Minute, time.Minute},
+		{time.Second, -1, time.Minute, time.Second},
+	}
+
+	for _, test := range tests {
+		if got := doubled(test.base, test.times, test.limit); got != test.want {
+			t.Errorf("doubled(%v, %d, %v) = %v, want %v", test.base, test.times, test.limit, got, test.want)
+		}
+	}
+}
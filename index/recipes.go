@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/list_db"
+	"github.com/jeffereydecker/blazemarker/recipe_db"
+)
+
+type Recipe = recipe_db.Recipe
+
+type RecipesPage struct {
+	Title   string    `json:"title"`
+	Query   string    `json:"query"`
+	Recipes []*Recipe `json:"recipes"`
+}
+
+type RecipePage struct {
+	Title   string  `json:"title"`
+	Recipe  *Recipe `json:"recipe"`
+	CanEdit bool    `json:"can_edit"`
+	Lists   []*List `json:"lists"`
+}
+
+func recipeFromPath(w http.ResponseWriter, r *http.Request) *Recipe {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "Recipe not found")
+		return nil
+	}
+
+	recipe := recipe_db.GetRecipe(uint(id))
+	if recipe == nil {
+		writeJSONError(w, http.StatusNotFound, "Recipe not found")
+		return nil
+	}
+
+	return recipe
+}
+
+func decodeRecipe(w http.ResponseWriter, r *http.Request) *Recipe {
+	recipe := new(Recipe)
+	if err := json.NewDecoder(r.Body).Decode(recipe); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return nil
+	}
+
+	tags := make([]string, 0, len(recipe.Tags))
+	for _, tag := range recipe.Tags {
+		if tag = blog_db.NormalizeTag(tag); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	recipe.Tags = tags
+
+	return recipe
+}
+
+func servRecipes(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	pageData := new(RecipesPage)
+	pageData.Title = "Recipes"
+	pageData.Query = r.URL.Query().Get("q")
+	pageData.Recipes = recipe_db.SearchRecipes(pageData.Query)
+
+	logger.DebugContext(r.Context(), "servRecipes()", "pageData.Query", pageData.Query)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/recipes.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servRecipe(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servRecipe()", "id", id)
+
+	pageData := new(RecipePage)
+	if pageData.Recipe = recipe_db.GetRecipe(uint(id)); pageData.Recipe == nil {
+		http.NotFound(w, r)
+		return
+	}
+	pageData.Title = pageData.Recipe.Title
+	pageData.CanEdit = canEditRecipe(username, pageData.Recipe)
+	pageData.Lists = list_db.GetLists(username)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/recipe.html")
+	err = t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servRecipesAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servRecipesAPI()")
+
+	writeJSON(w, http.StatusOK, recipe_db.SearchRecipes(r.URL.Query().Get("q")))
+}
+
+func servRecipeAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	if recipe := recipeFromPath(w, r); recipe != nil {
+		writeJSON(w, http.StatusOK, recipe)
+	}
+}
+
+func servCreateRecipeAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	recipe := decodeRecipe(w, r)
+	if recipe == nil {
+		return
+	}
+	recipe.Author = username
+
+	if err := recipe_db.CreateRecipe(recipe); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Recipe created", "recipe.ID", recipe.ID, "recipe.Title", recipe.Title, "username", username)
+
+	writeJSON(w, http.StatusCreated, recipe)
+}
+
+func servUpdateRecipeAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	recipe := recipeFromPath(w, r)
+	if recipe == nil {
+		return
+	}
+	if !canEditRecipe(username, recipe) {
+		logger.InfoContext(r.Context(), "Recipe edit not allowed", "recipe.ID", recipe.ID, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only the recipe's author can change it")
+		return
+	}
+
+	update := decodeRecipe(w, r)
+	if update == nil {
+		return
+	}
+	recipe.Title = update.Title
+	recipe.Ingredients = update.Ingredients
+	recipe.Steps = update.Steps
+	recipe.Photo = update.Photo
+	recipe.Tags = update.Tags
+
+	if err := recipe_db.UpdateRecipe(recipe); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Recipe updated", "recipe.ID", recipe.ID, "recipe.Title", recipe.Title, "username", username)
+
+	writeJSON(w, http.StatusOK, recipe)
+}
+
+func servDeleteRecipeAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	recipe := recipeFromPath(w, r)
+	if recipe == nil {
+		return
+	}
+	if !canEditRecipe(username, recipe) {
+		logger.InfoContext(r.Context(), "Recipe delete not allowed", "recipe.ID", recipe.ID, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only the recipe's author can delete it")
+		return
+	}
+
+	if err := recipe_db.DeleteRecipe(recipe.ID); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to delete recipe")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Recipe deleted", "recipe.ID", recipe.ID, "recipe.Title", recipe.Title, "username", username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Adds the recipe's ingredients, or the ones picked, to a shopping list.
+func servRecipeShoppingAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	recipe := recipeFromPath(w, r)
+	if recipe == nil {
+		return
+	}
+
+	request := struct {
+		ListID      uint     `json:"list_id"`
+		Ingredients []string `json:"ingredients"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	list := list_db.GetList(request.ListID)
+	if list == nil || !list.CanAccess(username) {
+		writeJSONError(w, http.StatusNotFound, "List not found")
+		return
+	}
+
+	ingredients := recipe.Ingredients
+	if len(request.Ingredients) > 0 {
+		ingredients = request.Ingredients
+	}
+
+	items := make([]*list_db.Item, 0, len(ingredients))
+	for _, ingredient := range ingredients {
+		items = append(items, &list_db.Item{Text: ingredient, AddedBy: username})
+	}
+
+	if err := list_db.AddItems(list.ID, items); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Recipe added to list", "recipe.ID", recipe.ID, "list.ID", list.ID, "items", len(items), "username", username)
+
+	writeJSON(w, http.StatusOK, getListView(list))
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Invite codes are single use and stored next to the .htpasswd file they
+// grant an entry in.
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedBy string    `json:"created_by"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	UsedBy    string    `json:"used_by,omitempty"`
+	Used      time.Time `json:"used,omitempty"`
+}
+
+type Registration struct {
+	Title    string `json:"title"`
+	Code     string `json:"code"`
+	Username string `json:"username"`
+	Message  string `json:"message"`
+}
+
+const (
+	invitesFile  = "../blaze_auth/invites.json"
+	htpasswdFile = "../blaze_auth/.htpasswd"
+	inviteTTL    = 14 * 24 * time.Hour
+)
+
+var usernameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+var errInvalidInvite = errors.New("the invite code is invalid, used or expired")
+
+// Serializes changes to the invites, .htpasswd, disabled and deleted files.
+var registrationMutex sync.Mutex
+
+func readInvites() []*Invite {
+	invites := make([]*Invite, 0)
+
+	jsonData, err := os.ReadFile(invitesFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return invites
+	}
+
+	if err := json.Unmarshal(jsonData, &invites); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return invites
+}
+
+func writeInvites(invites []*Invite) error {
+	jsonData, err := json.MarshalIndent(invites, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(invitesFile, jsonData, 0600)
+}
+
+func createInvite(createdBy string) (*Invite, error) {
+	code := make([]byte, 12)
+	if _, err := rand.Read(code); err != nil {
+		return nil, err
+	}
+
+	invite := &Invite{Code: hex.EncodeToString(code), CreatedBy: createdBy, Created: time.Now(), Expires: time.Now().Add(inviteTTL)}
+
+	registrationMutex.Lock()
+	defer registrationMutex.Unlock()
+
+	invites := append(readInvites(), invite)
+	if err := writeInvites(invites); err != nil {
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+func userExists(username string) (bool, error) {
+	f, err := os.Open(htpasswdFile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if name, _, found := strings.Cut(scanner.Text(), ":"); found && name == username {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// Redeems the invite and appends the new credential in one step so a code
+// can't be used twice and a failed write leaves the invite unused.
+func registerUser(code string, username string, password string) error {
+	if !usernameRe.MatchString(username) {
+		return errors.New("usernames are 3 to 32 letters, digits, dots, dashes or underscores")
+	}
+	if len(password) < 10 {
+		return errors.New("passwords must be at least 10 characters")
+	}
+
+	registrationMutex.Lock()
+	defer registrationMutex.Unlock()
+
+	invites := readInvites()
+	var invite *Invite
+	for _, candidate := range invites {
+		if candidate.Code == code {
+			invite = candidate
+		}
+	}
+	if invite == nil || len(invite.UsedBy) > 0 || time.Now().After(invite.Expires) {
+		return errInvalidInvite
+	}
+
+	exists, err := userExists(username)
+	if err != nil {
+		logger.Error(err.Error())
+		return errors.New("registration is unavailable, please try again later")
+	}
+	if !exists {
+		exists, err = isDeleted(username)
+		if err != nil {
+			logger.Error(err.Error())
+			return errors.New("registration is unavailable, please try again later")
+		}
+	}
+	if exists {
+		return errors.New("that username is taken")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Error(err.Error())
+		return errors.New("registration is unavailable, please try again later")
+	}
+
+	invite.UsedBy = username
+	invite.Used = time.Now()
+	if err := writeInvites(invites); err != nil {
+		logger.Error(err.Error())
+		return errors.New("registration is unavailable, please try again later")
+	}
+
+	f, err := os.OpenFile(htpasswdFile, os.O_WRONLY|os.O_APPEND, 0600)
+	if err == nil {
+		_, err = f.WriteString(username + ":" + string(hash) + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		invite.UsedBy = ""
+		invite.Used = time.Time{}
+		if err := writeInvites(invites); err != nil {
+			logger.Error(err.Error())
+		}
+		return errors.New("registration is unavailable, please try again later")
+	}
+
+	return nil
+}
+
+func servRegister(w http.ResponseWriter, r *http.Request) {
+	pageData := new(Registration)
+	pageData.Title = "Join Blazemarker"
+
+	switch r.Method {
+	case http.MethodGet:
+		logger.DebugContext(r.Context(), "servRegister()[GET]")
+		pageData.Code = r.URL.Query().Get("code")
+	case http.MethodPost:
+		logger.DebugContext(r.Context(), "servRegister()[POST]")
+
+		if err := r.ParseForm(); err != nil {
+			logger.ErrorContext(r.Context(), "Form parsing error")
+			http.Error(w, "Form parsing error", http.StatusBadRequest)
+			return
+		}
+
+		ip := clientIP(r)
+		pageData.Code = r.FormValue("code")
+		pageData.Username = r.FormValue("username")
+
+		if remaining := loginLockout(ip, ""); remaining > 0 {
+			writeLockedOut(w, remaining)
+			return
+		}
+
+		if r.FormValue("password") != r.FormValue("confirm") {
+			pageData.Message = "The passwords don't match"
+		} else if err := registerUser(pageData.Code, pageData.Username, r.FormValue("password")); err != nil {
+			// Guessing invite codes counts as failed logins from the address,
+			// mistakes in the form don't
+			if errors.Is(err, errInvalidInvite) {
+				recordLoginFailure(ip, "")
+			}
+			pageData.Message = err.Error()
+		} else {
+			logger.InfoContext(r.Context(), "User registered", "username", pageData.Username, "ip", ip)
+			audit_db.Record(audit_db.ActionUserCreated, pageData.Username, ip, "invite "+pageData.Code)
+			http.Redirect(w, r, "/articles", http.StatusFound)
+			return
+		}
+	default:
+		logger.InfoContext(r.Context(), "Method not allowed", "r.Method", r.Method)
+		return
+	}
+
+	t, _ := parseTemplates(r, "", "../templates/base.html", "../templates/register.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servInvitesAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servInvitesAPI()")
+
+	writeJSON(w, http.StatusOK, readInvites())
+}
+
+func servCreateInviteAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	invite, err := createInvite(username)
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to create invite")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Invite created", "username", username)
+	audit_db.Record(audit_db.ActionInviteCreated, username, clientIP(r), "")
+
+	writeJSON(w, http.StatusOK, map[string]any{"invite": invite, "url": "/register?code=" + invite.Code})
+}
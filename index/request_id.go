@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+)
+
+// Every request carries a correlation ID in its context, logged as request_id
+// by the logger's ...Context methods and returned in X-Request-ID. An ID sent
+// by a proxy in the same header is kept so traces line up across both.
+var requestID_re = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		logger.Error(err.Error())
+	}
+	return hex.EncodeToString(id)
+}
+
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if requestID_re.FindStringIndex(id) == nil {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(blaze_log.WithRequestID(r.Context(), id)))
+	})
+}
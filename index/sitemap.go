@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The sitemap lists the pages anyone can read without signing in. Articles
+// and albums need a member login, so they are left out. Absolute URLs use
+// BLAZEMARKER_SITE_URL, https://blazemarker.com by default.
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name      `xml:"urlset"`
+	XMLNS   string        `xml:"xmlns,attr"`
+	URLs    []*sitemapURL `xml:"url"`
+}
+
+func siteURL() string {
+	if value := os.Getenv("BLAZEMARKER_SITE_URL"); len(value) > 0 {
+		return strings.TrimSuffix(value, "/")
+	}
+	return "https://blazemarker.com"
+}
+
+func lastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+func servSitemap(w http.ResponseWriter, r *http.Request) {
+	logger.DebugContext(r.Context(), "servSitemap()")
+
+	urlSet := &sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	urlSet.URLs = append(urlSet.URLs, &sitemapURL{Loc: siteURL() + "/"})
+
+	pages := visibleNowPages("")
+	if len(pages) > 0 {
+		urlSet.URLs = append(urlSet.URLs, &sitemapURL{Loc: siteURL() + "/now", LastMod: lastMod(pages[0].Updated)})
+	}
+	for _, page := range pages {
+		urlSet.URLs = append(urlSet.URLs, &sitemapURL{Loc: siteURL() + "/now?user=" + url.QueryEscape(page.Author), LastMod: lastMod(page.Updated)})
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(urlSet); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+)
+
+// The public status page lets family members check whether the site is down
+// without logging in. It only shows coarse component health and planned
+// maintenance, and is off unless BLAZEMARKER_PUBLIC_STATUS is set to true.
+
+type ComponentStatus struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+}
+
+type MaintenanceWindow struct {
+	ID          int       `json:"id"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Description string    `json:"description"`
+}
+
+type StatusPage struct {
+	Title       string               `json:"title"`
+	Checked     time.Time            `json:"checked"`
+	Components  []*ComponentStatus   `json:"components"`
+	Maintenance []*MaintenanceWindow `json:"maintenance"`
+}
+
+const maintenanceFile = "../maintenance.json"
+
+var maintenanceMutex sync.Mutex
+
+func publicStatusEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("BLAZEMARKER_PUBLIC_STATUS"))
+	return enabled
+}
+
+func readMaintenanceWindows() []*MaintenanceWindow {
+	windows := make([]*MaintenanceWindow, 0)
+
+	jsonData, err := os.ReadFile(maintenanceFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return windows
+	}
+
+	if err := json.Unmarshal(jsonData, &windows); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return windows
+}
+
+func writeMaintenanceWindows(windows []*MaintenanceWindow) error {
+	jsonData, err := json.MarshalIndent(windows, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(maintenanceFile, jsonData, 0644)
+}
+
+// Windows that haven't ended yet, soonest first.
+func upcomingMaintenance() []*MaintenanceWindow {
+	now := time.Now()
+
+	windows := slices.DeleteFunc(readMaintenanceWindows(), func(window *MaintenanceWindow) bool {
+		return window.End.Before(now)
+	})
+	slices.SortFunc(windows, func(a, b *MaintenanceWindow) int { return a.Start.Compare(b.Start) })
+
+	return windows
+}
+
+func getStatusPage() *StatusPage {
+	status := &StatusPage{Title: "Blazemarker Status", Checked: time.Now()}
+
+	status.Components = append(status.Components, &ComponentStatus{Name: "Website", OK: true})
+	status.Components = append(status.Components, &ComponentStatus{Name: "Database", OK: audit_db.Ping() == nil})
+	status.Components = append(status.Components, &ComponentStatus{Name: "Photo and article storage", OK: !storageCritical()})
+	status.Maintenance = upcomingMaintenance()
+
+	return status
+}
+
+func servStatus(w http.ResponseWriter, r *http.Request) {
+	if !publicStatusEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servStatus()")
+
+	pageData := getStatusPage()
+
+	if r.URL.Query().Get("format") == "json" {
+		writeJSON(w, http.StatusOK, pageData)
+		return
+	}
+
+	t, _ := parseTemplates(r, "", "../templates/base.html", "../templates/status.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servMaintenanceAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servMaintenanceAPI()")
+
+	writeJSON(w, http.StatusOK, upcomingMaintenance())
+}
+
+func servAddMaintenanceAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	window := new(MaintenanceWindow)
+	if err := json.NewDecoder(r.Body).Decode(window); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if window.Start.IsZero() || !window.End.After(window.Start) {
+		writeJSONError(w, http.StatusBadRequest, "A maintenance window needs a start before its end")
+		return
+	}
+
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+
+	// Past windows are dropped whenever the list is rewritten
+	windows := upcomingMaintenance()
+	window.ID = 0
+	for _, existing := range readMaintenanceWindows() {
+		window.ID = max(window.ID, existing.ID)
+	}
+	window.ID = window.ID + 1
+	windows = append(windows, window)
+
+	if err := writeMaintenanceWindows(windows); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save maintenance window")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Maintenance window added", "username", username, "start", window.Start, "end", window.End)
+	audit_db.Record(audit_db.ActionMaintenanceScheduled, username, clientIP(r), window.Start.Format(time.RFC3339)+" to "+window.End.Format(time.RFC3339)+": "+window.Description)
+
+	writeJSON(w, http.StatusOK, window)
+}
+
+func servDeleteMaintenanceAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid maintenance window id")
+		return
+	}
+
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+
+	windows := readMaintenanceWindows()
+	remaining := slices.DeleteFunc(slices.Clone(windows), func(window *MaintenanceWindow) bool { return window.ID == id })
+	if len(remaining) == len(windows) {
+		writeJSONError(w, http.StatusNotFound, "Maintenance window not found")
+		return
+	}
+
+	if err := writeMaintenanceWindows(remaining); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save maintenance windows")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Maintenance window removed", "username", username, "id", id)
+
+	writeJSON(w, http.StatusOK, map[string]int{"id": id})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type VolumeStatus struct {
+	Name        string  `json:"name"`
+	Path        string  `json:"path"`
+	TotalBytes  uint64  `json:"total_bytes"`
+	FreeBytes   uint64  `json:"free_bytes"`
+	FreePercent float64 `json:"free_percent"`
+	Level       string  `json:"level"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type StorageStatus struct {
+	Checked         time.Time       `json:"checked"`
+	WarningPercent  float64         `json:"warning_percent"`
+	CriticalPercent float64         `json:"critical_percent"`
+	Volumes         []*VolumeStatus `json:"volumes"`
+}
+
+// Volumes that can't be measured are unknown rather than critical, so a
+// failing check doesn't stop every save.
+const (
+	StorageOK       = "ok"
+	StorageWarning  = "warning"
+	StorageCritical = "critical"
+	StorageUnknown  = "unknown"
+)
+
+var storageVolumes = []struct {
+	Name string
+	Path string
+}{
+	{"photos", "../photos"},
+	{"data", "../articles"},
+	{"logs", "../logs"},
+}
+
+var (
+	storageStatus      *StorageStatus
+	storageStatusMutex sync.Mutex
+)
+
+// Thresholds are percentages of free space, overridable with
+// BLAZEMARKER_STORAGE_WARNING_PERCENT and BLAZEMARKER_STORAGE_CRITICAL_PERCENT.
+func storageThreshold(name string, defaultPercent float64) float64 {
+	if value := os.Getenv(name); len(value) > 0 {
+		percent, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return percent
+		}
+		logger.Error(err.Error(), "name", name)
+	}
+	return defaultPercent
+}
+
+func checkVolume(name string, path string, warningPercent float64, criticalPercent float64) *VolumeStatus {
+	volume := &VolumeStatus{Name: name, Path: path, Level: StorageOK}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		volume.Level = StorageUnknown
+		volume.Error = err.Error()
+		return volume
+	}
+
+	volume.TotalBytes = stat.Blocks * uint64(stat.Bsize)
+	volume.FreeBytes = stat.Bavail * uint64(stat.Bsize)
+	if volume.TotalBytes == 0 {
+		volume.Level = StorageUnknown
+		volume.Error = "volume reports no size"
+		return volume
+	}
+	volume.FreePercent = float64(volume.FreeBytes) * 100 / float64(volume.TotalBytes)
+
+	if volume.FreePercent < criticalPercent {
+		volume.Level = StorageCritical
+	} else if volume.FreePercent < warningPercent {
+		volume.Level = StorageWarning
+	}
+
+	return volume
+}
+
+func checkStorage() *StorageStatus {
+	status := new(StorageStatus)
+	status.Checked = time.Now()
+	status.WarningPercent = storageThreshold("BLAZEMARKER_STORAGE_WARNING_PERCENT", 10)
+	status.CriticalPercent = storageThreshold("BLAZEMARKER_STORAGE_CRITICAL_PERCENT", 2)
+
+	for _, storageVolume := range storageVolumes {
+		volume := checkVolume(storageVolume.Name, storageVolume.Path, status.WarningPercent, status.CriticalPercent)
+		status.Volumes = append(status.Volumes, volume)
+
+		switch volume.Level {
+		case StorageCritical:
+			logger.Error("Storage critically low, uploads paused", "volume", volume.Name, "path", volume.Path, "free_percent", volume.FreePercent)
+		case StorageUnknown:
+			logger.Error("Storage check failed", "volume", volume.Name, "path", volume.Path, "error", volume.Error)
+		case StorageWarning:
+			logger.Warn("Storage running low", "volume", volume.Name, "path", volume.Path, "free_percent", volume.FreePercent)
+		}
+	}
+
+	storageStatusMutex.Lock()
+	storageStatus = status
+	storageStatusMutex.Unlock()
+
+	return status
+}
+
+func getStorageStatus() *StorageStatus {
+	storageStatusMutex.Lock()
+	status := storageStatus
+	storageStatusMutex.Unlock()
+
+	if status == nil {
+		status = checkStorage()
+	}
+	return status
+}
+
+// Uploads are refused up front while any volume is measured to be critically
+// low instead of failing part way through a write.
+func storageCritical() bool {
+	for _, volume := range getStorageStatus().Volumes {
+		if volume.Level == StorageCritical {
+			return true
+		}
+	}
+	return false
+}
+
+func startStorageMonitor(interval time.Duration) {
+	checkStorage()
+
+	go func() {
+		for range time.Tick(interval) {
+			checkStorage()
+		}
+	}()
+}
+
+func servStorageAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servStorageAPI()")
+
+	writeJSON(w, http.StatusOK, checkStorage())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+)
+
+type TagCount = blog_db.TagCount
+
+// Lists tags with their article counts, for the tag cloud and the editor's
+// autocomplete. ?prefix= narrows the list to matching tags.
+func servTagsAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	prefix := blog_db.NormalizeTag(r.URL.Query().Get("prefix"))
+
+	logger.DebugContext(r.Context(), "servTagsAPI()", "prefix", prefix)
+
+	tags := make([]*TagCount, 0)
+	for _, tag := range blog_db.GetTags() {
+		if strings.HasPrefix(tag.Name, prefix) {
+			tags = append(tags, tag)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// Renames a tag on every article. Renaming onto an existing tag merges them.
+func servRenameTagAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	tag := r.PathValue("name")
+
+	request := struct {
+		Name string `json:"name"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	count, err := blog_db.RenameTag(tag, request.Name)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Tag renamed", "tag", tag, "name", request.Name, "articles", count, "username", username)
+	audit_db.Record(audit_db.ActionTagRenamed, username, clientIP(r), tag+" -> "+blog_db.NormalizeTag(request.Name))
+
+	writeJSON(w, http.StatusOK, map[string]int{"articles": count})
+}
+
+func servDeleteTagAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	tag := r.PathValue("name")
+
+	count, err := blog_db.DeleteTag(tag)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Tag deleted", "tag", tag, "articles", count, "username", username)
+	audit_db.Record(audit_db.ActionTagDeleted, username, clientIP(r), tag)
+
+	writeJSON(w, http.StatusOK, map[string]int{"articles": count})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/jeffereydecker/blazemarker/activity_db"
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/bookmark_db"
+	"github.com/jeffereydecker/blazemarker/poll_db"
+)
+
+// Deleting an article moves it to its authors' trash, where they can restore
+// or purge it. What is left is purged after 30 days.
+
+type TrashPage struct {
+	Title    string     `json:"title"`
+	Articles []*Article `json:"articles"`
+}
+
+func servTrash(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servTrash()")
+
+	pageData := new(TrashPage)
+	pageData.Title = "Trash"
+	pageData.Articles = blog_db.GetTrash(username)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/trash.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servDeleteArticleAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	key := r.PathValue("key")
+
+	article := blog_db.GetArticle(key)
+	if article == nil {
+		writeJSONError(w, http.StatusNotFound, "Article not found")
+		return
+	}
+	if !canEditArticle(username, article) {
+		logger.InfoContext(r.Context(), "Article delete not allowed", "key", key, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only the article's authors can delete it")
+		return
+	}
+	if blog_db.IsArticleHeld(key) {
+		writeJSONError(w, http.StatusConflict, "Article is on hold")
+		return
+	}
+
+	if err := blog_db.DeleteArticle(key); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Article moved to trash", "key", key, "username", username)
+	audit_db.Record(audit_db.ActionArticleDeleted, username, clientIP(r), article.Title)
+
+	writeJSON(w, http.StatusOK, map[string]string{"key": key})
+}
+
+// Returns the trashed article if username is one of its authors.
+func trashedArticle(w http.ResponseWriter, r *http.Request, username string) *Article {
+	article := blog_db.GetTrashedArticle(r.PathValue("key"))
+	if article == nil {
+		writeJSONError(w, http.StatusNotFound, "Article not in trash")
+		return nil
+	}
+	if !article.HasAuthor(username) {
+		logger.InfoContext(r.Context(), "Trash access not allowed", "key", article.Key(), "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only the article's authors can restore or purge it")
+		return nil
+	}
+	return article
+}
+
+func servRestoreArticleAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	article := trashedArticle(w, r, username)
+	if article == nil {
+		return
+	}
+
+	if err := blog_db.RestoreArticle(article.Key()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Article restored", "key", article.Key(), "username", username)
+	audit_db.Record(audit_db.ActionArticleRestored, username, clientIP(r), article.Title)
+
+	writeJSON(w, http.StatusOK, map[string]string{"key": article.Key()})
+}
+
+func servPurgeArticleAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	article := trashedArticle(w, r, username)
+	if article == nil {
+		return
+	}
+	if blog_db.IsArticleHeld(article.Key()) {
+		writeJSONError(w, http.StatusConflict, "Article is on hold")
+		return
+	}
+
+	if err := blog_db.PurgeArticle(article.Key()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Article purged", "key", article.Key(), "username", username)
+	audit_db.Record(audit_db.ActionArticlePurged, username, clientIP(r), article.Title)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Removes what other modules keep about an article once it is purged, so a
+// later article with the same key starts clean.
+func articlePurged(key string) {
+	if err := activity_db.DeleteRef(ActivityArticle, key); err != nil {
+		logger.Error(err.Error())
+	}
+	if err := bookmark_db.RemoveRef(bookmark_db.KindArticle, key); err != nil {
+		logger.Error(err.Error())
+	}
+	if err := poll_db.DeleteArticlePolls(key); err != nil {
+		logger.Error(err.Error())
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/activity_db"
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+)
+
+// Trips group a date range with an itinerary, a packing checklist, a photo
+// album and journal articles, which are the articles carrying the trip's tag.
+// Trips are stored in ../trips.json. Any member can view a trip; its owner or
+// an admin can change it.
+
+type ItineraryItem struct {
+	Day   string `json:"day"` // 2006-01-02
+	Time  string `json:"time,omitempty"`
+	Title string `json:"title"`
+	Notes string `json:"notes,omitempty"`
+}
+
+type PackingItem struct {
+	Item   string `json:"item"`
+	Packed bool   `json:"packed"`
+}
+
+type Trip struct {
+	ID        int              `json:"id"`
+	Name      string           `json:"name"`
+	Owner     string           `json:"owner"`
+	Start     string           `json:"start"` // 2006-01-02
+	End       string           `json:"end"`
+	Album     string           `json:"album,omitempty"`
+	Tag       string           `json:"tag"`
+	Itinerary []*ItineraryItem `json:"itinerary"`
+	Packing   []*PackingItem   `json:"packing"`
+}
+
+type TripPage struct {
+	Title   string     `json:"title"`
+	Trip    *Trip      `json:"trip,omitempty"`
+	Trips   []*Trip    `json:"trips,omitempty"`
+	Journal []*Article `json:"journal,omitempty"`
+	CanEdit bool       `json:"can_edit"`
+}
+
+const tripsFile = "../trips.json"
+
+var tripsMutex sync.Mutex
+
+func readTrips() []*Trip {
+	trips := make([]*Trip, 0)
+
+	jsonData, err := os.ReadFile(tripsFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error(err.Error())
+		}
+		return trips
+	}
+
+	if err := json.Unmarshal(jsonData, &trips); err != nil {
+		logger.Error(err.Error())
+	}
+
+	return trips
+}
+
+func writeTrips(trips []*Trip) error {
+	jsonData, err := json.MarshalIndent(trips, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(tripsFile, jsonData, 0644)
+}
+
+func getTrip(id int) *Trip {
+	trips := readTrips()
+	if index := slices.IndexFunc(trips, func(trip *Trip) bool { return trip.ID == id }); index >= 0 {
+		return trips[index]
+	}
+	return nil
+}
+
+func canEditTrip(username string, trip *Trip) bool {
+	return len(username) > 0 && (trip.Owner == username || isAdmin(username))
+}
+
+// Checks the trip's dates and album and sorts its itinerary by day and time.
+func (trip *Trip) validate(username string) error {
+	trip.Name = strings.TrimSpace(trip.Name)
+	if len(trip.Name) == 0 {
+		return errors.New("trip name can't be empty")
+	}
+
+	start, err := time.Parse("2006-01-02", trip.Start)
+	if err != nil {
+		return errors.New("invalid start, expected YYYY-MM-DD")
+	}
+	end, err := time.Parse("2006-01-02", trip.End)
+	if err != nil {
+		return errors.New("invalid end, expected YYYY-MM-DD")
+	}
+	if end.Before(start) {
+		return errors.New("trip ends before it starts")
+	}
+
+	if len(trip.Album) > 0 {
+		if strings.ContainsAny(trip.Album, `/\`) || !canViewAlbum(username, trip.Album) {
+			return errors.New("album not found: " + trip.Album)
+		}
+		if info, err := os.Stat(gallery_db.GetAlbumDir(trip.Album)); err != nil || !info.IsDir() {
+			return errors.New("album not found: " + trip.Album)
+		}
+	}
+
+	if trip.Tag = blog_db.NormalizeTag(trip.Tag); len(trip.Tag) == 0 {
+		trip.Tag = blog_db.NormalizeTag(trip.Name)
+	}
+
+	if trip.Itinerary == nil {
+		trip.Itinerary = make([]*ItineraryItem, 0)
+	}
+	for _, item := range trip.Itinerary {
+		if item.Day < trip.Start || item.Day > trip.End {
+			return errors.New("itinerary item outside the trip: " + item.Title)
+		}
+	}
+	sort.SliceStable(trip.Itinerary, func(i, j int) bool {
+		if trip.Itinerary[i].Day != trip.Itinerary[j].Day {
+			return trip.Itinerary[i].Day < trip.Itinerary[j].Day
+		}
+		return trip.Itinerary[i].Time < trip.Itinerary[j].Time
+	})
+
+	if trip.Packing == nil {
+		trip.Packing = make([]*PackingItem, 0)
+	}
+
+	return nil
+}
+
+// Writes the recap article for a finished trip in Markdown: the dates, the
+// itinerary, links to the journal articles and the album.
+func tripRecap(trip *Trip, username string) (*Article, error) {
+	title := "Trip Recap: " + trip.Name
+
+	var recap strings.Builder
+
+	recap.WriteString("**" + trip.Start + " to " + trip.End + "**\n\n")
+
+	if len(trip.Itinerary) > 0 {
+		recap.WriteString("## Itinerary\n\n")
+		for _, item := range trip.Itinerary {
+			recap.WriteString("- **" + strings.TrimSpace(item.Day+" "+item.Time) + "** " + item.Title)
+			if len(item.Notes) > 0 {
+				recap.WriteString(": " + item.Notes)
+			}
+			recap.WriteString("\n")
+		}
+		recap.WriteString("\n")
+	}
+
+	// Earlier recaps carry the trip's tag too, and private entries stay out
+	// of a recap everyone can read
+	journal := slices.DeleteFunc(blog_db.GetArticlesByTag(trip.Tag), func(article *Article) bool { return article.Title == title || article.Private })
+	if len(journal) > 0 {
+		blog_db.SortByDate(journal)
+		recap.WriteString("## Journal\n\n")
+		for i := len(journal) - 1; i >= 0; i-- {
+			recap.WriteString("- " + journal[i].Date + " " + journal[i].Title + " by " + journal[i].Author + "\n")
+		}
+		recap.WriteString("\n[Read the journal](/articles?tag=" + url.QueryEscape(trip.Tag) + ")\n\n")
+	}
+
+	if len(trip.Album) > 0 {
+		recap.WriteString("[See the photos](/album?name=" + url.QueryEscape(trip.Album) + ")\n")
+	}
+
+	article := new(Article)
+	article.Title = title
+	article.Author = username
+	article.Date = time.Now().Format("2006-01-02")
+	article.Tags = []string{trip.Tag}
+	if err := article.SetBody(blog_db.FormatMarkdown, recap.String()); err != nil {
+		return nil, err
+	}
+
+	return article, nil
+}
+
+func servTrips(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servTrips()")
+
+	pageData := new(TripPage)
+	pageData.Title = "Trips"
+	pageData.Trips = readTrips()
+	sort.Slice(pageData.Trips, func(i, j int) bool { return pageData.Trips[i].Start > pageData.Trips[j].Start })
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/trips.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servTrip(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+
+	logger.DebugContext(r.Context(), "servTrip()", "id", id)
+
+	pageData := new(TripPage)
+	if pageData.Trip = getTrip(id); pageData.Trip == nil {
+		http.NotFound(w, r)
+		return
+	}
+	pageData.Title = pageData.Trip.Name
+	pageData.Journal = visibleArticles(username, blog_db.GetArticlesByTag(pageData.Trip.Tag))
+	blog_db.SortByDate(pageData.Journal)
+	pageData.CanEdit = canEditTrip(username, pageData.Trip)
+
+	t, _ := parseTemplates(r, username, "../templates/base.html", "../templates/trip.html")
+	err := t.Execute(w, pageData)
+
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+}
+
+func servTripsAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servTripsAPI()")
+
+	writeJSON(w, http.StatusOK, readTrips())
+}
+
+func servTripAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, _ := strconv.Atoi(r.PathValue("id"))
+
+	logger.DebugContext(r.Context(), "servTripAPI()", "id", id)
+
+	trip := getTrip(id)
+	if trip == nil {
+		writeJSONError(w, http.StatusNotFound, "Trip not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, trip)
+}
+
+func servCreateTripAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	trip := new(Trip)
+	if err := json.NewDecoder(r.Body).Decode(trip); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	trip.Owner = username
+
+	if err := trip.validate(username); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tripsMutex.Lock()
+	defer tripsMutex.Unlock()
+
+	trips := readTrips()
+	trip.ID = 1
+	for _, existing := range trips {
+		trip.ID = max(trip.ID, existing.ID+1)
+	}
+	trips = append(trips, trip)
+
+	if err := writeTrips(trips); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save trip")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Trip created", "trip.ID", trip.ID, "trip.Name", trip.Name, "username", username)
+
+	writeJSON(w, http.StatusCreated, trip)
+}
+
+// Replaces the trip's details, itinerary and packing list. The owner can't be
+// changed.
+func servUpdateTripAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, _ := strconv.Atoi(r.PathValue("id"))
+
+	update := new(Trip)
+	if err := json.NewDecoder(r.Body).Decode(update); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	tripsMutex.Lock()
+	defer tripsMutex.Unlock()
+
+	trips := readTrips()
+	index := slices.IndexFunc(trips, func(trip *Trip) bool { return trip.ID == id })
+	if index < 0 {
+		writeJSONError(w, http.StatusNotFound, "Trip not found")
+		return
+	}
+	if !canEditTrip(username, trips[index]) {
+		writeJSONError(w, http.StatusForbidden, "Only the trip's owner can change it")
+		return
+	}
+
+	update.ID = id
+	update.Owner = trips[index].Owner
+	if err := update.validate(username); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	trips[index] = update
+
+	if err := writeTrips(trips); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save trip")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, update)
+}
+
+func servDeleteTripAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, _ := strconv.Atoi(r.PathValue("id"))
+
+	tripsMutex.Lock()
+	defer tripsMutex.Unlock()
+
+	trips := readTrips()
+	index := slices.IndexFunc(trips, func(trip *Trip) bool { return trip.ID == id })
+	if index < 0 {
+		writeJSONError(w, http.StatusNotFound, "Trip not found")
+		return
+	}
+	if !canEditTrip(username, trips[index]) {
+		writeJSONError(w, http.StatusForbidden, "Only the trip's owner can delete it")
+		return
+	}
+
+	if err := writeTrips(slices.Delete(trips, index, index+1)); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to delete trip")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Trip deleted", "id", id, "username", username)
+
+	writeJSON(w, http.StatusOK, map[string]int{"id": id})
+}
+
+func servTripRecapAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	id, _ := strconv.Atoi(r.PathValue("id"))
+
+	trip := getTrip(id)
+	if trip == nil {
+		writeJSONError(w, http.StatusNotFound, "Trip not found")
+		return
+	}
+	if !canEditTrip(username, trip) {
+		writeJSONError(w, http.StatusForbidden, "Only the trip's owner can write its recap")
+		return
+	}
+	if time.Now().Format("2006-01-02") <= trip.End {
+		writeJSONError(w, http.StatusBadRequest, "The trip hasn't ended yet")
+		return
+	}
+
+	article, err := tripRecap(trip, username)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if storageCritical() {
+		writeJSONError(w, http.StatusInsufficientStorage, "Storage is critically low, please try again later")
+		return
+	}
+
+	if err := blog_db.CreateArticle(article); err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	audit_db.Record(audit_db.ActionArticleCreated, username, clientIP(r), article.Title)
+	activity_db.Record(ActivityArticle, username, article.Title, article.Key(), 0)
+
+	writeJSON(w, http.StatusCreated, article)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jeffereydecker/blazemarker/activity_db"
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blog_db"
+	"github.com/jeffereydecker/blazemarker/bookmark_db"
+	"github.com/jeffereydecker/blazemarker/challenge_db"
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+	"github.com/jeffereydecker/blazemarker/inventory_db"
+	"github.com/jeffereydecker/blazemarker/list_db"
+	"github.com/jeffereydecker/blazemarker/location_db"
+	"github.com/jeffereydecker/blazemarker/poll_db"
+	"github.com/jeffereydecker/blazemarker/recipe_db"
+	"gorm.io/gorm"
+)
+
+// Disabled users are listed one username per line in ../blaze_auth/disabled.
+// They keep their credential and content but can no longer log in. Deleted
+// users are listed in ../blaze_auth/deleted so their names can't be registered
+// again and inherit what is still recorded against them.
+const (
+	disabledFile = "../blaze_auth/disabled"
+	deletedFile  = "../blaze_auth/deleted"
+)
+
+// Articles and other content of deleted users are credited to this name when
+// they are anonymized rather than reassigned. It can't collide with a
+// username.
+const anonymizedAuthor = "Former member"
+
+type User struct {
+	Username string `json:"username"`
+	Admin    bool   `json:"admin"`
+	Disabled bool   `json:"disabled"`
+}
+
+func readUsernames(filename string) ([]string, error) {
+	usernames := make([]string, 0)
+
+	f, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return usernames, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if name, _, _ := strings.Cut(line, ":"); len(name) > 0 && !strings.HasPrefix(name, "#") {
+			usernames = append(usernames, name)
+		}
+	}
+
+	return usernames, scanner.Err()
+}
+
+func isDisabled(username string) bool {
+	disabled, err := readUsernames(disabledFile)
+	if err != nil {
+		logger.Error(err.Error())
+		return false
+	}
+
+	return slices.Contains(disabled, username)
+}
+
+func setDisabled(username string, disable bool) error {
+	registrationMutex.Lock()
+	defer registrationMutex.Unlock()
+
+	disabled, err := readUsernames(disabledFile)
+	if err != nil {
+		return err
+	}
+
+	disabled = slices.DeleteFunc(disabled, func(name string) bool { return name == username })
+	if disable {
+		disabled = append(disabled, username)
+	}
+
+	return os.WriteFile(disabledFile, []byte(strings.Join(append(disabled, ""), "\n")), 0600)
+}
+
+func isDeleted(username string) (bool, error) {
+	deleted, err := readUsernames(deletedFile)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(deleted, username), nil
+}
+
+// Lists the members who can still log in, leaving out disabled and deleted
+// users, for pickers and for checking the names members enter.
+func activeMembers() ([]string, error) {
+	usernames, err := readUsernames(htpasswdFile)
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := readUsernames(disabledFile)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := readUsernames(deletedFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(usernames, func(name string) bool {
+		return slices.Contains(disabled, name) || slices.Contains(deleted, name)
+	}), nil
+}
+
+// Hands a deleted user's database records over in one transaction, so a
+// failure leaves them all with the user and the delete can be retried. Their
+// lists, recipes, polls, challenges, activity and inventory are credited to
+// another name; check-ins, bookmarks, ballots and challenge progress are
+// personal and are removed or anonymized instead.
+func reassignRecords(from string, to string) error {
+	gdb := blaze_db.GetDB()
+	if gdb == nil {
+		return errors.New("database not available")
+	}
+
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		for _, reassign := range []func(*gorm.DB, string, string) error{
+			list_db.ReassignUser,
+			recipe_db.ReassignUser,
+			poll_db.ReassignUser,
+			challenge_db.ReassignUser,
+			activity_db.ReassignUser,
+			inventory_db.ReassignUser,
+		} {
+			if err := reassign(tx, from, to); err != nil {
+				return err
+			}
+		}
+
+		if err := location_db.DeleteUser(tx, from); err != nil {
+			return err
+		}
+		return bookmark_db.DeleteUser(tx, from)
+	})
+}
+
+// Rewrites a file of usernames without the user, keeping comments.
+func removeUsername(filename string, username string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	kept := slices.DeleteFunc(slices.Clone(lines), func(line string) bool {
+		name, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+		return name == username
+	})
+	if len(kept) == len(lines) {
+		return nil
+	}
+
+	return os.WriteFile(filename, []byte(strings.Join(kept, "")), 0600)
+}
+
+// Rewrites .htpasswd without the user's credential, drops them from the admin
+// and disabled lists and records the name as deleted.
+func removeCredential(username string) error {
+	registrationMutex.Lock()
+	defer registrationMutex.Unlock()
+
+	deleted, err := isDeleted(username)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		f, err := os.OpenFile(deletedFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(username + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, filename := range []string{adminsFile, disabledFile, htpasswdFile} {
+		if err := removeUsername(filename, username); err != nil {
+			return err
+		}
+	}
+	forgetCredentials(username)
+
+	return nil
+}
+
+func servUsersAPI(w http.ResponseWriter, r *http.Request) {
+	if ok, _ := adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servUsersAPI()")
+
+	usernames, err := readUsernames(htpasswdFile)
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to read users")
+		return
+	}
+
+	users := make([]*User, 0, len(usernames))
+	for _, username := range usernames {
+		users = append(users, &User{Username: username, Admin: isAdmin(username), Disabled: isDisabled(username)})
+	}
+
+	writeJSON(w, http.StatusOK, users)
+}
+
+// Looks up the user named in the path, refusing to act on the caller's own
+// account so an admin can't lock themselves out.
+func targetUser(w http.ResponseWriter, r *http.Request, username string) (string, bool) {
+	name := r.PathValue("name")
+
+	if name == username {
+		writeJSONError(w, http.StatusBadRequest, "You can't change your own account")
+		return name, false
+	}
+
+	exists, err := userExists(name)
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to read users")
+		return name, false
+	}
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "User not found")
+		return name, false
+	}
+
+	return name, true
+}
+
+func servDisableUserAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	name, ok := targetUser(w, r, username)
+	if !ok {
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servDisableUserAPI()", "name", name)
+
+	if err := setDisabled(name, true); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to disable user")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "User disabled", "username", username, "name", name)
+	audit_db.Record(audit_db.ActionUserDisabled, username, clientIP(r), name)
+
+	writeJSON(w, http.StatusOK, &User{Username: name, Admin: isAdmin(name), Disabled: true})
+}
+
+func servEnableUserAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	name, ok := targetUser(w, r, username)
+	if !ok {
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servEnableUserAPI()", "name", name)
+
+	if err := setDisabled(name, false); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to enable user")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "User enabled", "username", username, "name", name)
+	audit_db.Record(audit_db.ActionUserEnabled, username, clientIP(r), name)
+
+	writeJSON(w, http.StatusOK, &User{Username: name, Admin: isAdmin(name), Disabled: false})
+}
+
+// Deletes a user after handing their content over. ?reassign=<username> gives
+// their articles, albums and records to another member, otherwise articles
+// and records are credited to anonymizedAuthor and albums are left to admins.
+// Content is moved before the credential is removed so a failed delete can
+// simply be retried. The name is kept on the deleted list so nobody can
+// register it and pick up what is still recorded under it, such as audit and
+// guestbook history.
+func servDeleteUserAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = adminAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed adminAuth attempt")
+		return
+	}
+
+	name, ok := targetUser(w, r, username)
+	if !ok {
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servDeleteUserAPI()", "name", name)
+
+	articleAuthor, albumOwner := anonymizedAuthor, ""
+	if reassign := r.URL.Query().Get("reassign"); len(reassign) > 0 {
+		if exists, err := userExists(reassign); err != nil || !exists || reassign == name {
+			writeJSONError(w, http.StatusBadRequest, "Unknown user to reassign to: "+reassign)
+			return
+		}
+		articleAuthor, albumOwner = reassign, reassign
+	}
+
+	articles, renamed, err := blog_db.ReassignArticles(name, articleAuthor)
+	for from, to := range renamed {
+		articleRenamed(r, from, to)
+	}
+	if err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to reassign articles: "+err.Error())
+		return
+	}
+
+	albums, err := gallery_db.ReassignAlbums(name, albumOwner)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Unable to reassign albums")
+		return
+	}
+
+	if err := reassignRecords(name, articleAuthor); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to reassign records")
+		return
+	}
+
+	if err := removeCredential(name); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "Unable to delete user")
+		return
+	}
+
+	logger.InfoContext(r.Context(), "User deleted", "username", username, "name", name, "articles", articles, "albums", albums, "reassignedTo", articleAuthor)
+	audit_db.Record(audit_db.ActionUserDeleted, username, clientIP(r), name+" content to "+articleAuthor)
+
+	writeJSON(w, http.StatusOK, map[string]any{"username": name, "articles": articles, "albums": albums, "reassigned_to": articleAuthor})
+}
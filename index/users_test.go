@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/jeffereydecker/blazemarker/bookmark_db"
+	"github.com/jeffereydecker/blazemarker/list_db"
+	"github.com/jeffereydecker/blazemarker/location_db"
+	"github.com/jeffereydecker/blazemarker/poll_db"
+)
+
+func TestReassignRecords(t *testing.T) {
+	list := &list_db.List{Name: "Groceries", CreatedBy: "dave"}
+	if err := list_db.CreateList(list); err != nil {
+		t.Fatal(err)
+	}
+	if err := list_db.AddItem(list.ID, &list_db.Item{Text: "Milk", AddedBy: "dave", Assignee: "erin"}); err != nil {
+		t.Fatal(err)
+	}
+	poll := &poll_db.Poll{Question: "Where to?", Options: []string{"Beach", "Hills"}, CreatedBy: "dave"}
+	if err := poll_db.CreatePoll(poll); err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"dave", "erin"} {
+		if err := poll_db.CastVote(poll, username, []int{0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := bookmark_db.AddBookmark("dave", "article", "2024-07-01Beachdave"); err != nil {
+		t.Fatal(err)
+	}
+	if err := location_db.AddCheckIn(&location_db.CheckIn{Username: "dave", Lat: 51.5, Lon: -0.1, Label: "Home"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recipes and challenges were never saved, so their tables don't exist
+	if err := reassignRecords("dave", "erin"); err != nil {
+		t.Fatalf("reassignRecords: %v", err)
+	}
+
+	if list := list_db.GetList(list.ID); list == nil || list.CreatedBy != "erin" {
+		t.Errorf("list = %+v, want created by erin", list)
+	}
+	if items := list_db.GetItems(list.ID); len(items) != 1 || items[0].AddedBy != "erin" {
+		t.Errorf("items = %+v, want one added by erin", items)
+	}
+	if poll := poll_db.GetPoll(poll.ID); poll == nil || poll.CreatedBy != "erin" {
+		t.Errorf("poll = %+v, want created by erin", poll)
+	}
+	if results := poll_db.GetResults(poll, "erin"); results.Voters != 2 || results.Counts[0] != 2 || !slices.Equal(results.Mine, []int{0}) {
+		t.Errorf("results = %+v, want both ballots kept and erin's own", results)
+	}
+	if bookmarks := bookmark_db.GetBookmarks("dave"); len(bookmarks) != 0 {
+		t.Errorf("bookmarks = %+v, want none", bookmarks)
+	}
+	for _, checkIn := range location_db.GetVisibleCheckIns("dave", list.Created.AddDate(0, 0, -1)) {
+		if checkIn.Username == "dave" {
+			t.Errorf("check-in %+v kept", checkIn)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/jeffereydecker/blazemarker/audit_db"
+	"github.com/jeffereydecker/blazemarker/gallery_db"
+)
+
+// Photo edits keep the previous original as a version that can be restored.
+// Only admins and the album owner may edit.
+
+const maxReplacementSize = 64 << 20
+
+type PhotoEdit = gallery_db.PhotoEdit
+
+// Authenticates the caller and checks they may edit photos in the album named
+// in the path.
+func photoEditAuth(w http.ResponseWriter, r *http.Request) (bool, string, string, string) {
+	ok, username := basicAuth(w, r)
+	if !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return false, username, "", ""
+	}
+
+	albumName := r.PathValue("album")
+	photoName := r.PathValue("photo")
+
+	if !canManageAlbum(username, albumName) {
+		logger.InfoContext(r.Context(), "Photo edit not allowed", "albumName", albumName, "photoName", photoName, "username", username)
+		writeJSONError(w, http.StatusForbidden, "Only admins and the album owner can edit photos")
+		return false, username, albumName, photoName
+	}
+
+	return true, username, albumName, photoName
+}
+
+func writePhotoVersions(w http.ResponseWriter, albumName string, photoName string) {
+	versions, err := gallery_db.GetPhotoVersions(albumName, photoName)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"album":    albumName,
+		"photo":    photoName,
+		"versions": versions,
+	})
+}
+
+func servPhotoVersionsAPI(w http.ResponseWriter, r *http.Request) {
+	var username string
+	var ok bool
+
+	if ok, username = basicAuth(w, r); !ok {
+		logger.InfoContext(r.Context(), "Failed baseAuth attempt")
+		return
+	}
+
+	albumName := r.PathValue("album")
+	photoName := r.PathValue("photo")
+
+	logger.DebugContext(r.Context(), "servPhotoVersionsAPI()", "album", albumName, "photo", photoName)
+
+	if !canViewAlbum(username, albumName) {
+		writeJSONError(w, http.StatusNotFound, "Album not found")
+		return
+	}
+
+	writePhotoVersions(w, albumName, photoName)
+}
+
+func servEditPhotoAPI(w http.ResponseWriter, r *http.Request) {
+	ok, username, albumName, photoName := photoEditAuth(w, r)
+	if !ok {
+		return
+	}
+
+	edit := new(PhotoEdit)
+	if err := json.NewDecoder(r.Body).Decode(edit); err != nil {
+		logger.ErrorContext(r.Context(), err.Error())
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servEditPhotoAPI()", "albumName", albumName, "photoName", photoName, "edit.Op", edit.Op)
+
+	if storageCritical() {
+		writeJSONError(w, http.StatusInsufficientStorage, "Storage is critically low, please try again later")
+		return
+	}
+
+	if err := gallery_db.EditPhoto(albumName, photoName, username, edit); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Photo edited", "albumName", albumName, "photoName", photoName, "edit.Op", edit.Op, "username", username)
+	audit_db.Record(audit_db.ActionPhotoEdited, username, clientIP(r), albumName+"/"+photoName+" "+edit.Op)
+
+	writePhotoVersions(w, albumName, photoName)
+}
+
+// The request body is the replacement JPEG.
+func servReplacePhotoAPI(w http.ResponseWriter, r *http.Request) {
+	ok, username, albumName, photoName := photoEditAuth(w, r)
+	if !ok {
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servReplacePhotoAPI()", "albumName", albumName, "photoName", photoName)
+
+	if storageCritical() {
+		writeJSONError(w, http.StatusInsufficientStorage, "Storage is critically low, please try again later")
+		return
+	}
+
+	if err := gallery_db.ReplacePhoto(albumName, photoName, username, http.MaxBytesReader(w, r.Body, maxReplacementSize)); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Photo replaced", "albumName", albumName, "photoName", photoName, "username", username)
+	audit_db.Record(audit_db.ActionPhotoEdited, username, clientIP(r), albumName+"/"+photoName+" replace")
+
+	writePhotoVersions(w, albumName, photoName)
+}
+
+func servRevertPhotoAPI(w http.ResponseWriter, r *http.Request) {
+	ok, username, albumName, photoName := photoEditAuth(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid version")
+		return
+	}
+
+	logger.DebugContext(r.Context(), "servRevertPhotoAPI()", "albumName", albumName, "photoName", photoName, "version", version)
+
+	if err := gallery_db.RevertPhoto(albumName, photoName, version, username); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Photo reverted", "albumName", albumName, "photoName", photoName, "version", version, "username", username)
+	audit_db.Record(audit_db.ActionPhotoEdited, username, clientIP(r), albumName+"/"+photoName+" revert to version "+strconv.Itoa(version))
+
+	writePhotoVersions(w, albumName, photoName)
+}
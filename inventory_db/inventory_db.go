@@ -0,0 +1,205 @@
+package inventory_db
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+// The household inventory: what the family owns, where it is kept and when
+// its warranty runs out. Photo is the URL of an uploaded image and Documents
+// link receipts and manuals, either site paths or https URLs. Dates are
+// 2006-01-02 and may be left empty.
+type Item struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	Name            string    `json:"name"`
+	Location        string    `json:"location,omitempty"`
+	PurchaseDate    string    `json:"purchase_date,omitempty"`
+	WarrantyExpires string    `gorm:"index" json:"warranty_expires,omitempty"`
+	Photo           string    `json:"photo,omitempty"`
+	Documents       []string  `gorm:"serializer:json" json:"documents,omitempty"`
+	Notes           string    `json:"notes,omitempty"`
+	Owner           string    `gorm:"index" json:"owner"`
+	Created         time.Time `json:"created"`
+	Updated         time.Time `json:"updated"`
+}
+
+// Items would otherwise share the items table of list_db.
+func (Item) TableName() string {
+	return "inventory_items"
+}
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&Item{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+func isLink(link string) bool {
+	return strings.HasPrefix(link, "/") || strings.HasPrefix(link, "https://")
+}
+
+func validateItem(item *Item) error {
+	item.Name = strings.TrimSpace(item.Name)
+	if len(item.Name) == 0 {
+		return errors.New("item name can't be empty")
+	}
+	item.Location = strings.TrimSpace(item.Location)
+	item.Notes = strings.TrimSpace(item.Notes)
+
+	for _, date := range []string{item.PurchaseDate, item.WarrantyExpires} {
+		if _, err := time.Parse("2006-01-02", date); len(date) > 0 && err != nil {
+			return errors.New("dates must look like 2006-01-02: " + date)
+		}
+	}
+
+	if len(item.Photo) > 0 && !isLink(item.Photo) {
+		return errors.New("photo must be a site path or an https URL")
+	}
+	documents := make([]string, 0, len(item.Documents))
+	for _, document := range item.Documents {
+		if document = strings.TrimSpace(document); len(document) == 0 {
+			continue
+		}
+		if !isLink(document) {
+			return errors.New("documents must be site paths or https URLs")
+		}
+		documents = append(documents, document)
+	}
+	item.Documents = documents
+
+	return nil
+}
+
+// Returns the items whose name, location or notes contain query, or all of
+// them when it is empty, by name.
+func SearchItems(query string) []*Item {
+	items := make([]*Item, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return items
+	}
+
+	tx := gdb.Order("name")
+	if query = strings.ToLower(strings.TrimSpace(query)); len(query) > 0 {
+		like := "%" + query + "%"
+		tx = tx.Where("LOWER(name) LIKE ? OR LOWER(location) LIKE ? OR LOWER(notes) LIKE ?", like, like, like)
+	}
+	if err := tx.Find(&items).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return items
+}
+
+// Returns the items whose warranty runs out between day and within days
+// later, soonest first.
+func GetExpiringItems(day time.Time, within int) []*Item {
+	items := make([]*Item, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return items
+	}
+
+	err := gdb.Where("warranty_expires >= ? AND warranty_expires <= ?", day.Format("2006-01-02"), day.AddDate(0, 0, within).Format("2006-01-02")).
+		Order("warranty_expires").Find(&items).Error
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	return items
+}
+
+func GetItem(id uint) *Item {
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	item := new(Item)
+	if err := gdb.First(item, id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	return item
+}
+
+func CreateItem(item *Item) error {
+	if err := validateItem(item); err != nil {
+		return err
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("inventory database not available")
+	}
+
+	item.ID = 0
+	item.Created = time.Now()
+	item.Updated = item.Created
+
+	return gdb.Create(item).Error
+}
+
+func UpdateItem(item *Item) error {
+	if err := validateItem(item); err != nil {
+		return err
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("inventory database not available")
+	}
+
+	item.Updated = time.Now()
+
+	return gdb.Save(item).Error
+}
+
+func DeleteItem(id uint) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("inventory database not available")
+	}
+
+	return gdb.Delete(&Item{}, id).Error
+}
+
+// Credits a deleted member's items to another name, as part of the caller's
+// transaction.
+func ReassignUser(tx *gorm.DB, from string, to string) error {
+	// Nothing to reassign before the first item is saved
+	if !tx.Migrator().HasTable(&Item{}) {
+		return nil
+	}
+
+	return tx.Model(&Item{}).Where("owner = ?", from).Update("owner", to).Error
+}
@@ -0,0 +1,58 @@
+package inventory_db
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestValidateItem(t *testing.T) {
+	tests := []struct {
+		name  string
+		item  Item
+		valid bool
+	}{
+		{"name only", Item{Name: " Dishwasher "}, true},
+		{"every field", Item{Name: "Dishwasher", PurchaseDate: "2024-03-01", WarrantyExpires: "2026-03-01", Photo: "/media/dishwasher.jpg", Documents: []string{"https://example.com/manual.pdf", "", "/media/receipt.jpg"}}, true},
+		{"no name", Item{Name: "  "}, false},
+		{"bad purchase date", Item{Name: "Dishwasher", PurchaseDate: "01/03/2024"}, false},
+		{"bad warranty date", Item{Name: "Dishwasher", WarrantyExpires: "2026-02-30"}, false},
+		{"photo over http", Item{Name: "Dishwasher", Photo: "http://example.com/dishwasher.jpg"}, false},
+		{"script document", Item{Name: "Dishwasher", Documents: []string{"javascript:alert(1)"}}, false},
+	}
+
+	for _, test := range tests {
+		item := test.item
+		if err := validateItem(&item); test.valid != (err == nil) {
+			t.Errorf("%s: validateItem error = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+
+	item := Item{Name: " Dishwasher ", Documents: []string{" /media/receipt.jpg ", ""}}
+	if err := validateItem(&item); err != nil || item.Name != "Dishwasher" || !slices.Equal(item.Documents, []string{"/media/receipt.jpg"}) {
+		t.Errorf("validateItem = %v, %+v, want trimmed name and documents", err, item)
+	}
+}
+
+func TestGetExpiringItems(t *testing.T) {
+	day := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, item := range []*Item{
+		{Name: "Expired", WarrantyExpires: "2026-10-15"},
+		{Name: "Today", WarrantyExpires: "2026-10-16"},
+		{Name: "Next month", WarrantyExpires: "2026-11-15"},
+		{Name: "Next year", WarrantyExpires: "2027-10-16"},
+		{Name: "No warranty"},
+	} {
+		if err := CreateItem(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := make([]string, 0)
+	for _, item := range GetExpiringItems(day, 30) {
+		names = append(names, item.Name)
+	}
+	if want := []string{"Today", "Next month"}; !slices.Equal(names, want) {
+		t.Errorf("GetExpiringItems = %q, want %q", names, want)
+	}
+}
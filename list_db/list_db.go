@@ -0,0 +1,303 @@
+package list_db
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+// Shopping and todo lists. Shared lists can be read and changed by every
+// member, the others only by whoever created them. Items can be assigned to
+// a member and given a due day.
+type List struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Shared    bool      `json:"shared"`
+	CreatedBy string    `json:"created_by"`
+	Created   time.Time `json:"created"`
+}
+
+const (
+	KindShopping = "shopping"
+	KindTodo     = "todo"
+)
+
+type Item struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	ListID    uint       `gorm:"index" json:"list_id"`
+	Text      string     `json:"text"`
+	Checked   bool       `json:"checked"`
+	CheckedBy string     `json:"checked_by,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	Assignee  string     `json:"assignee,omitempty"`
+	Due       string     `json:"due,omitempty"` // 2006-01-02
+	AddedBy   string     `json:"added_by"`
+	Created   time.Time  `json:"created"`
+}
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&List{}, &Item{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+func IsValidKind(kind string) bool {
+	return kind == KindShopping || kind == KindTodo
+}
+
+func (list *List) CanAccess(username string) bool {
+	return len(username) > 0 && (list.Shared || list.CreatedBy == username)
+}
+
+// Returns the lists username can see, shared ones first.
+func GetLists(username string) []*List {
+	lists := make([]*List, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return lists
+	}
+
+	if err := gdb.Where("shared = ? OR created_by = ?", true, username).Order("shared desc, name").Find(&lists).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return lists
+}
+
+func GetList(id uint) *List {
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	list := new(List)
+	if err := gdb.First(list, id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	return list
+}
+
+func CreateList(list *List) error {
+	list.Name = strings.TrimSpace(list.Name)
+	if len(list.Name) == 0 {
+		return errors.New("list name can't be empty")
+	}
+	if len(list.Kind) == 0 {
+		list.Kind = KindShopping
+	}
+	if !IsValidKind(list.Kind) {
+		return errors.New("invalid list kind: " + list.Kind)
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("list database not available")
+	}
+
+	list.ID = 0
+	list.Created = time.Now()
+
+	return gdb.Create(list).Error
+}
+
+func DeleteList(id uint) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("list database not available")
+	}
+
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("list_id = ?", id).Delete(&Item{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&List{}, id).Error
+	})
+}
+
+// Returns the list's items, open ones first.
+func GetItems(listID uint) []*Item {
+	items := make([]*Item, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return items
+	}
+
+	if err := gdb.Where("list_id = ?", listID).Order("checked, created").Find(&items).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return items
+}
+
+func GetItem(listID uint, id uint) *Item {
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	item := new(Item)
+	if err := gdb.Where("list_id = ?", listID).First(item, id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	return item
+}
+
+func validateItem(item *Item) error {
+	item.Text = strings.TrimSpace(item.Text)
+	if len(item.Text) == 0 {
+		return errors.New("item text can't be empty")
+	}
+	if len(item.Due) > 0 {
+		if _, err := time.Parse("2006-01-02", item.Due); err != nil {
+			return errors.New("invalid due day, expected YYYY-MM-DD")
+		}
+	}
+	return nil
+}
+
+func AddItems(listID uint, items []*Item) error {
+	for _, item := range items {
+		if err := validateItem(item); err != nil {
+			return err
+		}
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("list database not available")
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for _, item := range items {
+		item.ID = 0
+		item.ListID = listID
+		item.Checked = false
+		item.CheckedBy = ""
+		item.CheckedAt = nil
+		item.Created = now
+	}
+
+	return gdb.Create(&items).Error
+}
+
+func AddItem(listID uint, item *Item) error {
+	return AddItems(listID, []*Item{item})
+}
+
+// Saves the item's text, assignee, due day and checked state. username is
+// recorded as who checked it.
+func UpdateItem(item *Item, username string) error {
+	if err := validateItem(item); err != nil {
+		return err
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("list database not available")
+	}
+
+	if !item.Checked {
+		item.CheckedBy = ""
+		item.CheckedAt = nil
+	} else if item.CheckedAt == nil {
+		now := time.Now()
+		item.CheckedBy = username
+		item.CheckedAt = &now
+	}
+
+	return gdb.Save(item).Error
+}
+
+func DeleteItem(listID uint, id uint) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("list database not available")
+	}
+
+	return gdb.Where("list_id = ?", listID).Delete(&Item{}, id).Error
+}
+
+// Removes the checked items, e.g. once the shopping is done.
+func ClearChecked(listID uint) (int64, error) {
+	gdb := getDB()
+	if gdb == nil {
+		return 0, errors.New("list database not available")
+	}
+
+	result := gdb.Where("list_id = ? AND checked = ?", listID, true).Delete(&Item{})
+	return result.RowsAffected, result.Error
+}
+
+// Returns the open items assigned to username that are due by day.
+func GetDueItems(username string, day time.Time) []*Item {
+	items := make([]*Item, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return items
+	}
+
+	err := gdb.Where("assignee = ? AND checked = ? AND due <> ? AND due <= ?", username, false, "", day.Format("2006-01-02")).Order("due").Find(&items).Error
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	return items
+}
+
+// Credits a deleted member's lists, items, check offs and assignments to
+// another name, as part of the caller's transaction.
+func ReassignUser(tx *gorm.DB, from string, to string) error {
+	// Nothing to reassign before the first list is saved
+	if !tx.Migrator().HasTable(&List{}) {
+		return nil
+	}
+
+	if err := tx.Model(&List{}).Where("created_by = ?", from).Update("created_by", to).Error; err != nil {
+		return err
+	}
+	for _, column := range []string{"added_by", "checked_by", "assignee"} {
+		if err := tx.Model(&Item{}).Where(column+" = ?", from).Update(column, to).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package location_db
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+// Location check-ins posted from the PWA. Members only see each other's
+// check-ins while they are recent, and only from members who turned sharing
+// on; everyone always sees their own.
+type CheckIn struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	Username string    `gorm:"index" json:"username"`
+	Lat      float64   `json:"lat"`
+	Lon      float64   `json:"lon"`
+	Label    string    `json:"label"`
+	Note     string    `json:"note,omitempty"`
+	Created  time.Time `gorm:"index" json:"created"`
+}
+
+// Sharing is off until the member turns it on.
+type Settings struct {
+	Username string `gorm:"primaryKey" json:"username"`
+	Share    bool   `json:"share"`
+}
+
+const CheckInVisibility = 7 * 24 * time.Hour
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&CheckIn{}, &Settings{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+func GetSettings(username string) *Settings {
+	settings := &Settings{Username: username}
+
+	gdb := getDB()
+	if gdb == nil {
+		return settings
+	}
+
+	if err := gdb.Where("username = ?", username).First(settings).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Error(err.Error())
+	}
+
+	return settings
+}
+
+func SaveSettings(settings *Settings) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("location database not available")
+	}
+
+	return gdb.Save(settings).Error
+}
+
+func AddCheckIn(checkIn *CheckIn) error {
+	if checkIn.Lat < -90 || checkIn.Lat > 90 || checkIn.Lon < -180 || checkIn.Lon > 180 {
+		return errors.New("invalid coordinates")
+	}
+	checkIn.Label = strings.TrimSpace(checkIn.Label)
+	checkIn.Note = strings.TrimSpace(checkIn.Note)
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("location database not available")
+	}
+
+	checkIn.ID = 0
+	checkIn.Created = time.Now()
+
+	return gdb.Create(checkIn).Error
+}
+
+// Returns the viewer's own check-ins since since and the recent check-ins of
+// members who share them, newest first.
+func GetVisibleCheckIns(viewer string, since time.Time) []*CheckIn {
+	checkIns := make([]*CheckIn, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return checkIns
+	}
+
+	recent := time.Now().Add(-CheckInVisibility)
+	if since.After(recent) {
+		recent = since
+	}
+
+	sharing := gdb.Model(&Settings{}).Select("username").Where("share = ?", true)
+	err := gdb.Where("(username = ? AND created >= ?) OR (username IN (?) AND created >= ?)", viewer, since, sharing, recent).
+		Order("created desc").Find(&checkIns).Error
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	return checkIns
+}
+
+func DeleteCheckIn(id uint, username string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("location database not available")
+	}
+
+	result := gdb.Where("username = ?", username).Delete(&CheckIn{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("check-in not found")
+	}
+
+	return nil
+}
+
+// Check-ins say where a member was, so they aren't handed to anyone else:
+// a deleted member's check-ins and sharing setting are removed, as part of
+// the caller's transaction.
+func DeleteUser(tx *gorm.DB, username string) error {
+	// Nothing to delete before the first check-in is saved
+	if !tx.Migrator().HasTable(&CheckIn{}) {
+		return nil
+	}
+
+	if err := tx.Where("username = ?", username).Delete(&CheckIn{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("username = ?", username).Delete(&Settings{}).Error
+}
@@ -0,0 +1,284 @@
+package poll_db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+// Polls stand alone or are attached to an article by its key. Each member
+// has one ballot per poll: a single option, or several when the poll allows
+// it. Voting again replaces the ballot, until the poll closes.
+type Poll struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Question  string     `json:"question"`
+	Options   []string   `gorm:"serializer:json" json:"options"`
+	Multi     bool       `json:"multi"`
+	Closes    *time.Time `json:"closes,omitempty"`
+	Article   string     `gorm:"index" json:"article,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	Created   time.Time  `json:"created"`
+}
+
+type Vote struct {
+	PollID   uint      `gorm:"primaryKey" json:"poll_id"`
+	Username string    `gorm:"primaryKey" json:"username"`
+	Option   int       `gorm:"primaryKey" json:"option"`
+	Voted    time.Time `json:"voted"`
+}
+
+type Results struct {
+	Counts []int `json:"counts"` // Per option
+	Voters int   `json:"voters"`
+	Mine   []int `json:"mine"` // The viewer's options
+}
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&Poll{}, &Vote{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+func (poll *Poll) IsClosed(now time.Time) bool {
+	return poll.Closes != nil && !now.Before(*poll.Closes)
+}
+
+func (results *Results) Chose(option int) bool {
+	return slices.Contains(results.Mine, option)
+}
+
+// The option's share of the voters, as a whole percentage.
+func (results *Results) Percent(option int) int {
+	if results.Voters == 0 || option < 0 || option >= len(results.Counts) {
+		return 0
+	}
+	return results.Counts[option] * 100 / results.Voters
+}
+
+// Returns the standalone polls, or those attached to article, newest first.
+func GetPolls(article string) []*Poll {
+	polls := make([]*Poll, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return polls
+	}
+
+	if err := gdb.Where("article = ?", article).Order("created desc").Find(&polls).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return polls
+}
+
+func GetPoll(id uint) *Poll {
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	poll := new(Poll)
+	if err := gdb.First(poll, id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	return poll
+}
+
+func CreatePoll(poll *Poll) error {
+	poll.Question = strings.TrimSpace(poll.Question)
+	if len(poll.Question) == 0 {
+		return errors.New("poll question can't be empty")
+	}
+
+	options := make([]string, 0, len(poll.Options))
+	for _, option := range poll.Options {
+		if option = strings.TrimSpace(option); len(option) > 0 && !slices.Contains(options, option) {
+			options = append(options, option)
+		}
+	}
+	if len(options) < 2 {
+		return errors.New("a poll needs at least two options")
+	}
+	poll.Options = options
+
+	if poll.Closes != nil && poll.Closes.Before(time.Now()) {
+		return errors.New("poll can't close in the past")
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("poll database not available")
+	}
+
+	poll.ID = 0
+	poll.Created = time.Now()
+
+	return gdb.Create(poll).Error
+}
+
+// Closes the poll now.
+func ClosePoll(poll *Poll) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("poll database not available")
+	}
+
+	now := time.Now()
+	poll.Closes = &now
+
+	return gdb.Save(poll).Error
+}
+
+func DeletePoll(id uint) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("poll database not available")
+	}
+
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("poll_id = ?", id).Delete(&Vote{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Poll{}, id).Error
+	})
+}
+
+// Moves the polls attached to an article over to its new key.
+func RenameArticle(from string, to string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("poll database not available")
+	}
+
+	return gdb.Model(&Poll{}).Where("article = ?", from).Update("article", to).Error
+}
+
+// Deletes the polls attached to an article, with their votes.
+func DeleteArticlePolls(article string) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("poll database not available")
+	}
+
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		ids := tx.Model(&Poll{}).Select("id").Where("article = ?", article)
+		if err := tx.Where("poll_id IN (?)", ids).Delete(&Vote{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("article = ?", article).Delete(&Poll{}).Error
+	})
+}
+
+// Replaces username's ballot with options. No options retracts it.
+func CastVote(poll *Poll, username string, options []int) error {
+	if poll.IsClosed(time.Now()) {
+		return errors.New("poll is closed")
+	}
+	if len(options) > 1 && !poll.Multi {
+		return errors.New("only one option can be chosen")
+	}
+
+	votes := make([]*Vote, 0, len(options))
+	now := time.Now()
+	for _, option := range options {
+		if option < 0 || option >= len(poll.Options) {
+			return errors.New("invalid option")
+		}
+		if slices.ContainsFunc(votes, func(vote *Vote) bool { return vote.Option == option }) {
+			continue
+		}
+		votes = append(votes, &Vote{PollID: poll.ID, Username: username, Option: option, Voted: now})
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("poll database not available")
+	}
+
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("poll_id = ? AND username = ?", poll.ID, username).Delete(&Vote{}).Error; err != nil {
+			return err
+		}
+		if len(votes) == 0 {
+			return nil
+		}
+		return tx.Create(&votes).Error
+	})
+}
+
+func GetResults(poll *Poll, viewer string) *Results {
+	results := &Results{Counts: make([]int, len(poll.Options)), Mine: make([]int, 0)}
+
+	gdb := getDB()
+	if gdb == nil {
+		return results
+	}
+
+	votes := make([]*Vote, 0)
+	if err := gdb.Where("poll_id = ?", poll.ID).Find(&votes).Error; err != nil {
+		logger.Error(err.Error())
+		return results
+	}
+
+	voters := make(map[string]bool)
+	for _, vote := range votes {
+		if vote.Option < len(results.Counts) {
+			results.Counts[vote.Option] = results.Counts[vote.Option] + 1
+		}
+		if vote.Username == viewer {
+			results.Mine = append(results.Mine, vote.Option)
+		}
+		voters[vote.Username] = true
+	}
+	results.Voters = len(voters)
+
+	return results
+}
+
+// Credits a deleted member's polls to another name, as part of the caller's
+// transaction. Their ballots keep counting, but under an anonymous voter of
+// their own rather than another member, who may have voted too.
+func ReassignUser(tx *gorm.DB, from string, to string) error {
+	// Nothing to reassign before the first poll is saved
+	if !tx.Migrator().HasTable(&Poll{}) {
+		return nil
+	}
+
+	if err := tx.Model(&Poll{}).Where("created_by = ?", from).Update("created_by", to).Error; err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(from))
+	voter := "former:" + hex.EncodeToString(sum[:6])
+	return tx.Model(&Vote{}).Where("username = ?", from).Update("username", voter).Error
+}
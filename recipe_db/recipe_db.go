@@ -0,0 +1,166 @@
+package recipe_db
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffereydecker/blazemarker/blaze_db"
+	"github.com/jeffereydecker/blazemarker/blaze_log"
+	"gorm.io/gorm"
+)
+
+var logger = blaze_log.GetLogger()
+
+// The family recipe book. Ingredients and steps are kept one per entry, in
+// order, so ingredients can be copied to a shopping list. Photo is the URL of
+// an uploaded image.
+type Recipe struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Title       string    `json:"title"`
+	Ingredients []string  `gorm:"serializer:json" json:"ingredients"`
+	Steps       []string  `gorm:"serializer:json" json:"steps"`
+	Photo       string    `json:"photo,omitempty"`
+	Tags        []string  `gorm:"serializer:json" json:"tags,omitempty"`
+	Author      string    `gorm:"index" json:"author"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+var (
+	db     *gorm.DB = nil
+	dbOnce sync.Once
+)
+
+func openDB() {
+	db = blaze_db.GetDB()
+	if db == nil {
+		return
+	}
+
+	if err := db.AutoMigrate(&Recipe{}); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func getDB() *gorm.DB {
+	dbOnce.Do(openDB)
+
+	return db
+}
+
+// Drops blank lines so a textarea can be saved as is.
+func cleanLines(lines []string) []string {
+	cleaned := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			cleaned = append(cleaned, line)
+		}
+	}
+	return cleaned
+}
+
+func validateRecipe(recipe *Recipe) error {
+	recipe.Title = strings.TrimSpace(recipe.Title)
+	if len(recipe.Title) == 0 {
+		return errors.New("recipe title can't be empty")
+	}
+	recipe.Ingredients = cleanLines(recipe.Ingredients)
+	recipe.Steps = cleanLines(recipe.Steps)
+	if photo := recipe.Photo; len(photo) > 0 && !strings.HasPrefix(photo, "/") && !strings.HasPrefix(photo, "https://") {
+		return errors.New("photo must be a site path or an https URL")
+	}
+	return nil
+}
+
+// Returns the recipes whose title, ingredients or tags contain query, or all
+// of them when it is empty, by title.
+func SearchRecipes(query string) []*Recipe {
+	recipes := make([]*Recipe, 0)
+
+	gdb := getDB()
+	if gdb == nil {
+		return recipes
+	}
+
+	tx := gdb.Order("title")
+	if query = strings.ToLower(strings.TrimSpace(query)); len(query) > 0 {
+		like := "%" + query + "%"
+		tx = tx.Where("LOWER(title) LIKE ? OR LOWER(ingredients) LIKE ? OR LOWER(tags) LIKE ?", like, like, like)
+	}
+	if err := tx.Find(&recipes).Error; err != nil {
+		logger.Error(err.Error())
+	}
+
+	return recipes
+}
+
+func GetRecipe(id uint) *Recipe {
+	gdb := getDB()
+	if gdb == nil {
+		return nil
+	}
+
+	recipe := new(Recipe)
+	if err := gdb.First(recipe, id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	return recipe
+}
+
+func CreateRecipe(recipe *Recipe) error {
+	if err := validateRecipe(recipe); err != nil {
+		return err
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("recipe database not available")
+	}
+
+	recipe.ID = 0
+	recipe.Created = time.Now()
+	recipe.Updated = recipe.Created
+
+	return gdb.Create(recipe).Error
+}
+
+func UpdateRecipe(recipe *Recipe) error {
+	if err := validateRecipe(recipe); err != nil {
+		return err
+	}
+
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("recipe database not available")
+	}
+
+	recipe.Updated = time.Now()
+
+	return gdb.Save(recipe).Error
+}
+
+func DeleteRecipe(id uint) error {
+	gdb := getDB()
+	if gdb == nil {
+		return errors.New("recipe database not available")
+	}
+
+	return gdb.Delete(&Recipe{}, id).Error
+}
+
+// Credits a deleted member's recipes to another name, as part of the
+// caller's transaction.
+func ReassignUser(tx *gorm.DB, from string, to string) error {
+	// Nothing to reassign before the first recipe is saved
+	if !tx.Migrator().HasTable(&Recipe{}) {
+		return nil
+	}
+
+	return tx.Model(&Recipe{}).Where("author = ?", from).Update("author", to).Error
+}